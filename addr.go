@@ -0,0 +1,57 @@
+package topk
+
+import (
+	"net/netip"
+
+	"github.com/dgryski/go-metro"
+)
+
+// Insert16 adds a 16 byte binary key, e.g. an IPv6 address, to the stream.
+// The key is tracked as a string of the raw bytes, which saves formatting it
+// and keeps it compact. Updating a tracked key doesn't allocate.
+// It returns an estimation for the just inserted element
+func (s *Stream) Insert16(x [16]byte, count int) Element {
+	// only positive counts, negative ones are rejected or decrement
+	if count > 0 && s.rawKeys() {
+		// the conversion in the lookup doesn't allocate, the update reuses
+		// the tracked key
+		if idx, ok := s.k.m[string(x[:])]; ok {
+			s.own()
+			key := s.k.elts[idx].Key
+			e, _ := s.insert(key, metro.Hash64Str(key, 0), count)
+			return e
+		}
+	}
+	return s.Insert(string(x[:]), count)
+}
+
+// rawKeys reports whether keys are inserted as is, without any
+// normalization or filtering
+func (s *Stream) rawKeys() bool {
+	return s.transform == nil && s.filter == nil && s.rng == nil && s.maxKeyLen == 0
+}
+
+// InsertAddr adds an IP address to the stream, IPv4 addresses are tracked
+// as IPv4-mapped IPv6 addresses. Use AddrKey to render the keys back.
+// It returns an estimation for the just inserted element
+func (s *Stream) InsertAddr(a netip.Addr, count int) Element {
+	return s.Insert16(a.As16(), count)
+}
+
+// EstimateAddr returns an estimate for the IP address a
+func (s *Stream) EstimateAddr(a netip.Addr) Element {
+	b := a.As16()
+	return s.Estimate(string(b[:]))
+}
+
+// AddrKey returns the IP address of a key inserted with InsertAddr or
+// Insert16. Any 16 byte key is taken for an address, so sketches should not
+// mix addresses with textual keys.
+func AddrKey(key string) (netip.Addr, bool) {
+	if len(key) != 16 {
+		return netip.Addr{}, false
+	}
+	var b [16]byte
+	copy(b[:], key)
+	return netip.AddrFrom16(b).Unmap(), true
+}
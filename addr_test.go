@@ -0,0 +1,48 @@
+package topk
+
+import (
+	"net/netip"
+	"testing"
+)
+
+func TestInsertAddr(t *testing.T) {
+	s := New(10)
+
+	v4 := netip.MustParseAddr("10.0.0.1")
+	v6 := netip.MustParseAddr("2001:db8::1")
+	for i := 0; i < 3; i++ {
+		s.InsertAddr(v4, 1)
+	}
+	s.InsertAddr(v6, 1)
+
+	if e := s.EstimateAddr(v4); e.Count != 3 {
+		t.Errorf("expected %v to be counted 3 times, got %v", v4, e)
+	}
+
+	top := s.Keys()
+	for i, want := range []netip.Addr{v4, v6} {
+		a, ok := AddrKey(top[i].Key)
+		if !ok || a != want {
+			t.Errorf("expected %v at %d, got %v", want, i, a)
+		}
+	}
+	if _, ok := AddrKey("10.0.0.1"); ok {
+		t.Error("expected textual key not to be an address")
+	}
+
+	// negative counts take the same checks as Insert
+	s.InsertAddr(v6, -1)
+	if e := s.EstimateAddr(v6); e.Count != 1 {
+		t.Errorf("expected the negative count to be rejected, got %v", e)
+	}
+	if s.Total() != 4 {
+		t.Errorf("expected total 4, got %d", s.Total())
+	}
+
+	allocs := testing.AllocsPerRun(100, func() {
+		s.InsertAddr(v4, 1)
+	})
+	if allocs != 0 {
+		t.Errorf("expected 0 allocs/op for a tracked address, got %v", allocs)
+	}
+}
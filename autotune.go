@@ -0,0 +1,105 @@
+package topk
+
+import (
+	"fmt"
+
+	"github.com/dgryski/go-metro"
+)
+
+// Thresholds of WithAutoTune: the stream is considered too flat for n when
+// more than tuneChurn of the inserts in a window evict a tracked element and
+// the tracked elements are overestimated by more than tuneError on average
+const (
+	tuneChurn = 0.05
+	tuneError = 0.1
+)
+
+// TuneEvent reports that n is too small for the observed key distribution,
+// see WithAutoTune
+type TuneEvent struct {
+	From, To int
+	// Churn is the fraction of inserts evicting a tracked element
+	Churn float64
+	// RelativeError is the mean of Error/Count of the tracked elements
+	RelativeError float64
+	// Applied is set if the stream was resized, it is only a recommendation
+	// otherwise
+	Applied bool
+}
+
+// tuner monitors the eviction churn over windows of inserts
+type tuner struct {
+	max       int
+	inserts   int
+	evictions int
+}
+
+// evicted records an eviction and checks the churn once the window is full
+func (s *Stream) evicted() {
+	t := s.tune
+	t.evictions++
+	if t.inserts < 10*s.n {
+		return
+	}
+
+	ev := TuneEvent{
+		From:  s.n,
+		To:    2 * s.n,
+		Churn: float64(t.evictions) / float64(t.inserts),
+	}
+	t.inserts, t.evictions = 0, 0
+	if ev.Churn <= tuneChurn {
+		return
+	}
+	for _, e := range s.k.elts {
+		if e.Count > 0 {
+			ev.RelativeError += float64(e.Error) / float64(e.Count)
+		}
+	}
+	ev.RelativeError /= float64(len(s.k.elts))
+	if ev.RelativeError <= tuneError {
+		return
+	}
+
+	if t.max > s.n {
+		if ev.To > t.max {
+			ev.To = t.max
+		}
+		// ev.To is larger than n, which is valid
+		ev.Applied = s.Resize(ev.To) == nil
+	}
+	if s.hooks != nil {
+		s.hooks.OnTune(ev)
+	}
+}
+
+// Resize changes the number of tracked elements to n. The alphas keep their
+// size, so a resized stream can only be merged with streams resized the same
+// way. Shrinking drops the smallest elements, their counts are kept in the
+// alphas. A stream has to track at least one element, it returns an error
+// for a smaller n and leaves s unchanged.
+func (s *Stream) Resize(n int) error {
+	if n < 1 {
+		return fmt.Errorf("topk: invalid size n %d", n)
+	}
+	s.own()
+	s.n = n
+	if n >= len(s.k.elts) {
+		return nil
+	}
+
+	s.restore()
+	elts := append([]Element(nil), s.k.elts...)
+	s.k.sort(elts)
+	for _, e := range elts[n:] {
+		h := reduce(metro.Hash64Str(e.Key, 0), len(s.alphas))
+		if e.Count > s.alphas[h] {
+			s.alphas[h] = e.Count
+		}
+		if s.tier != nil {
+			s.tier.add(e)
+		}
+	}
+	s.replaceElements(elts)
+	return nil
+}
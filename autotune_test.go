@@ -0,0 +1,74 @@
+package topk
+
+import (
+	"fmt"
+	"testing"
+)
+
+type tuneHooks struct {
+	NopHooks
+	events []TuneEvent
+}
+
+func (h *tuneHooks) OnTune(ev TuneEvent) { h.events = append(h.events, ev) }
+
+func TestAutoTune(t *testing.T) {
+	for _, max := range []int{80, 0} {
+		h := &tuneHooks{}
+		s := New(10, WithAutoTune(max), WithHooks(h))
+		// a flat distribution of 50 keys
+		for i := 0; i < 20000; i++ {
+			s.Insert(fmt.Sprintf("key-%d", i%50), 1)
+		}
+
+		if len(h.events) == 0 {
+			t.Fatalf("max %d: expected tune events", max)
+		}
+		ev := h.events[0]
+		if ev.From != 10 || ev.To != 20 || ev.Churn <= tuneChurn || ev.RelativeError <= tuneError {
+			t.Errorf("max %d: unexpected first event %+v", max, ev)
+		}
+		if max == 0 {
+			if ev.Applied || s.n != 10 {
+				t.Errorf("expected a recommendation only, got %+v with n %d", ev, s.n)
+			}
+			continue
+		}
+		// 80 slots hold all keys, there is no churn anymore
+		if last := h.events[len(h.events)-1]; !last.Applied || last.To != 80 || s.n != 80 {
+			t.Errorf("expected to grow to 80, got %+v with n %d", last, s.n)
+		}
+		if len(s.Keys()) != 50 {
+			t.Errorf("expected all 50 keys to be tracked, got %d", len(s.Keys()))
+		}
+	}
+}
+
+func TestResize(t *testing.T) {
+	s := New(4)
+	for i := 0; i < 4; i++ {
+		s.Insert(fmt.Sprintf("key-%d", i), i+1)
+	}
+	if err := s.Resize(0); err == nil {
+		t.Error("expected an error resizing to 0")
+	}
+	if s.Cap() != 4 || s.Len() != 4 {
+		t.Errorf("expected the failed resize to leave the stream, got cap %d len %d", s.Cap(), s.Len())
+	}
+	if err := s.Resize(2); err != nil {
+		t.Fatal(err)
+	}
+
+	keys := s.Keys()
+	if len(keys) != 2 || keys[0].Key != "key-3" || keys[1].Key != "key-2" {
+		t.Errorf("expected the 2 largest keys, got %v", keys)
+	}
+	for i := 0; i < 2; i++ {
+		if e := s.Estimate(fmt.Sprintf("key-%d", i)); e.Count < i+1 {
+			t.Errorf("expected dropped key-%d to be estimated at least %d, got %v", i, i+1, e)
+		}
+	}
+	if err := s.Merge(New(2)); err == nil {
+		t.Error("expected merging a stream with different alphas to fail")
+	}
+}
@@ -0,0 +1,41 @@
+package topk
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+// ErrIncompatibleSketch is returned when sketches of different engines or
+// sizes are merged
+var ErrIncompatibleSketch = errors.New("topk: incompatible sketches")
+
+// incompatible returns an error wrapping ErrIncompatibleSketch
+func incompatible(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: "+format, append([]interface{}{ErrIncompatibleSketch}, args...)...)
+}
+
+// New10 returns a Stream estimating the top 10 most frequent elements
+func New10() *Stream { return New(10) }
+
+// New100 returns a Stream estimating the top 100 most frequent elements
+func New100() *Stream { return New(100) }
+
+// New1000 returns a Stream estimating the top 1000 most frequent elements
+func New1000() *Stream { return New(1000) }
+
+// MarshalBinary implements the encoding.BinaryMarshaler interface, it
+// returns the encoding written by Encode
+func (s *Stream) MarshalBinary() ([]byte, error) {
+	buf := bytes.NewBuffer(nil)
+	if err := s.Encode(buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary implements the encoding.BinaryUnmarshaler interface, it
+// decodes data written by Encode or MarshalBinary
+func (s *Stream) UnmarshalBinary(data []byte) error {
+	return s.Decode(bytes.NewReader(data))
+}
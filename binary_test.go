@@ -0,0 +1,129 @@
+package topk
+
+import (
+	"bytes"
+	"encoding"
+	"errors"
+	"testing"
+)
+
+var (
+	_ encoding.BinaryMarshaler   = (*Stream)(nil)
+	_ encoding.BinaryUnmarshaler = (*Stream)(nil)
+)
+
+func TestMarshalBinary(t *testing.T) {
+	s := New100()
+	s.Insert("a", 3)
+	s.Insert("b", 1)
+
+	data, err := s.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := New100()
+	if err := got.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	if !got.Equal(s) {
+		t.Errorf("unmarshaled stream differs: %v != %v", got, s)
+	}
+
+	for _, err := range []error{
+		New10().Merge(New1000()),
+		New10().UnmarshalBinary(data),
+		New10().MergeSketch(NewMisraGries(10)),
+		NewMisraGries(10).Merge(NewMisraGries(20)),
+	} {
+		if !errors.Is(err, ErrIncompatibleSketch) {
+			t.Errorf("expected ErrIncompatibleSketch, got %v", err)
+		}
+	}
+}
+
+func TestMergeDecode(t *testing.T) {
+	s := New10()
+	s.Insert("a", 3)
+	data, err := s.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.MergeDecode(bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	if e := s.Estimate("a"); e.Count != 6 {
+		t.Errorf("expected merged count 6, got %v", e)
+	}
+	if err := New100().MergeDecode(bytes.NewReader(data)); !errors.Is(err, ErrIncompatibleSketch) {
+		t.Errorf("expected ErrIncompatibleSketch, got %v", err)
+	}
+
+	// a stream hashing keys with another seed
+	foreign := bytes.Replace(data, []byte("seed\x00"), []byte("seed\x01"), 1)
+	if bytes.Equal(foreign, data) {
+		t.Fatal("seed field not found")
+	}
+	if err := s.MergeDecode(bytes.NewReader(foreign)); !errors.Is(err, ErrIncompatibleSketch) {
+		t.Errorf("expected ErrIncompatibleSketch, got %v", err)
+	}
+}
+
+func TestDecodeKeepsReceiver(t *testing.T) {
+	s := New(4)
+	s.Insert("keep", 7)
+	other := New(8)
+	other.Insert("o", 1)
+	data, err := other.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.UnmarshalBinary(data); !errors.Is(err, ErrIncompatibleSketch) {
+		t.Fatalf("expected ErrIncompatibleSketch, got %v", err)
+	}
+	if s.n != 4 || s.Estimate("keep").Count != 7 || s.Len() != 1 {
+		t.Errorf("expected the receiver unchanged, got %v", s.Keys())
+	}
+}
+
+func TestCompactHash(t *testing.T) {
+	s := New10()
+	s.Insert("a", 3)
+	buf := bytes.NewBuffer(nil)
+	if err := s.EncodeCompact(buf); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	// the hash name and seed follow the flags
+	foreign := append([]byte(nil), data...)
+	i := 3 + 1 + len(hashName)
+	if foreign[i] != hashSeed {
+		t.Fatalf("seed not found at %d", i)
+	}
+	foreign[i] = 1
+
+	if err := New10().Decode(bytes.NewReader(foreign)); !errors.Is(err, ErrIncompatibleSketch) {
+		t.Errorf("Decode: expected ErrIncompatibleSketch, got %v", err)
+	}
+	if _, err := NewBytesView(foreign); !errors.Is(err, ErrIncompatibleSketch) {
+		t.Errorf("NewBytesView: expected ErrIncompatibleSketch, got %v", err)
+	}
+	if _, err := OpenLazy(foreign); !errors.Is(err, ErrIncompatibleSketch) {
+		t.Errorf("OpenLazy: expected ErrIncompatibleSketch, got %v", err)
+	}
+	if err := New10().MergeEncoded(foreign); !errors.Is(err, ErrIncompatibleSketch) {
+		t.Errorf("MergeEncoded: expected ErrIncompatibleSketch, got %v", err)
+	}
+
+	// encodings without the hash aren't checked for it
+	legacy := append([]byte{compactMagic, encodingVersion, data[2] &^ compactHash}, data[i+1:]...)
+	got := New10()
+	if err := got.Decode(bytes.NewReader(legacy)); err != nil {
+		t.Fatal(err)
+	}
+	if e := got.Estimate("a"); e.Count != 3 {
+		t.Errorf("expected a counted 3 times, got %v", e)
+	}
+}
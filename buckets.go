@@ -0,0 +1,89 @@
+package topk
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Buckets maps numeric observations to labeled ranges, so a Stream can track
+// the most frequent ranges, e.g. of latencies or sizes.
+//
+// Bucket i covers [bounds[i-1], bounds[i]), the first and last bucket are
+// open towards -Inf and +Inf. Labels look like "[10,20)" and can be parsed
+// back with ParseBucket.
+type Buckets struct {
+	bounds []float64
+	labels []string
+}
+
+// NewBuckets returns Buckets with the given bounds, which have to be strictly
+// increasing
+func NewBuckets(bounds ...float64) (*Buckets, error) {
+	for i := 1; i < len(bounds); i++ {
+		if !(bounds[i] > bounds[i-1]) {
+			return nil, fmt.Errorf("topk: bounds not strictly increasing at %d: %v <= %v", i, bounds[i], bounds[i-1])
+		}
+	}
+
+	b := &Buckets{
+		bounds: append([]float64(nil), bounds...),
+		labels: make([]string, len(bounds)+1),
+	}
+	lo := math.Inf(-1)
+	for i := range b.labels {
+		hi := math.Inf(1)
+		if i < len(bounds) {
+			hi = bounds[i]
+		}
+		b.labels[i] = "[" + formatBound(lo) + "," + formatBound(hi) + ")"
+		lo = hi
+	}
+	return b, nil
+}
+
+// ExponentialBuckets returns count bounds starting at start, each one factor
+// times the previous one
+func ExponentialBuckets(start, factor float64, count int) (*Buckets, error) {
+	bounds := make([]float64, count)
+	for i := range bounds {
+		bounds[i] = start
+		start *= factor
+	}
+	return NewBuckets(bounds...)
+}
+
+func formatBound(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// Label returns the label of the bucket v falls into
+func (b *Buckets) Label(v float64) string {
+	i := sort.Search(len(b.bounds), func(i int) bool { return b.bounds[i] > v })
+	return b.labels[i]
+}
+
+// Insert adds the bucket of v to s with the given count
+func (b *Buckets) Insert(s *Stream, v float64, count int) Element {
+	return s.Insert(b.Label(v), count)
+}
+
+// ParseBucket returns the range [lo, hi) of a bucket label
+func ParseBucket(label string) (lo, hi float64, err error) {
+	if !strings.HasPrefix(label, "[") || !strings.HasSuffix(label, ")") {
+		return 0, 0, fmt.Errorf("topk: malformed bucket label %q", label)
+	}
+	parts := strings.Split(label[1:len(label)-1], ",")
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("topk: malformed bucket label %q", label)
+	}
+	if lo, err = strconv.ParseFloat(parts[0], 64); err != nil {
+		return 0, 0, err
+	}
+	if hi, err = strconv.ParseFloat(parts[1], 64); err != nil {
+		return 0, 0, err
+	}
+	return lo, hi, nil
+}
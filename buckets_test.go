@@ -0,0 +1,52 @@
+package topk
+
+import (
+	"math"
+	"testing"
+)
+
+func TestBuckets(t *testing.T) {
+	b, err := ExponentialBuckets(10, 2, 4) // 10, 20, 40, 80
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		v     float64
+		label string
+	}{
+		{-1, "[-Inf,10)"},
+		{10, "[10,20)"},
+		{19.9, "[10,20)"},
+		{45, "[40,80)"},
+		{80, "[80,+Inf)"},
+	}
+	for _, c := range cases {
+		if got := b.Label(c.v); got != c.label {
+			t.Errorf("%v: got %s, want %s", c.v, got, c.label)
+		}
+	}
+
+	s := New(10)
+	for _, v := range []float64{12, 15, 18, 50, 100} {
+		b.Insert(s, v, 1)
+	}
+	top := s.Keys()[0]
+	lo, hi, err := ParseBucket(top.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lo != 10 || hi != 20 || top.Count != 3 {
+		t.Errorf("expected [10,20) 3 times on top, got [%v,%v) %d times", lo, hi, top.Count)
+	}
+
+	if lo, hi, err := ParseBucket("[80,+Inf)"); err != nil || lo != 80 || !math.IsInf(hi, 1) {
+		t.Errorf("unexpected parse of open bucket: %v %v %v", lo, hi, err)
+	}
+	if _, _, err := ParseBucket("10-20"); err == nil {
+		t.Error("expected malformed label to fail")
+	}
+	if _, err := NewBuckets(1, 1); err == nil {
+		t.Error("expected non-increasing bounds to fail")
+	}
+}
@@ -0,0 +1,152 @@
+package topk
+
+import (
+	"encoding/binary"
+	"errors"
+	"sort"
+	"unsafe"
+
+	"github.com/dgryski/go-metro"
+)
+
+var (
+	errShortBuffer = errors.New("topk: short buffer")
+	errNoAlphas    = errors.New("topk: corrupt encoding: no alphas in stream")
+)
+
+// BytesView is a read-only view over a stream in the compact encoding, see
+// EncodeCompact. Its keys reference the buffer instead of being copied, so
+// opening a view only allocates the elements and alphas.
+//
+// The buffer must not be modified while the view or any key it returned is
+// in use.
+type BytesView struct {
+	n      int
+	alphas []int
+	elts   []Element
+}
+
+// NewBytesView returns a view over b, which has to hold a stream written by
+// EncodeCompact.
+func NewBytesView(b []byte) (*BytesView, error) {
+	_, br, n, err := openCompact(b)
+	if err != nil {
+		return nil, err
+	}
+	v := &BytesView{n: n}
+	// sizes beyond the int range wrap negative
+	sz := br.uvarint()
+	if sz < 0 || sz > len(br.b) {
+		// every alpha takes at least a byte
+		return nil, errShortBuffer
+	}
+	if sz == 0 {
+		return nil, errNoAlphas
+	}
+	v.alphas = make([]int, sz)
+	for i := range v.alphas {
+		v.alphas[i] = br.varint()
+	}
+	if sz = br.uvarint(); sz < 0 || sz > len(br.b) {
+		return nil, errShortBuffer
+	}
+	v.elts = make([]Element, sz)
+	for i := range v.elts {
+		e := &v.elts[i]
+		e.Key = br.string(br.uvarint())
+		e.Count = br.varint()
+		e.Error = br.varint()
+	}
+	if br.err != nil {
+		return nil, br.err
+	}
+	return v, nil
+}
+
+// Keys returns the estimates for the most frequent elements
+func (v *BytesView) Keys() []Element {
+	elts := append([]Element(nil), v.elts...)
+	sort.Sort(elementsByCountDescending(elts))
+	if len(elts) > v.n {
+		elts = elts[:v.n]
+	}
+	return elts
+}
+
+// Estimate returns an estimate for the item x
+func (v *BytesView) Estimate(x string) Element {
+	// a linear scan is cheaper than indexing a view queried only a few times
+	for _, e := range v.elts {
+		if e.Key == x {
+			return e
+		}
+	}
+	count := v.alphas[reduce(metro.Hash64Str(x, 0), len(v.alphas))]
+	return Element{Key: x, Count: count, Error: count}
+}
+
+// bytesReader reads the values of the compact encoding from a buffer,
+// keeping the first error
+type bytesReader struct {
+	b   []byte
+	err error
+}
+
+func (br *bytesReader) uvarint() int {
+	if br.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(br.b)
+	if n <= 0 {
+		br.err = errShortBuffer
+		return 0
+	}
+	br.b = br.b[n:]
+	return int(v)
+}
+
+func (br *bytesReader) varint() int {
+	if br.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(br.b)
+	if n <= 0 {
+		br.err = errShortBuffer
+		return 0
+	}
+	br.b = br.b[n:]
+	return int(v)
+}
+
+// hash reads and checks the hash of an encoding flagged by compactHash
+func (br *bytesReader) hash() error {
+	name := br.string(br.uvarint())
+	seed := br.uvarint()
+	if br.err != nil {
+		return br.err
+	}
+	return checkHash(name, uint64(seed))
+}
+
+// raw returns the next n bytes of the buffer
+func (br *bytesReader) raw(n int) []byte {
+	if br.err != nil {
+		return nil
+	}
+	if n < 0 || n > len(br.b) {
+		br.err = errShortBuffer
+		return nil
+	}
+	b := br.b[:n:n]
+	br.b = br.b[n:]
+	return b
+}
+
+// string returns the next n bytes as a string sharing the buffer
+func (br *bytesReader) string(n int) string {
+	b := br.raw(n)
+	if len(b) == 0 {
+		return ""
+	}
+	return unsafe.String(&b[0], len(b))
+}
@@ -0,0 +1,50 @@
+package topk
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+func TestBytesView(t *testing.T) {
+	s := New(100)
+	for _, w := range loadWords() {
+		s.Insert(w, 1)
+	}
+
+	buf := bytes.NewBuffer(nil)
+	if err := s.EncodeCompact(buf); err != nil {
+		t.Fatal(err)
+	}
+	b := buf.Bytes()
+
+	v, err := NewBytesView(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(v.Keys(), s.Keys()) {
+		t.Errorf("keys differ: %v != %v", v.Keys(), s.Keys())
+	}
+	for _, x := range []string{s.Keys()[0].Key, "not a word"} {
+		if got, want := v.Estimate(x), s.Estimate(x); got != want {
+			t.Errorf("estimate of %q differs: %v != %v", x, got, want)
+		}
+	}
+
+	header := []byte{compactMagic, encodingVersion, 0, 10}
+	huge := binary.AppendUvarint(nil, 1<<63)
+	for _, b := range [][]byte{
+		nil, b[:len(b)/2], {0x90},
+		append(header, huge...),
+		append(header, 0, 0),
+		append(append(header, 1, 0), huge...),
+		// n out of range, with a valid alpha and element count
+		append(append([]byte{compactMagic, encodingVersion, 0}, huge...), 1, 0, 0),
+		{compactMagic, encodingVersion, 0, 0, 1, 0, 0},
+	} {
+		if _, err := NewBytesView(b); err == nil {
+			t.Errorf("expected an error for %x", b)
+		}
+	}
+}
@@ -0,0 +1,276 @@
+package topk
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// compactMagic starts the compact encoding, it is never used by msgpack so
+// Decode tells both encodings apart by the first byte
+const compactMagic = 0xc1
+
+// flags of the compact encoding
+const (
+	compactDistinct      = 1 << iota // followed by the distinct registers
+	compactProbabilistic             // see WithProbabilisticUpdate
+	compactInheritMin                // see InheritMinReplacement
+	compactPromotion                 // followed by the threshold, see WithPromotion
+	compactLabels                    // followed by the labels, see WithLabels
+	compactHash                      // preceded by the hash name and seed
+)
+
+// EncodeCompact writes the stream in a minimal binary encoding: varint
+// counts and length prefixed keys without any per field framing. It is meant
+// for embedding large numbers of small sketches, Decode detects it
+// automatically.
+//
+// The encoding is the magic byte 0xc1, the format version, a flags byte, the
+// name and seed of the key hash, n, the alphas, the elements as key, count
+// and error, the total, inserts, admissions and evictions, followed by the
+// optional fields of the flags. Encodings without the compactHash flag lack
+// the hash and aren't checked for it.
+func (s *Stream) EncodeCompact(w io.Writer) error {
+	s.Consolidate()
+
+	flags := byte(compactHash)
+	if s.distinct != nil {
+		flags |= compactDistinct
+	}
+	if s.probabilistic {
+		flags |= compactProbabilistic
+	}
+	if s.replacement == InheritMinReplacement {
+		flags |= compactInheritMin
+	}
+	if s.promotion > 0 {
+		flags |= compactPromotion
+	}
+	if len(s.labels) > 0 {
+		flags |= compactLabels
+	}
+
+	b := make([]byte, 0, 16+len(s.alphas)+len(s.k.elts)*16)
+	b = append(b, compactMagic, encodingVersion, flags)
+	b = binary.AppendUvarint(b, uint64(len(hashName)))
+	b = append(b, hashName...)
+	b = binary.AppendUvarint(b, hashSeed)
+	b = binary.AppendUvarint(b, uint64(s.n))
+	b = binary.AppendUvarint(b, uint64(len(s.alphas)))
+	for _, a := range s.alphas {
+		b = binary.AppendVarint(b, int64(a))
+	}
+	b = binary.AppendUvarint(b, uint64(len(s.k.elts)))
+	for _, e := range s.k.elts {
+		b = binary.AppendUvarint(b, uint64(len(e.Key)))
+		b = append(b, e.Key...)
+		b = binary.AppendVarint(b, int64(e.Count))
+		b = binary.AppendVarint(b, int64(e.Error))
+	}
+	for _, v := range []int{s.total, s.inserts, s.admissions, s.stats.Evictions} {
+		b = binary.AppendVarint(b, int64(v))
+	}
+	if s.distinct != nil {
+		b = append(b, s.distinct.regs...)
+	}
+	if s.promotion > 0 {
+		b = binary.AppendUvarint(b, uint64(s.promotion))
+	}
+	if len(s.labels) > 0 {
+		b = s.appendLabels(b)
+	}
+
+	_, err := w.Write(b)
+	return err
+}
+
+// isCompact reports whether r starts with the compact encoding
+func isCompact(r *bufio.Reader) bool {
+	b, err := r.Peek(1)
+	return err == nil && b[0] == compactMagic
+}
+
+// checkHash returns ErrIncompatibleSketch unless the keys of an encoding were
+// hashed like those of this package
+func checkHash(name string, seed uint64) error {
+	if name != hashName {
+		return incompatible("expected keys hashed by %s, got %s", hashName, name)
+	}
+	if seed != hashSeed {
+		return incompatible("expected hash seed %d, got %d", hashSeed, seed)
+	}
+	return nil
+}
+
+// checkCompactHeader checks the magic byte and the format version at the
+// start of a compact encoding
+func checkCompactHeader(header []byte) error {
+	if len(header) < 3 {
+		return errShortBuffer
+	}
+	if header[0] != compactMagic {
+		return fmt.Errorf("%w %#x", ErrUnexpectedMagic, header[0])
+	}
+	return checkVersion(int(header[1]))
+}
+
+// openCompact checks the header of the compact encoding in b and reads n. It
+// returns the flags and a reader positioned at the alphas.
+func openCompact(b []byte) (flags byte, br bytesReader, n int, err error) {
+	if err := checkCompactHeader(b); err != nil {
+		return 0, br, 0, err
+	}
+	flags = b[2]
+	br = bytesReader{b: b[3:]}
+	if flags&compactHash != 0 {
+		if err := br.hash(); err != nil {
+			return 0, br, 0, err
+		}
+	}
+	// sizes beyond the int range wrap negative
+	if n = br.uvarint(); br.err == nil && (n <= 0 || n > maxDecodeLen) {
+		return 0, br, 0, fmt.Errorf("topk: corrupt encoding: size n %d out of range", n)
+	}
+	return flags, br, n, br.err
+}
+
+// compactReader reads the values of the compact encoding, keeping the first
+// error
+type compactReader struct {
+	r   *bufio.Reader
+	err error
+}
+
+func (cr *compactReader) uvarint() int {
+	if cr.err != nil {
+		return 0
+	}
+	v, err := binary.ReadUvarint(cr.r)
+	cr.err = err
+	return int(v)
+}
+
+func (cr *compactReader) varint() int {
+	if cr.err != nil {
+		return 0
+	}
+	v, err := binary.ReadVarint(cr.r)
+	cr.err = err
+	return int(v)
+}
+
+func (cr *compactReader) bytes(n int) []byte {
+	if cr.err != nil {
+		return nil
+	}
+	var b []byte
+	b, cr.err = readN(cr.r, n)
+	return b
+}
+
+// hash reads and checks the hash of an encoding flagged by compactHash
+func (cr *compactReader) hash() error {
+	name := cr.bytes(cr.uvarint())
+	seed := cr.uvarint()
+	if cr.err != nil {
+		return cr.err
+	}
+	return checkHash(string(name), uint64(seed))
+}
+
+// len reads a size and returns it with the capacity to preallocate for it
+func (cr *compactReader) len() (int, int) {
+	sz := cr.uvarint()
+	if cr.err != nil {
+		return 0, 0
+	}
+	hint, err := decodeLen(sz)
+	cr.err = err
+	return sz, hint
+}
+
+func (s *Stream) decodeCompact(ctx context.Context, r *bufio.Reader) error {
+	s.resetDecoded()
+
+	cr := &compactReader{r: r}
+	header := cr.bytes(3)
+	if cr.err != nil {
+		return cr.err
+	}
+	if err := checkCompactHeader(header); err != nil {
+		return err
+	}
+	flags := header[2]
+	if flags&compactHash != 0 {
+		if err := cr.hash(); err != nil {
+			return err
+		}
+	}
+
+	s.n = cr.uvarint()
+	sz, hint := cr.len()
+	s.alphas = make([]int, 0, hint)
+	for i := 0; i < sz && cr.err == nil; i++ {
+		if err := canceled(ctx, i); err != nil {
+			return err
+		}
+		s.alphas = append(s.alphas, cr.varint())
+	}
+
+	sz, hint = cr.len()
+	if cr.err != nil {
+		return cr.err
+	}
+	s.k.m = make(map[string]int, hint)
+	s.k.elts = make([]Element, 0, hint)
+	for i := 0; i < sz && cr.err == nil; i++ {
+		if err := canceled(ctx, i); err != nil {
+			return err
+		}
+		var e Element
+		e.Key = string(cr.bytes(cr.uvarint()))
+		e.Count = cr.varint()
+		e.Error = cr.varint()
+		s.k.m[e.Key] = i
+		s.k.elts = append(s.k.elts, e)
+	}
+	if cr.err != nil {
+		return cr.err
+	}
+	if err := s.checkDecoded(); err != nil {
+		return err
+	}
+	s.internKeys()
+	if s.k.score != nil || s.k.stable {
+		// the encoder may have ordered the heap by another score
+		s.k.init()
+	}
+
+	s.total = cr.varint()
+	s.inserts = cr.varint()
+	s.admissions = cr.varint()
+	s.stats.Evictions = cr.varint()
+	if flags&compactDistinct != 0 {
+		if regs := cr.bytes(1 << hllPrecision); cr.err == nil {
+			s.distinct = &hll{regs: regs}
+		}
+	}
+	if flags&compactPromotion != 0 {
+		s.promotion = cr.uvarint()
+	}
+	if flags&compactLabels != 0 {
+		sz, hint := cr.len()
+		s.labels = make(map[string]string, hint)
+		for i := 0; i < sz && cr.err == nil; i++ {
+			key := string(cr.bytes(cr.uvarint()))
+			s.labels[key] = string(cr.bytes(cr.uvarint()))
+		}
+	}
+	s.probabilistic = flags&compactProbabilistic != 0
+	if flags&compactInheritMin != 0 {
+		s.replacement = InheritMinReplacement
+	}
+	return cr.err
+}
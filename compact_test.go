@@ -0,0 +1,54 @@
+package topk
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestEncodeCompact(t *testing.T) {
+	words := loadWords()
+	s := New(100, WithDistinct(), WithProbabilisticUpdate())
+	for _, w := range words {
+		s.Insert(w, 1)
+	}
+
+	compact, msgp := bytes.NewBuffer(nil), bytes.NewBuffer(nil)
+	if err := s.EncodeCompact(compact); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Encode(msgp); err != nil {
+		t.Fatal(err)
+	}
+	if compact.Len() >= msgp.Len() {
+		t.Errorf("expected the compact encoding to be smaller, got %d >= %d bytes", compact.Len(), msgp.Len())
+	}
+	enc := compact.Bytes()
+
+	decoded := New(100)
+	if err := decoded.Decode(bytes.NewReader(enc)); err != nil {
+		t.Fatal(err)
+	}
+	if !decoded.Equal(s) || decoded.Inserts() != s.Inserts() || !decoded.probabilistic {
+		t.Error("decoded stream differs")
+	}
+	if n, ok := decoded.Distinct(); !ok || n == 0 {
+		t.Errorf("expected the distinct count to survive, got %d", n)
+	}
+	if err := New(100).Decode(bytes.NewReader(enc[:len(enc)/2])); err == nil {
+		t.Error("expected an error decoding a truncated encoding")
+	}
+
+	// compact and msgp encodings merge alike
+	merged, err := MergeEncodedAll([]io.Reader{bytes.NewReader(enc), bytes.NewReader(msgp.Bytes())})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := s.clone()
+	if err := want.Merge(s); err != nil {
+		t.Fatal(err)
+	}
+	if !merged.Equal(want) {
+		t.Error("merged compact encoding differs")
+	}
+}
@@ -0,0 +1,73 @@
+package topk
+
+import "sort"
+
+// Change describes how the estimate of a key changed from one sketch to
+// another
+type Change struct {
+	Key      string  `json:"key"`
+	Previous Element `json:"previous"`
+	Current  Element `json:"current"`
+
+	// Delta is the change of the estimated count, the true change lies within
+	// [MinDelta, MaxDelta] given the error bounds of both estimates
+	Delta    int `json:"delta"`
+	MinDelta int `json:"min_delta"`
+	MaxDelta int `json:"max_delta"`
+}
+
+func newChange(key string, prev, cur Element) Change {
+	return Change{
+		Key:      key,
+		Previous: prev,
+		Current:  cur,
+		Delta:    cur.Count - prev.Count,
+		MinDelta: cur.LowerBound() - prev.UpperBound(),
+		MaxDelta: cur.UpperBound() - prev.LowerBound(),
+	}
+}
+
+// Report lists the differences between the top elements of two sketches
+type Report struct {
+	// Entrants are tracked by the current sketch only, by descending
+	// current count
+	Entrants []Change `json:"entrants"`
+	// Dropped are tracked by the previous sketch only, by descending previous
+	// count
+	Dropped []Change `json:"dropped"`
+	// Movers are tracked by both sketches, by descending absolute delta
+	Movers []Change `json:"movers"`
+}
+
+// Compare reports how the top elements of cur differ from those of prev,
+// typically the sketches of the current and the previous time window. Keys
+// not tracked by one of the sketches are estimated from its alphas.
+func Compare(prev, cur *Stream) Report {
+	var r Report
+	for _, e := range cur.Keys() {
+		p := prev.Estimate(e.Key)
+		if _, ok := prev.k.m[p.Key]; ok {
+			r.Movers = append(r.Movers, newChange(e.Key, p, e))
+		} else {
+			r.Entrants = append(r.Entrants, newChange(e.Key, p, e))
+		}
+	}
+	for _, e := range prev.Keys() {
+		c := cur.Estimate(e.Key)
+		if _, ok := cur.k.m[c.Key]; !ok {
+			r.Dropped = append(r.Dropped, newChange(e.Key, e, c))
+		}
+	}
+
+	sort.SliceStable(r.Movers, func(i, j int) bool {
+		return abs(r.Movers[i].Delta) > abs(r.Movers[j].Delta)
+	})
+	return r
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
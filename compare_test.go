@@ -0,0 +1,33 @@
+package topk
+
+import (
+	"testing"
+)
+
+func TestCompare(t *testing.T) {
+	prev, cur := New(3), New(3)
+	prev.Insert("a", 10)
+	prev.Insert("b", 8)
+	prev.Insert("c", 5)
+
+	cur.Insert("a", 11)
+	cur.Insert("b", 2)
+	cur.Insert("d", 7)
+
+	r := Compare(prev, cur)
+
+	if len(r.Entrants) != 1 || r.Entrants[0].Key != "d" || r.Entrants[0].Delta != 7 {
+		t.Errorf("unexpected entrants %+v", r.Entrants)
+	}
+	if len(r.Dropped) != 1 || r.Dropped[0].Key != "c" || r.Dropped[0].Delta != -5 {
+		t.Errorf("unexpected dropped %+v", r.Dropped)
+	}
+	if len(r.Movers) != 2 || r.Movers[0].Key != "b" || r.Movers[0].Delta != -6 || r.Movers[1].Key != "a" {
+		t.Errorf("unexpected movers %+v", r.Movers)
+	}
+	for _, c := range append(append(r.Entrants, r.Dropped...), r.Movers...) {
+		if c.MinDelta > c.Delta || c.MaxDelta < c.Delta {
+			t.Errorf("delta %d outside of its bounds [%d, %d]", c.Delta, c.MinDelta, c.MaxDelta)
+		}
+	}
+}
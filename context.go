@@ -0,0 +1,15 @@
+package topk
+
+import "context"
+
+// checkEvery is the number of elements processed between checks of the
+// context in long running operations
+const checkEvery = 1024
+
+// canceled returns the context's error every checkEvery iterations i
+func canceled(ctx context.Context, i int) error {
+	if i%checkEvery != 0 {
+		return nil
+	}
+	return ctx.Err()
+}
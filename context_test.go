@@ -0,0 +1,49 @@
+package topk
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"testing"
+)
+
+func TestContext(t *testing.T) {
+	s := New(2000)
+	for i := 0; i < 5000; i++ {
+		s.Insert(fmt.Sprintf("key-%d", i), 1)
+	}
+	buf := bytes.NewBuffer(nil)
+	if err := s.Encode(buf); err != nil {
+		t.Fatal(err)
+	}
+	enc := buf.Bytes()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := New(2000).DecodeContext(ctx, bytes.NewReader(enc)); err != context.Canceled {
+		t.Errorf("expected decode to be canceled, got %v", err)
+	}
+	if _, err := MergeEncodedAllContext(ctx, []io.Reader{bytes.NewReader(enc)}); err != context.Canceled {
+		t.Errorf("expected merge of encoded sketches to be canceled, got %v", err)
+	}
+
+	other := New(2000)
+	other.Insert("other", 1)
+	before := s.clone()
+	if err := s.MergeContext(ctx, other); err != context.Canceled {
+		t.Errorf("expected merge to be canceled, got %v", err)
+	}
+	if !s.Equal(before) {
+		t.Error("canceled merge modified the stream")
+	}
+
+	if err := s.MergeContext(context.Background(), other); err != nil {
+		t.Fatal(err)
+	}
+	decoded := New(2000)
+	if err := decoded.DecodeContext(context.Background(), bytes.NewReader(enc)); err != nil {
+		t.Fatal(err)
+	}
+}
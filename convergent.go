@@ -0,0 +1,168 @@
+package topk
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/tinylib/msgp/msgp"
+)
+
+const convergentMagic = "topk/crdt"
+
+// Convergent aggregates streams for gossip protocols, where the same state
+// may arrive along different paths and be merged any number of times.
+//
+// It keeps the most advanced stream of every origin, the one with the
+// largest total, instead of adding them up. Merging is commutative and
+// idempotent: merging a Convergent twice or receiving an outdated state of an
+// origin leaves it unchanged. Each origin must only ever grow its own stream.
+type Convergent struct {
+	n       int
+	origins map[string]*Stream
+}
+
+// NewConvergent returns a Convergent of streams of size n
+func NewConvergent(n int) *Convergent {
+	return &Convergent{n: n, origins: make(map[string]*Stream)}
+}
+
+// Update records s as the state of origin unless a more advanced one is
+// known. s is copied.
+func (c *Convergent) Update(origin string, s *Stream) error {
+	if s.n != c.n {
+		return incompatible("expected stream of size n %d, got %d", c.n, s.n)
+	}
+	if cur, ok := c.origins[origin]; ok {
+		if len(cur.alphas) != len(s.alphas) {
+			return incompatible("expected %d alphas, got %d", len(cur.alphas), len(s.alphas))
+		}
+		if !ahead(s, cur) {
+			return nil
+		}
+	}
+	c.origins[origin] = s.clone()
+	return nil
+}
+
+// ahead reports whether a is a later state of an origin than b
+func ahead(a, b *Stream) bool {
+	if a.total != b.total {
+		return a.total > b.total
+	}
+	return a.inserts > b.inserts
+}
+
+// Merge merges the origins of other into c
+func (c *Convergent) Merge(other *Convergent) error {
+	if c.n != other.n {
+		return incompatible("expected stream of size n %d, got %d", c.n, other.n)
+	}
+	for origin, s := range other.origins {
+		if err := c.Update(origin, s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Origins returns the known origins in order
+func (c *Convergent) Origins() []string {
+	origins := make([]string, 0, len(c.origins))
+	for origin := range c.origins {
+		origins = append(origins, origin)
+	}
+	sort.Strings(origins)
+	return origins
+}
+
+// Stream returns the merge of the latest streams of all origins
+func (c *Convergent) Stream() (*Stream, error) {
+	s := New(c.n)
+	for i, origin := range c.Origins() {
+		o := c.origins[origin]
+		if i == 0 {
+			// the alphas of the origins may be sized differently than New's
+			s = o.clone()
+			continue
+		}
+		if err := s.Merge(o); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// Encode writes the streams of all origins to w
+func (c *Convergent) Encode(w io.Writer) error {
+	wrt := msgp.NewWriter(w)
+	if err := encodeHeader(wrt, convergentMagic); err != nil {
+		return err
+	}
+	if err := wrt.WriteInt(c.n); err != nil {
+		return err
+	}
+	origins := c.Origins()
+	if err := wrt.WriteArrayHeader(uint32(len(origins))); err != nil {
+		return err
+	}
+	buf := bytes.NewBuffer(nil)
+	for _, origin := range origins {
+		buf.Reset()
+		if err := c.origins[origin].encode(buf); err != nil {
+			return err
+		}
+		if err := wrt.WriteString(origin); err != nil {
+			return err
+		}
+		if err := wrt.WriteBytes(buf.Bytes()); err != nil {
+			return err
+		}
+	}
+	return wrt.Flush()
+}
+
+// Decode replaces c with the Convergent read from r. c is left unchanged on
+// errors.
+func (c *Convergent) Decode(r io.Reader) error {
+	rdr := msgp.NewReader(r)
+	if err := expectHeader(rdr, convergentMagic); err != nil {
+		return err
+	}
+	n, err := rdr.ReadInt()
+	if err != nil {
+		return err
+	}
+	if n < 1 || n > maxDecodeLen {
+		return fmt.Errorf("topk: corrupt encoding: size n %d out of range", n)
+	}
+	sz, err := rdr.ReadArrayHeader()
+	if err != nil {
+		return err
+	}
+	if _, err := decodeLen(int(sz)); err != nil {
+		return err
+	}
+	d := NewConvergent(n)
+	for i := uint32(0); i < sz; i++ {
+		origin, err := readString(rdr)
+		if err != nil {
+			return err
+		}
+		b, err := readBytes(rdr)
+		if err != nil {
+			return err
+		}
+		s := &Stream{}
+		if err := s.Decode(bytes.NewReader(b)); err != nil {
+			return err
+		}
+		if s.n != n {
+			return incompatible("expected stream of size n %d, got %d", n, s.n)
+		}
+		d.origins[origin] = s
+	}
+	*c = *d
+	return nil
+}
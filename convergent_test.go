@@ -0,0 +1,94 @@
+package topk
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/tinylib/msgp/msgp"
+)
+
+func TestConvergent(t *testing.T) {
+	a, b := New(10), New(10)
+	a.Insert("x", 3)
+	b.Insert("x", 2)
+	b.Insert("y", 1)
+
+	c1, c2 := NewConvergent(10), NewConvergent(10)
+	if err := c1.Update("a", a); err != nil {
+		t.Fatal(err)
+	}
+	if err := c2.Update("b", b); err != nil {
+		t.Fatal(err)
+	}
+	// gossip the states back and forth, repeatedly
+	for i := 0; i < 3; i++ {
+		if err := c1.Merge(c2); err != nil {
+			t.Fatal(err)
+		}
+		if err := c2.Merge(c1); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, c := range []*Convergent{c1, c2} {
+		s, err := c.Stream()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e := s.Estimate("x"); e.Count != 5 {
+			t.Errorf("expected count 5, got %v", e)
+		}
+	}
+
+	// an outdated state of a is ignored, a later one replaces it
+	stale := a.clone()
+	a.Insert("x", 1)
+	if err := c1.Update("a", a); err != nil {
+		t.Fatal(err)
+	}
+	if err := c1.Update("a", stale); err != nil {
+		t.Fatal(err)
+	}
+	if s, _ := c1.Stream(); s.Estimate("x").Count != 6 {
+		t.Errorf("expected count 6, got %v", s.Estimate("x"))
+	}
+
+	buf := bytes.NewBuffer(nil)
+	if err := c1.Encode(buf); err != nil {
+		t.Fatal(err)
+	}
+	enc := buf.Bytes()
+	decoded := &Convergent{}
+	if err := decoded.Decode(bytes.NewReader(enc)); err != nil {
+		t.Fatal(err)
+	}
+
+	// truncated input leaves the receiver as it was
+	for _, i := range []int{len(enc) / 2, len(enc) - 1} {
+		if err := decoded.Decode(bytes.NewReader(enc[:i])); err == nil {
+			t.Errorf("truncation at %d of %d decoded", i, len(enc))
+		}
+		if len(decoded.Origins()) != 2 {
+			t.Fatalf("truncation at %d changed the origins to %v", i, decoded.Origins())
+		}
+	}
+	var zero bytes.Buffer
+	w := msgp.NewWriter(&zero)
+	encodeHeader(w, convergentMagic)
+	w.WriteInt(0)
+	w.WriteArrayHeader(0)
+	w.Flush()
+	if err := decoded.Decode(&zero); err == nil {
+		t.Error("expected an error for size n 0")
+	}
+	if err := c2.Merge(decoded); err != nil {
+		t.Fatal(err)
+	}
+	if s, _ := c2.Stream(); s.Estimate("x").Count != 6 || len(c2.Origins()) != 2 {
+		t.Errorf("expected count 6 from 2 origins, got %v from %v", s.Estimate("x"), c2.Origins())
+	}
+
+	if err := c1.Update("c", New(20)); err == nil {
+		t.Error("expected an error for a stream of another size")
+	}
+}
@@ -0,0 +1,275 @@
+package topk
+
+import (
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/dgryski/go-metro"
+	"github.com/tinylib/msgp/msgp"
+)
+
+const countMinMagic = "topk/cms"
+
+// CountMinHeap estimates the top n elements of a stream with a Count-Min
+// sketch and a heap of the keys with the largest estimates, as an
+// alternative to the Filtered Space-Saving of Stream.
+//
+// Unlike the alphas of Stream, which are shared by many keys, the Count-Min
+// sketch gives a bounded estimate for every key, tracked or not: it
+// overestimates by at most e/width times the stream length with a
+// probability of 1-exp(-depth). Prefer it when most estimates are of
+// untracked keys.
+type CountMinHeap struct {
+	n            int
+	width, depth int
+	total        int
+	table        []int // depth rows of width counters
+	k            keys
+}
+
+// NewCountMinHeap returns a CountMinHeap tracking the top n elements with a
+// Count-Min sketch of depth rows of width counters, all of them at least 1
+func NewCountMinHeap(n, width, depth int) (*CountMinHeap, error) {
+	if n < 1 || width < 1 || depth < 1 || width > math.MaxInt/depth {
+		return nil, fmt.Errorf("topk: count-min of size n %d, width %d and depth %d out of range", n, width, depth)
+	}
+	return &CountMinHeap{
+		n:     n,
+		width: width,
+		depth: depth,
+		table: make([]int, width*depth),
+		k:     keys{m: make(map[string]int, n), elts: make([]Element, 0, n)},
+	}, nil
+}
+
+// cells returns the index of the counter of x in each row
+func (s *CountMinHeap) cells(x string, idx []int) []int {
+	h := metro.Hash64Str(x, 0)
+	h1, h2 := uint32(h), uint32(h>>32)
+	for i := 0; i < s.depth; i++ {
+		// double hashing derives the rows' hashes from the two halves
+		idx = append(idx, i*s.width+int(reduce(uint64(h1+uint32(i)*h2), s.width)))
+	}
+	return idx
+}
+
+// count returns the sketch's estimate over the cells
+func (s *CountMinHeap) count(cells []int) int {
+	min := math.MaxInt
+	for _, c := range cells {
+		if s.table[c] < min {
+			min = s.table[c]
+		}
+	}
+	return min
+}
+
+// slack returns the overestimation bound e/width * N
+func (s *CountMinHeap) slack(count int) int {
+	slack := int(math.Ceil(math.E / float64(s.width) * float64(s.total)))
+	if slack > count {
+		return count
+	}
+	return slack
+}
+
+// Insert adds count occurrences of x to the stream.
+// It returns an estimation for the just inserted element
+func (s *CountMinHeap) Insert(x string, count int) Element {
+	var buf [8]int
+	cells := s.cells(x, buf[:0])
+	s.total += count
+	for _, c := range cells {
+		s.table[c] += count
+	}
+	est := s.count(cells)
+	e := Element{Key: x, Count: est, Error: s.slack(est)}
+
+	if idx, ok := s.k.m[x]; ok {
+		s.k.elts[idx] = e
+		s.k.fix(idx)
+		return e
+	}
+	if len(s.k.elts) < s.n {
+		s.k.push(e)
+		return e
+	}
+	if min := s.k.elts[0]; est > min.Count {
+		delete(s.k.m, min.Key)
+		s.k.elts[0] = e
+		s.k.m[x] = 0
+		s.k.fix(0)
+	}
+	return e
+}
+
+// Estimate returns an estimate for the item x, whether it is tracked or not
+func (s *CountMinHeap) Estimate(x string) Element {
+	var buf [8]int
+	est := s.count(s.cells(x, buf[:0]))
+	return Element{Key: x, Count: est, Error: s.slack(est)}
+}
+
+// Keys returns the tracked elements ordered like Stream.Keys
+func (s *CountMinHeap) Keys() []Element {
+	elts := make([]Element, 0, len(s.k.elts))
+	for _, e := range s.k.elts {
+		// the errors grow with the stream length since the last update
+		elts = append(elts, s.Estimate(e.Key))
+	}
+	SortElements(elts)
+	return elts
+}
+
+// Total returns the sum of the counts of all inserted elements
+func (s *CountMinHeap) Total() int {
+	return s.total
+}
+
+// MergeSketch merges other, which has to be a *CountMinHeap, into s like Merge
+func (s *CountMinHeap) MergeSketch(other Sketch) error {
+	o, ok := other.(*CountMinHeap)
+	if !ok {
+		if other == nil {
+			return ErrNilSketch
+		}
+		return incompatible("cannot merge %T into %T", other, s)
+	}
+	return s.Merge(o)
+}
+
+// Merge adds other to s. The sketches are summed up, the tracked keys of
+// both are estimated from the merged sketch and the largest n kept.
+func (s *CountMinHeap) Merge(other *CountMinHeap) error {
+	if other == nil {
+		return ErrNilSketch
+	}
+	if s.n != other.n || s.width != other.width || s.depth != other.depth {
+		return incompatible("expected count-min of size n %d, width %d and depth %d, got %d, %d and %d",
+			s.n, s.width, s.depth, other.n, other.width, other.depth)
+	}
+
+	for i, c := range other.table {
+		s.table[i] += c
+	}
+	s.total += other.total
+
+	seen := make(map[string]struct{}, len(s.k.elts)+len(other.k.elts))
+	elts := make([]Element, 0, len(s.k.elts)+len(other.k.elts))
+	for _, tk := range []*keys{&s.k, &other.k} {
+		for _, e := range tk.elts {
+			if _, ok := seen[e.Key]; !ok {
+				seen[e.Key] = struct{}{}
+				elts = append(elts, s.Estimate(e.Key))
+			}
+		}
+	}
+	s.setElements(elts)
+	return nil
+}
+
+// setElements replaces the tracked elements with the n largest of elts
+func (s *CountMinHeap) setElements(elts []Element) {
+	SortElements(elts)
+	if len(elts) > s.n {
+		elts = elts[:s.n]
+	}
+	// sized by the elements, n may be read from an encoding
+	s.k = keys{m: make(map[string]int, len(elts)), elts: make([]Element, 0, len(elts))}
+	for _, e := range elts {
+		s.k.push(e)
+	}
+}
+
+// Encode writes the sketch to w
+func (s *CountMinHeap) Encode(w io.Writer) error {
+	wrt := msgp.NewWriter(w)
+	if err := encodeHeader(wrt, countMinMagic); err != nil {
+		return err
+	}
+	for _, v := range []int{s.n, s.width, s.depth, s.total} {
+		if err := wrt.WriteInt(v); err != nil {
+			return err
+		}
+	}
+	if err := wrt.WriteArrayHeader(uint32(len(s.table))); err != nil {
+		return err
+	}
+	for _, c := range s.table {
+		if err := wrt.WriteInt(c); err != nil {
+			return err
+		}
+	}
+	// the counts of the tracked keys are estimated from the table
+	if err := wrt.WriteArrayHeader(uint32(len(s.k.elts))); err != nil {
+		return err
+	}
+	for _, e := range s.k.elts {
+		if err := wrt.WriteString(e.Key); err != nil {
+			return err
+		}
+	}
+	return wrt.Flush()
+}
+
+// Decode replaces s with the sketch read from r. s is left unchanged on
+// errors.
+func (s *CountMinHeap) Decode(r io.Reader) error {
+	rdr := msgp.NewReader(r)
+	if err := expectHeader(rdr, countMinMagic); err != nil {
+		return err
+	}
+	var dims [4]int
+	for i := range dims {
+		v, err := rdr.ReadInt()
+		if err != nil {
+			return err
+		}
+		dims[i] = v
+	}
+	n, width, depth := dims[0], dims[1], dims[2]
+	if n < 1 || n > maxDecodeLen || width < 1 || depth < 1 || width > maxDecodeLen/depth {
+		return fmt.Errorf("topk: corrupt encoding: count-min of size n %d, width %d and depth %d out of range", n, width, depth)
+	}
+	d := &CountMinHeap{n: n, width: width, depth: depth, total: dims[3]}
+
+	sz, err := rdr.ReadArrayHeader()
+	if err != nil {
+		return err
+	}
+	if int(sz) != width*depth {
+		return fmt.Errorf("topk: corrupt encoding: expected %d counters, got %d", width*depth, sz)
+	}
+	// the table grows with the counters actually read
+	hint, err := decodeLen(int(sz))
+	if err != nil {
+		return err
+	}
+	d.table = make([]int, 0, hint)
+	for i := 0; i < int(sz); i++ {
+		c, err := rdr.ReadInt()
+		if err != nil {
+			return err
+		}
+		d.table = append(d.table, c)
+	}
+
+	if sz, err = rdr.ReadArrayHeader(); err != nil {
+		return err
+	}
+	if hint, err = decodeLen(int(sz)); err != nil {
+		return err
+	}
+	elts := make([]Element, 0, hint)
+	for i := uint32(0); i < sz; i++ {
+		x, err := readString(rdr)
+		if err != nil {
+			return err
+		}
+		elts = append(elts, d.Estimate(x))
+	}
+	d.setElements(elts)
+	*s = *d
+	return nil
+}
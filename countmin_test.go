@@ -0,0 +1,63 @@
+package topk
+
+import (
+	"fmt"
+	"math"
+	"testing"
+)
+
+// newCountMin returns a CountMinHeap with the valid dimensions
+func newCountMin(t testing.TB, n, width, depth int) *CountMinHeap {
+	t.Helper()
+	s, err := NewCountMinHeap(n, width, depth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestCountMinHeap(t *testing.T) {
+	s := newCountMin(t, 5, 2000, 4)
+	exact := map[string]int{}
+	for i := 0; i < 50000; i++ {
+		x := fmt.Sprintf("key-%d", i%1000)
+		if i%3 == 0 {
+			x = fmt.Sprintf("hot-%d", i%7)
+		}
+		s.Insert(x, 1)
+		exact[x]++
+	}
+
+	keys := s.Keys()
+	if len(keys) != 5 {
+		t.Fatalf("expected 5 keys, got %v", keys)
+	}
+	for _, e := range keys {
+		if e.Key[:4] != "hot-" {
+			t.Errorf("expected only hot keys, got %v", keys)
+		}
+	}
+
+	// untracked keys are estimated within the bounds too
+	var outside int
+	for x, c := range exact {
+		e := s.Estimate(x)
+		if e.Count < c {
+			t.Fatalf("expected %s to be overestimated, got %v for %d", x, e, c)
+		}
+		if e.LowerBound() > c {
+			outside++
+		}
+	}
+	if outside > len(exact)/50 {
+		t.Errorf("expected most counts above their lower bound, %d of %d are not", outside, len(exact))
+	}
+}
+
+func TestCountMinHeapDimensions(t *testing.T) {
+	for _, d := range [][3]int{{0, 10, 4}, {5, 0, 4}, {5, 10, 0}, {5, -1, 4}, {5, math.MaxInt, 2}} {
+		if _, err := NewCountMinHeap(d[0], d[1], d[2]); err == nil {
+			t.Errorf("expected an error for size n %d, width %d and depth %d", d[0], d[1], d[2])
+		}
+	}
+}
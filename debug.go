@@ -0,0 +1,112 @@
+package topk
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/dgryski/go-metro"
+)
+
+// String returns a concise summary of the stream: its size, the total count
+// of all inserted elements, how many of the n slots are in use and the top 5
+// elements.
+func (s *Stream) String() string {
+	top := s.Keys()
+	if len(top) > 5 {
+		top = top[:5]
+	}
+	parts := make([]string, len(top))
+	for i, e := range top {
+		parts[i] = fmt.Sprintf("%q:%d±%d", e.Key, e.Count, e.Error)
+	}
+
+	return fmt.Sprintf("topk.Stream{n: %d, total: %d, fill: %d/%d, top: [%s]}",
+		s.n, s.total, len(s.k.elts), s.n, strings.Join(parts, " "))
+}
+
+// DebugDump writes the heap in array order and all nonzero alphas to w
+func (s *Stream) DebugDump(w io.Writer) error {
+	if _, err := fmt.Fprintf(w, "n=%d elements=%d alphas=%d stale=%t\n", s.n, len(s.k.elts), len(s.alphas), s.stale); err != nil {
+		return err
+	}
+	for i, e := range s.k.elts {
+		if _, err := fmt.Fprintf(w, "heap[%d] key=%q count=%d error=%d\n", i, e.Key, e.Count, e.Error); err != nil {
+			return err
+		}
+	}
+	for i, a := range s.alphas {
+		if a == 0 {
+			continue
+		}
+		if _, err := fmt.Fprintf(w, "alpha[%d]=%d\n", i, a); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// KeyTrace tells which heap entry and alpha slot a key maps to
+type KeyTrace struct {
+	// Key is the key after normalization
+	Key string
+	// Partition is the heap tracking the key, always 0 for a Stream
+	Partition int
+	// HeapIndex is the index of the key in the heap array, -1 if the key
+	// is not tracked
+	HeapIndex int
+	// AlphaSlot is the index of the alpha of the key, Alpha its value
+	AlphaSlot int
+	Alpha     int
+}
+
+// PartitionOf returns the partition of x, a Stream is a single partition so
+// it is always 0
+func (s *Stream) PartitionOf(x string) int { return 0 }
+
+// Trace returns where x is tracked in the stream
+func (s *Stream) Trace(x string) KeyTrace {
+	x, _ = s.key(x)
+	t := KeyTrace{Key: x, HeapIndex: -1}
+	if idx, ok := s.k.m[x]; ok {
+		t.HeapIndex = idx
+	}
+	t.AlphaSlot = int(reduce(metro.Hash64Str(x, 0), len(s.alphas)))
+	t.Alpha = s.alphas[t.AlphaSlot]
+	return t
+}
+
+// DumpPartition writes partition i like DebugDump, a Stream only has
+// partition 0
+func (s *Stream) DumpPartition(w io.Writer, i int) error {
+	if i != 0 {
+		return fmt.Errorf("topk: partition %d out of range [0,1)", i)
+	}
+	return s.DebugDump(w)
+}
+
+// PartitionOf returns the shard tracking x
+func (sh *Sharded) PartitionOf(x string) int {
+	return int(shardOf(x, len(sh.shards)))
+}
+
+// Trace returns where x is tracked in its shard
+func (sh *Sharded) Trace(x string) KeyTrace {
+	shd := sh.shardFor(x)
+	shd.mu.Lock()
+	t := shd.s.Trace(x)
+	shd.mu.Unlock()
+	t.Partition = sh.PartitionOf(x)
+	return t
+}
+
+// DumpPartition writes the heap and alphas of shard i like DebugDump
+func (sh *Sharded) DumpPartition(w io.Writer, i int) error {
+	if i < 0 || i >= len(sh.shards) {
+		return fmt.Errorf("topk: partition %d out of range [0,%d)", i, len(sh.shards))
+	}
+	shd := &sh.shards[i]
+	shd.mu.Lock()
+	defer shd.mu.Unlock()
+	return shd.s.DebugDump(w)
+}
@@ -0,0 +1,78 @@
+package topk
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestString(t *testing.T) {
+	s := New(3)
+	s.Insert("a", 3)
+	s.Insert("b", 2)
+
+	want := `topk.Stream{n: 3, total: 5, fill: 2/3, top: ["a":3±0 "b":2±0]}`
+	if got := s.String(); got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+
+	// the total includes the untracked counts
+	s = New(1)
+	s.Insert("a", 3)
+	s.Insert("b", 2)
+	want = `topk.Stream{n: 1, total: 5, fill: 1/1, top: ["a":3±0]}`
+	if got := s.String(); got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestDebugDump(t *testing.T) {
+	s := New(1)
+	s.Insert("a", 3)
+	s.Insert("b", 1)
+
+	buf := bytes.NewBuffer(nil)
+	if err := s.DebugDump(buf); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, want := range []string{"n=1 elements=1 alphas=6", `heap[0] key="a" count=3 error=0`, "]=1\n"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("%q missing from dump:\n%s", want, out)
+		}
+	}
+}
+
+func TestTrace(t *testing.T) {
+	s := New(3, WithKeyTransform(strings.ToLower))
+	s.Insert("a", 3)
+	s.Insert("b", 2)
+
+	tr := s.Trace("A")
+	if tr.Key != "a" || tr.Partition != 0 || s.k.elts[tr.HeapIndex].Key != "a" {
+		t.Errorf("unexpected trace %+v", tr)
+	}
+	if tr := s.Trace("missing"); tr.HeapIndex != -1 || tr.AlphaSlot < 0 || tr.AlphaSlot >= len(s.alphas) {
+		t.Errorf("unexpected trace of an untracked key %+v", tr)
+	}
+	if err := s.DumpPartition(&bytes.Buffer{}, 1); err == nil {
+		t.Error("expected an error for a missing partition")
+	}
+
+	sh := NewSharded(3, 4)
+	sh.Insert("a", 1)
+	p := sh.PartitionOf("a")
+	if tr := sh.Trace("a"); tr.Partition != p || tr.HeapIndex != 0 {
+		t.Errorf("unexpected trace %+v", tr)
+	}
+	buf := bytes.NewBuffer(nil)
+	if err := sh.DumpPartition(buf, p); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), `heap[0] key="a" count=1 error=0`) {
+		t.Errorf("expected a in the dump of its partition, got %s", buf)
+	}
+	if err := sh.DumpPartition(buf, 4); err == nil {
+		t.Error("expected an error for a missing partition")
+	}
+}
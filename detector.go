@@ -0,0 +1,97 @@
+package topk
+
+import "time"
+
+// RiserKind tells why a RiserEvent was emitted
+type RiserKind int
+
+const (
+	// FastRiser is emitted for keys growing faster than the threshold rate
+	FastRiser RiserKind = iota
+	// NewInTop is emitted for keys entering the top m
+	NewInTop
+)
+
+// RiserEvent reports a key rising suddenly
+type RiserEvent struct {
+	Kind    RiserKind
+	Element Element
+	// Rank is the position of the key in the results, starting at 0
+	Rank int
+	// Rate is the growth of the key's estimated count per interval
+	Rate float64
+	Time time.Time
+}
+
+// Detector detects keys whose estimated count grows faster than a threshold
+// or that newly enter the top m, e.g. to spot DDoS sources or abuse. Feed it
+// the sketch periodically with Observe.
+type Detector struct {
+	threshold float64
+	interval  time.Duration
+	topM      int
+	clock     Clock
+
+	last     time.Time
+	counts   map[string]int
+	previous map[string]struct{}
+}
+
+// NewDetector returns a Detector reporting keys growing by more than
+// threshold per interval and keys entering the top m. A topM of 0 disables
+// the latter. Rates are measured against clock, the system clock if nil.
+func NewDetector(threshold int, interval time.Duration, topM int, clock Clock) *Detector {
+	if clock == nil {
+		clock = SystemClock{}
+	}
+	return &Detector{
+		threshold: float64(threshold),
+		interval:  interval,
+		topM:      topM,
+		clock:     clock,
+	}
+}
+
+// Observe compares s to the previous observation and returns the events for
+// the keys rising since then. The first observation only establishes the
+// baseline.
+func (d *Detector) Observe(s *Stream) []RiserEvent {
+	now := d.clock.Now()
+	keys := s.Keys()
+
+	counts := make(map[string]int, len(keys))
+	top := make(map[string]struct{}, d.topM)
+	for i, e := range keys {
+		counts[e.Key] = e.Count
+		if i < d.topM {
+			top[e.Key] = struct{}{}
+		}
+	}
+
+	var events []RiserEvent
+	if d.counts != nil {
+		elapsed := now.Sub(d.last)
+		for i, e := range keys {
+			var rate float64
+			if elapsed > 0 {
+				prev, ok := d.counts[e.Key]
+				if !ok {
+					// the key wasn't tracked back then, its error is
+					// what it inherited on admission
+					prev = e.Error
+				}
+				rate = float64(e.Count-prev) * float64(d.interval) / float64(elapsed)
+			}
+
+			if rate > d.threshold {
+				events = append(events, RiserEvent{Kind: FastRiser, Element: e, Rank: i, Rate: rate, Time: now})
+			}
+			if _, ok := d.previous[e.Key]; i < d.topM && !ok {
+				events = append(events, RiserEvent{Kind: NewInTop, Element: e, Rank: i, Rate: rate, Time: now})
+			}
+		}
+	}
+
+	d.last, d.counts, d.previous = now, counts, top
+	return events
+}
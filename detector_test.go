@@ -0,0 +1,44 @@
+package topk
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDetector(t *testing.T) {
+	clock := NewFakeClock(time.Unix(0, 0))
+	d := NewDetector(100, time.Minute, 2, clock)
+
+	s := New(10)
+	s.Insert("a", 1000)
+	s.Insert("b", 500)
+	s.Insert("c", 10)
+	if events := d.Observe(s); len(events) != 0 {
+		t.Fatalf("expected no events for the baseline, got %v", events)
+	}
+
+	// over two minutes a grows by 100/min, c by 300/min
+	clock.Advance(2 * time.Minute)
+	s.Insert("a", 200)
+	s.Insert("c", 600)
+
+	events := d.Observe(s)
+	if len(events) != 2 {
+		t.Fatalf("expected 2 events, got %v", events)
+	}
+	if e := events[0]; e.Kind != FastRiser || e.Element.Key != "c" || e.Rate != 300 || e.Rank != 1 {
+		t.Errorf("unexpected event %+v", e)
+	}
+	if e := events[1]; e.Kind != NewInTop || e.Element.Key != "c" {
+		t.Errorf("unexpected event %+v", e)
+	}
+}
+
+func TestDetectorSystemClock(t *testing.T) {
+	d := NewDetector(10, time.Second, 3, nil)
+	s := New(10)
+	s.Insert("a", 1)
+	if events := d.Observe(s); len(events) != 0 {
+		t.Errorf("expected the first observation to only set the baseline, got %v", events)
+	}
+}
@@ -0,0 +1,79 @@
+package topk
+
+// doorkeeper holds back the first occurrence of keys so that keys occurring
+// only once never make it to the heap or the alphas.
+//
+// It is made of two Bloom filters, one of the keys seen once and one of the
+// keys seen at least twice. The first occurrence of a key is held, the second
+// one is admitted together with the held one and any later ones pass through.
+// Both filters are reset after capacity keys have been held, to keep their
+// false positive rate low.
+type doorkeeper struct {
+	once     []uint64
+	twice    []uint64
+	held     int
+	capacity int
+}
+
+type doorResult uint8
+
+const (
+	doorPass  doorResult = iota // seen before, insert as usual
+	doorHeld                    // first occurrence, hold it back
+	doorAdmit                   // second occurrence, insert both
+)
+
+func newDoorkeeper(bits int) *doorkeeper {
+	words := (bits + 63) / 64
+	if words < 1 {
+		words = 1
+	}
+	return &doorkeeper{
+		once:  make([]uint64, words),
+		twice: make([]uint64, words),
+		// with two probes this keeps the false positive rate around 5%
+		capacity: words * 64 / 8,
+	}
+}
+
+// probes returns the two bit positions of h
+func (d *doorkeeper) probes(h uint64) (uint32, uint32) {
+	bits := len(d.once) * 64
+	// the low 32 bits of the hash select the alpha slot, use the high ones
+	return reduce(h>>32, bits), reduce((h>>32)*0x9e3779b97f4a7c15>>32, bits)
+}
+
+func has(f []uint64, p1, p2 uint32) bool {
+	return f[p1/64]&(1<<(p1%64)) != 0 && f[p2/64]&(1<<(p2%64)) != 0
+}
+
+func set(f []uint64, p1, p2 uint32) {
+	f[p1/64] |= 1 << (p1 % 64)
+	f[p2/64] |= 1 << (p2 % 64)
+}
+
+func (d *doorkeeper) check(h uint64) doorResult {
+	p1, p2 := d.probes(h)
+	switch {
+	case has(d.twice, p1, p2):
+		return doorPass
+	case has(d.once, p1, p2):
+		set(d.twice, p1, p2)
+		return doorAdmit
+	}
+
+	if d.held >= d.capacity {
+		d.reset()
+	}
+	d.held++
+	set(d.once, p1, p2)
+	return doorHeld
+}
+
+func (d *doorkeeper) reset() {
+	for i := range d.once {
+		d.once[i] = 0
+		d.twice[i] = 0
+	}
+	d.held = 0
+}
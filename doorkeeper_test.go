@@ -0,0 +1,35 @@
+package topk
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestDoorkeeper(t *testing.T) {
+	s := New(10, WithDoorkeeper(1<<16))
+
+	// one-hit wonders never make it to the sketch
+	for i := 0; i < 1000; i++ {
+		s.Insert(fmt.Sprintf("once-%d", i), 1)
+	}
+	for i := 0; i < 5; i++ {
+		s.Insert("five", 1)
+	}
+	s.InsertMany([]string{"two", "two"})
+
+	if e := s.Estimate("five"); e.Count != 5 {
+		t.Errorf("expected five to be counted 5 times, got %v", e)
+	}
+	if e := s.Estimate("two"); e.Count != 2 {
+		t.Errorf("expected two to be counted 2 times, got %v", e)
+	}
+	if keys := s.Keys(); keys[0].Key != "five" {
+		t.Errorf("expected five on top, got %v", keys)
+	}
+	if st := s.Stats(); st.HeldInserts < 950 {
+		t.Errorf("expected about 1002 held inserts, got %d", st.HeldInserts)
+	}
+	if s.Total() != 1007 {
+		t.Errorf("expected a total of 1007, got %d", s.Total())
+	}
+}
@@ -0,0 +1,55 @@
+package topk
+
+import "time"
+
+// Eviction records a tracked element being replaced by a new key
+type Eviction struct {
+	// Element is the evicted element as of its eviction
+	Element
+	// By is the key that replaced it
+	By   string
+	Time time.Time
+}
+
+// evictionLog is a ring buffer of the most recent evictions
+type evictionLog struct {
+	buf  []Eviction
+	next int
+	full bool
+}
+
+func (l *evictionLog) add(e Eviction) {
+	l.buf[l.next] = e
+	if l.next++; l.next == len(l.buf) {
+		l.next = 0
+		l.full = true
+	}
+}
+
+func (l *evictionLog) clone() *evictionLog {
+	c := *l
+	c.buf = append([]Eviction(nil), l.buf...)
+	return &c
+}
+
+// Evictions returns the most recent evictions recorded with WithEvictionLog,
+// oldest first. Only evictions by inserts are recorded, elements trimmed by
+// a merge are not.
+func (s *Stream) Evictions() []Eviction {
+	l := s.evictions
+	if l == nil {
+		return nil
+	}
+	if !l.full {
+		return append([]Eviction(nil), l.buf[:l.next]...)
+	}
+	return append(append([]Eviction(nil), l.buf[l.next:]...), l.buf[:l.next]...)
+}
+
+// now returns the current time of the stream's clock
+func (s *Stream) now() time.Time {
+	if s.clock == nil {
+		return time.Now()
+	}
+	return s.clock.Now()
+}
@@ -0,0 +1,37 @@
+package topk
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestEvictions(t *testing.T) {
+	clock := NewFakeClock(time.Unix(0, 0))
+	s := New(1, WithEvictionLog(3), WithClock(clock))
+	if ev := s.Evictions(); len(ev) != 0 {
+		t.Fatalf("expected no evictions, got %v", ev)
+	}
+
+	for i := 0; i < 5; i++ {
+		clock.Advance(time.Second)
+		// each count exceeds all previous ones, so every insert evicts
+		s.Insert(fmt.Sprintf("key-%d", i), 1<<(4*i))
+	}
+
+	ev := s.Evictions()
+	if len(ev) != 3 {
+		t.Fatalf("expected the last 3 evictions, got %v", ev)
+	}
+	for i, e := range ev {
+		// key-0 was evicted by key-1 and so on, the first one fell off
+		key, by := fmt.Sprintf("key-%d", i+1), fmt.Sprintf("key-%d", i+2)
+		if e.Key != key || e.By != by || e.Count < 1<<(4*(i+1)) || !e.Time.Equal(time.Unix(int64(i+3), 0)) {
+			t.Errorf("unexpected eviction %d: %+v", i, e)
+		}
+	}
+
+	if ev := New(1).Evictions(); ev != nil {
+		t.Errorf("expected no log without WithEvictionLog, got %v", ev)
+	}
+}
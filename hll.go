@@ -0,0 +1,84 @@
+package topk
+
+import (
+	"fmt"
+	"math"
+	"math/bits"
+)
+
+// hllPrecision is the number of hash bits selecting a register, 2^12
+// registers give a standard error of about 1.6%
+const hllPrecision = 12
+
+// hll is a HyperLogLog estimating the number of distinct keys inserted
+type hll struct {
+	regs []uint8
+}
+
+func newHLL() *hll {
+	return &hll{regs: make([]uint8, 1<<hllPrecision)}
+}
+
+// add records the key hashing to h
+func (l *hll) add(h uint64) {
+	// mix the hash, its low and high bits already select the alpha slot and
+	// the shard
+	h *= 0x9e3779b97f4a7c15
+	idx := h >> (64 - hllPrecision)
+	rank := uint8(bits.LeadingZeros64(h<<hllPrecision|1<<(hllPrecision-1))) + 1
+	if rank > l.regs[idx] {
+		l.regs[idx] = rank
+	}
+}
+
+// merge makes l count the keys added to other as well
+func (l *hll) merge(other *hll) {
+	for i, r := range other.regs {
+		if r > l.regs[i] {
+			l.regs[i] = r
+		}
+	}
+}
+
+// count returns the estimated number of distinct keys
+func (l *hll) count() uint64 {
+	m := float64(len(l.regs))
+	var (
+		sum   float64
+		zeros int
+	)
+	for _, r := range l.regs {
+		sum += math.Ldexp(1, -int(r))
+		if r == 0 {
+			zeros++
+		}
+	}
+	est := 0.7213 / (1 + 1.079/m) * m * m / sum
+	if est <= 2.5*m && zeros > 0 {
+		// linear counting is more accurate for small cardinalities
+		est = m * math.Log(m/float64(zeros))
+	}
+	return uint64(est + 0.5)
+}
+
+func (l *hll) clone() *hll {
+	return &hll{regs: append([]uint8(nil), l.regs...)}
+}
+
+func decodeHLL(b []byte) (*hll, error) {
+	if len(b) != 1<<hllPrecision {
+		return nil, fmt.Errorf("expected %d distinct registers, got %d", 1<<hllPrecision, len(b))
+	}
+	return &hll{regs: b}, nil
+}
+
+// Distinct returns the estimated number of distinct keys inserted into the
+// stream, including those never tracked. It is only maintained with
+// WithDistinct, ok is false otherwise or if the stream was merged with one
+// that didn't maintain it.
+func (s *Stream) Distinct() (n uint64, ok bool) {
+	if s.distinct == nil {
+		return 0, false
+	}
+	return s.distinct.count(), true
+}
@@ -0,0 +1,62 @@
+package topk
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"testing"
+)
+
+func TestDistinct(t *testing.T) {
+	a, b := New(10, WithDistinct()), New(10, WithDistinct())
+	for i := 0; i < 20000; i++ {
+		a.Insert(fmt.Sprintf("key-%d", i), 1)
+		a.Insert("hot", 1)
+		b.Insert(fmt.Sprintf("key-%d", i+10000), 1)
+	}
+
+	checkDistinct := func(s *Stream, want int) {
+		t.Helper()
+		n, ok := s.Distinct()
+		if !ok {
+			t.Fatal("expected distinct count to be maintained")
+		}
+		if math.Abs(float64(n)-float64(want)) > 0.05*float64(want) {
+			t.Errorf("expected about %d distinct keys, got %d", want, n)
+		}
+	}
+	checkDistinct(a, 20001)
+
+	buf := bytes.NewBuffer(nil)
+	if err := b.Encode(buf); err != nil {
+		t.Fatal(err)
+	}
+	enc := buf.Bytes()
+	decoded := New(10)
+	if err := decoded.Decode(bytes.NewReader(enc)); err != nil {
+		t.Fatal(err)
+	}
+	checkDistinct(decoded, 20000)
+
+	merged, err := MergeEncodedAll([]io.Reader{bytes.NewReader(enc), bytes.NewReader(enc)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkDistinct(merged, 20000)
+
+	if err := a.Merge(b); err != nil {
+		t.Fatal(err)
+	}
+	checkDistinct(a, 30001)
+
+	if err := a.Merge(New(10)); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := a.Distinct(); ok {
+		t.Error("expected distinct count to be unknown after merging a stream without it")
+	}
+	if _, ok := New(10).Distinct(); ok {
+		t.Error("expected distinct count to be unknown without WithDistinct")
+	}
+}
@@ -0,0 +1,58 @@
+package topk
+
+import "io"
+
+// Hooks receive the events of a Stream, e.g. to feed a metrics or tracing
+// library. They are called synchronously from the stream's methods and must
+// not call back into the stream. Embed NopHooks to only implement some of
+// them.
+type Hooks interface {
+	// OnInsert is called for every insert counted by the sketch, after
+	// key normalization and filtering
+	OnInsert(key string, count int)
+	// OnAdmit is called when a key starts being tracked
+	OnAdmit(e Element)
+	// OnEvict is called when the tracked element evicted is replaced by the
+	// key by
+	OnEvict(evicted Element, by string)
+	// OnMerge is called after another stream was merged in, with the
+	// number of elements tracked afterwards
+	OnMerge(tracked int)
+	// OnEncode is called after Encode with the number of bytes written
+	OnEncode(bytes int64, err error)
+	// OnTune is called when WithAutoTune finds n too small
+	OnTune(ev TuneEvent)
+}
+
+// NopHooks implements Hooks doing nothing
+type NopHooks struct{}
+
+// OnInsert does nothing
+func (NopHooks) OnInsert(string, int) {}
+
+// OnAdmit does nothing
+func (NopHooks) OnAdmit(Element) {}
+
+// OnEvict does nothing
+func (NopHooks) OnEvict(Element, string) {}
+
+// OnMerge does nothing
+func (NopHooks) OnMerge(int) {}
+
+// OnEncode does nothing
+func (NopHooks) OnEncode(int64, error) {}
+
+// OnTune does nothing
+func (NopHooks) OnTune(TuneEvent) {}
+
+// countingWriter counts the bytes written to w
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
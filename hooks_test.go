@@ -0,0 +1,55 @@
+package topk
+
+import (
+	"bytes"
+	"testing"
+)
+
+type recordingHooks struct {
+	NopHooks
+	inserts, admits, evicts int
+	merged                  int
+	encoded                 int64
+}
+
+func (h *recordingHooks) OnInsert(string, int)    { h.inserts++ }
+func (h *recordingHooks) OnAdmit(Element)         { h.admits++ }
+func (h *recordingHooks) OnEvict(Element, string) { h.evicts++ }
+func (h *recordingHooks) OnMerge(tracked int)     { h.merged = tracked }
+func (h *recordingHooks) OnEncode(n int64, err error) {
+	if err == nil {
+		h.encoded = n
+	}
+}
+
+func TestHooks(t *testing.T) {
+	h := &recordingHooks{}
+	s := New(2, WithHooks(h))
+	s.Insert("a", 1)
+	s.Insert("a", 1)
+	s.Insert("b", 1)
+	// each count exceeds all previous ones, so these evict
+	s.Insert("c", 10)
+	s.Insert("d", 100)
+
+	if h.inserts != 5 || h.admits != 4 || h.evicts != 2 {
+		t.Errorf("expected 5 inserts, 4 admits and 2 evictions, got %+v", h)
+	}
+
+	other := New(2)
+	other.Insert("e", 1)
+	if err := s.Merge(other); err != nil {
+		t.Fatal(err)
+	}
+	if h.merged != 2 {
+		t.Errorf("expected 2 tracked elements after merge, got %d", h.merged)
+	}
+
+	buf := bytes.NewBuffer(nil)
+	if err := s.Encode(buf); err != nil {
+		t.Fatal(err)
+	}
+	if h.encoded != int64(buf.Len()) {
+		t.Errorf("expected %d encoded bytes, got %d", buf.Len(), h.encoded)
+	}
+}
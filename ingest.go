@@ -0,0 +1,215 @@
+package topk
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrDropped is returned by Ingester.Insert when the buffer is full and the
+// ingester drops inserts
+var ErrDropped = errors.New("topk: ingest buffer full, insert dropped")
+
+// BufferPolicy defines what happens to inserts when an Ingester's buffer is
+// full
+type BufferPolicy int
+
+const (
+	// Block makes inserts wait for space in the buffer
+	Block BufferPolicy = iota
+	// Drop rejects inserts with ErrDropped
+	Drop
+)
+
+type ingestItem struct {
+	key   string
+	count int
+}
+
+// IngestStats are the counters of an Ingester
+type IngestStats struct {
+	Received  uint64 // inserts accepted into the buffer
+	Dropped   uint64 // inserts rejected because the buffer was full
+	Processed uint64 // inserts applied to the stream
+	Lag       int    // inserts waiting in the buffer
+
+	// Throughput is the number of processed inserts per second since Run
+	// was started
+	Throughput float64
+}
+
+// Ingester decouples producers from a Stream: inserts are buffered in a
+// bounded channel and applied to the stream by a single goroutine running
+// Run. The stream can be queried concurrently through View.
+type Ingester struct {
+	policy BufferPolicy
+	ch     chan ingestItem
+
+	mu sync.Mutex
+	s  *Stream
+
+	clock Clock
+
+	received  atomic.Uint64
+	dropped   atomic.Uint64
+	processed atomic.Uint64
+	started   atomic.Int64
+}
+
+// IngestOption configures an Ingester
+type IngestOption func(*Ingester)
+
+// WithIngestClock sets the clock used to compute the throughput, the default
+// is SystemClock
+func WithIngestClock(c Clock) IngestOption {
+	return func(in *Ingester) {
+		in.clock = c
+	}
+}
+
+// NewIngester returns an Ingester feeding s through a buffer of the given
+// size
+func NewIngester(s *Stream, buffer int, policy BufferPolicy, opts ...IngestOption) *Ingester {
+	in := &Ingester{
+		policy: policy,
+		ch:     make(chan ingestItem, buffer),
+		s:      s,
+		clock:  SystemClock{},
+	}
+	for _, opt := range opts {
+		opt(in)
+	}
+	return in
+}
+
+// Insert queues x to be inserted with the given count. Depending on the
+// buffer policy it either waits for space in the buffer or returns
+// ErrDropped if there is none. It must not be called after Close.
+func (in *Ingester) Insert(ctx context.Context, x string, count int) error {
+	it := ingestItem{key: x, count: count}
+	if in.policy == Drop {
+		select {
+		case in.ch <- it:
+		default:
+			in.dropped.Add(1)
+			return ErrDropped
+		}
+		in.received.Add(1)
+		return nil
+	}
+
+	select {
+	case in.ch <- it:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	in.received.Add(1)
+	return nil
+}
+
+// Close signals that there will be no more inserts, Run returns once the
+// buffer is drained
+func (in *Ingester) Close() {
+	close(in.ch)
+}
+
+// Run applies the buffered inserts to the stream until Close is called or
+// ctx is cancelled
+func (in *Ingester) Run(ctx context.Context) error {
+	in.started.Store(in.clock.Now().UnixNano())
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case it, ok := <-in.ch:
+			if !ok {
+				return nil
+			}
+			in.apply(it)
+		}
+	}
+}
+
+// apply inserts it and whatever else is buffered under a single lock
+func (in *Ingester) apply(it ingestItem) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+
+	in.s.Insert(it.key, it.count)
+	n := uint64(1)
+	defer func() { in.processed.Add(n) }()
+
+	for n < 256 {
+		select {
+		case it, ok := <-in.ch:
+			if !ok {
+				return
+			}
+			in.s.Insert(it.key, it.count)
+			n++
+		default:
+			return
+		}
+	}
+}
+
+// View returns an immutable view of the stream's current state
+func (in *Ingester) View() *View {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	return in.s.Freeze()
+}
+
+// Snapshot returns a snapshot of the stream's current state
+func (in *Ingester) Snapshot() Snapshot {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	return in.s.Snapshot()
+}
+
+// Watch emits a snapshot of the stream every interval until ctx is done,
+// then closes the returned channel. Snapshots are taken consistently
+// between batches of inserts. A snapshot that hasn't been received by the
+// next tick is replaced, so a slow receiver gets the latest one.
+func (in *Ingester) Watch(ctx context.Context, interval time.Duration) <-chan Snapshot {
+	ch := make(chan Snapshot, 1)
+	go func() {
+		defer close(ch)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			snap := in.Snapshot()
+			// drop the unread snapshot, this goroutine is the only sender so
+			// the send below doesn't block
+			select {
+			case <-ch:
+			default:
+			}
+			ch <- snap
+		}
+	}()
+	return ch
+}
+
+// Stats returns the ingester's counters
+func (in *Ingester) Stats() IngestStats {
+	st := IngestStats{
+		Received:  in.received.Load(),
+		Dropped:   in.dropped.Load(),
+		Processed: in.processed.Load(),
+		Lag:       len(in.ch),
+	}
+	if started := in.started.Load(); started != 0 {
+		if elapsed := in.clock.Now().Sub(time.Unix(0, started)).Seconds(); elapsed > 0 {
+			st.Throughput = float64(st.Processed) / elapsed
+		}
+	}
+	return st
+}
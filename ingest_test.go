@@ -0,0 +1,124 @@
+package topk
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestIngester(t *testing.T) {
+	in := NewIngester(New(10), 16, Block)
+
+	done := make(chan error)
+	go func() {
+		done <- in.Run(context.Background())
+	}()
+
+	ctx := context.Background()
+	for i := 0; i < 1000; i++ {
+		if err := in.Insert(ctx, "a", 1); err != nil {
+			t.Fatal(err)
+		}
+		if i%10 == 0 {
+			if err := in.Insert(ctx, "b", 1); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	in.Close()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	if e := in.View().Estimate("a"); e.Count != 1000 {
+		t.Errorf("expected a to be counted 1000 times, got %v", e)
+	}
+	st := in.Stats()
+	if st.Received != 1100 || st.Processed != 1100 || st.Dropped != 0 || st.Lag != 0 {
+		t.Errorf("unexpected stats %+v", st)
+	}
+}
+
+func TestIngesterDrop(t *testing.T) {
+	// nothing is consuming the buffer
+	in := NewIngester(New(10), 2, Drop)
+
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		if err := in.Insert(ctx, "a", 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := in.Insert(ctx, "a", 1); err != ErrDropped {
+		t.Errorf("expected ErrDropped, got %v", err)
+	}
+
+	st := in.Stats()
+	if st.Received != 2 || st.Dropped != 1 || st.Lag != 2 {
+		t.Errorf("unexpected stats %+v", st)
+	}
+}
+
+func TestIngesterThroughput(t *testing.T) {
+	clock := NewFakeClock(time.Unix(1000, 0))
+	in := NewIngester(New(10), 16, Block, WithIngestClock(clock))
+
+	done := make(chan error)
+	go func() {
+		done <- in.Run(context.Background())
+	}()
+	for i := 0; i < 100; i++ {
+		if err := in.Insert(context.Background(), "a", 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	in.Close()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	clock.Advance(10 * time.Second)
+	if st := in.Stats(); st.Throughput != 10 {
+		t.Errorf("expected a throughput of 10/s, got %v", st.Throughput)
+	}
+}
+
+func TestIngesterWatch(t *testing.T) {
+	in := NewIngester(New(10), 16, Block)
+	go in.Run(context.Background())
+	defer in.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	snaps := in.Watch(ctx, time.Millisecond)
+
+	if err := in.Insert(ctx, "a", 1); err != nil {
+		t.Fatal(err)
+	}
+	// wait for the insert to show up
+	for snap := range snaps {
+		if snap.Estimate("a").Count == 1 {
+			break
+		}
+	}
+	cancel()
+	for range snaps {
+		// drain until the channel is closed
+	}
+
+	// a slow receiver gets the latest snapshot, not the one of the first
+	// tick after it stopped receiving
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	snaps = in.Watch(ctx, time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	if err := in.Insert(ctx, "b", 1); err != nil {
+		t.Fatal(err)
+	}
+	for in.Snapshot().Estimate("b").Count != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if snap := <-snaps; snap.Estimate("b").Count != 1 {
+		t.Errorf("expected the latest snapshot, got %v", snap.Estimate("b"))
+	}
+}
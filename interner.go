@@ -0,0 +1,78 @@
+package topk
+
+import (
+	"strings"
+	"sync"
+)
+
+// Interner is a pool of key strings shared by sketches over overlapping key
+// spaces, so that a key tracked by many of them is stored once, see
+// WithInterner. It is safe for concurrent use.
+//
+// The pool holds at most capacity keys and is emptied when it is full. Keys
+// still tracked by a sketch stay valid, but are only deduplicated again once
+// a sketch re-admits them, so capacity should exceed the number of distinct
+// keys tracked across the sketches.
+type Interner struct {
+	mu       sync.Mutex
+	m        map[string]string
+	capacity int
+}
+
+// NewInterner returns a pool holding up to capacity keys
+func NewInterner(capacity int) *Interner {
+	return &Interner{m: make(map[string]string), capacity: capacity}
+}
+
+// Intern returns the pooled string equal to x, adding a copy of x if there
+// is none. The copy keeps x from pinning a larger buffer it was sliced from.
+func (p *Interner) Intern(x string) string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if v, ok := p.m[x]; ok {
+		return v
+	}
+	if len(p.m) >= p.capacity {
+		p.m = make(map[string]string)
+	}
+	v := strings.Clone(x)
+	p.m[v] = v
+	return v
+}
+
+// Len returns the number of pooled keys
+func (p *Interner) Len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.m)
+}
+
+// WithInterner stores the keys tracked by the stream in the pool p. Only the
+// keys admitted to the stream are interned, the inserts of tracked keys
+// don't touch the pool.
+func WithInterner(p *Interner) Option {
+	return func(s *Stream) {
+		s.interner = p
+	}
+}
+
+// intern returns the pooled x if the stream uses an interner
+func (s *Stream) intern(x string) string {
+	if s.interner == nil {
+		return x
+	}
+	return s.interner.Intern(x)
+}
+
+// internKeys pools the keys of a decoded stream
+func (s *Stream) internKeys() {
+	if s.interner == nil {
+		return
+	}
+	for i := range s.k.elts {
+		e := &s.k.elts[i]
+		e.Key = s.interner.Intern(e.Key)
+		// replaces the key of the index with the pooled one as well
+		s.k.m[e.Key] = i
+	}
+}
@@ -0,0 +1,49 @@
+package topk
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"unsafe"
+)
+
+func TestInterner(t *testing.T) {
+	pool := NewInterner(100)
+	a, b := New(10, WithInterner(pool)), New(10, WithInterner(pool))
+	for i := 0; i < 5; i++ {
+		// distinct strings with equal contents
+		a.Insert(fmt.Sprintf("key-%d", i), 1)
+		b.Insert(fmt.Sprintf("key-%d", i), 2)
+	}
+	if pool.Len() != 5 {
+		t.Errorf("expected 5 pooled keys, got %d", pool.Len())
+	}
+	for _, e := range a.Keys() {
+		if f := b.Estimate(e.Key); unsafe.StringData(f.Key) != unsafe.StringData(e.Key) {
+			t.Errorf("expected %q to be stored once", e.Key)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := a.Encode(&buf); err != nil {
+		t.Fatal(err)
+	}
+	c := New(10, WithInterner(pool))
+	if err := c.Decode(&buf); err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range c.Keys() {
+		if unsafe.StringData(e.Key) != unsafe.StringData(pool.Intern(e.Key)) {
+			t.Errorf("expected decoded %q to be pooled", e.Key)
+		}
+	}
+
+	// a full pool starts over
+	small := NewInterner(2)
+	for i := 0; i < 3; i++ {
+		small.Intern(fmt.Sprint(i))
+	}
+	if small.Len() != 1 {
+		t.Errorf("expected the pool to be emptied, got %d keys", small.Len())
+	}
+}
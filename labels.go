@@ -0,0 +1,148 @@
+package topk
+
+import (
+	"encoding/binary"
+	"maps"
+	"sort"
+	"strings"
+
+	"github.com/tinylib/msgp/msgp"
+)
+
+// WithLabels attaches labels to the stream, e.g. the tenant, the start of
+// the window or the source host. They are carried through Encode and Decode
+// and combined by merges, so the provenance of a snapshot travels with it.
+//
+// Merged labels keep the values both sides agree on. Differing values are
+// combined into the sorted set of values joined by commas, so merging the
+// streams of the hosts a and b labels the result "a,b". Labels required by
+// WithRequiredLabels have to be equal instead.
+func WithLabels(labels map[string]string) Option {
+	return func(s *Stream) {
+		s.labels = maps.Clone(labels)
+	}
+}
+
+// WithRequiredLabels makes the streams whose values of the labels keys
+// differ incompatible with s, see CompatibleWith. A label missing on one
+// side has to be missing on the other.
+func WithRequiredLabels(keys ...string) Option {
+	return func(s *Stream) {
+		s.requiredLabels = keys
+	}
+}
+
+// Labels returns a copy of the stream's labels
+func (s *Stream) Labels() map[string]string {
+	return maps.Clone(s.labels)
+}
+
+// SetLabel sets the label key to value
+func (s *Stream) SetLabel(key, value string) {
+	s.own()
+	if s.labels == nil {
+		s.labels = make(map[string]string)
+	}
+	s.labels[key] = value
+}
+
+// checkLabels returns an error if the required labels of s differ in labels
+func (s *Stream) checkLabels(labels map[string]string) error {
+	for _, key := range s.requiredLabels {
+		got, ok := labels[key]
+		if want, required := s.labels[key]; ok != required || got != want {
+			return incompatible("expected label %s %q, got %q", key, want, got)
+		}
+	}
+	return nil
+}
+
+// combineLabels merges labels into those of s
+func (s *Stream) combineLabels(labels map[string]string) {
+	if len(labels) == 0 {
+		return
+	}
+	if s.labels == nil {
+		s.labels = make(map[string]string, len(labels))
+	}
+	for key, v := range labels {
+		if cur, ok := s.labels[key]; ok && cur != v {
+			v = joinLabel(cur, v)
+		}
+		s.labels[key] = v
+	}
+}
+
+// joinLabel returns the sorted set of the comma separated values of a and b
+func joinLabel(a, b string) string {
+	set := make(map[string]struct{})
+	for _, v := range strings.Split(a+","+b, ",") {
+		set[v] = struct{}{}
+	}
+	values := make([]string, 0, len(set))
+	for v := range set {
+		values = append(values, v)
+	}
+	sort.Strings(values)
+	return strings.Join(values, ",")
+}
+
+// sortedLabels returns the label keys in order, which keeps encodings
+// deterministic
+func (s *Stream) sortedLabels() []string {
+	keys := make([]string, 0, len(s.labels))
+	for key := range s.labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (s *Stream) encodeLabels(w *msgp.Writer) error {
+	if err := w.WriteMapHeader(uint32(len(s.labels))); err != nil {
+		return err
+	}
+	for _, key := range s.sortedLabels() {
+		if err := w.WriteString(key); err != nil {
+			return err
+		}
+		if err := w.WriteString(s.labels[key]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func decodeLabels(r *msgp.Reader) (map[string]string, error) {
+	sz, err := r.ReadMapHeader()
+	if err != nil {
+		return nil, err
+	}
+	hint, err := decodeLen(int(sz))
+	if err != nil {
+		return nil, err
+	}
+	labels := make(map[string]string, hint)
+	for i := uint32(0); i < sz; i++ {
+		key, err := readString(r)
+		if err != nil {
+			return nil, err
+		}
+		if labels[key], err = readString(r); err != nil {
+			return nil, err
+		}
+	}
+	return labels, nil
+}
+
+// appendLabels appends the labels in the compact encoding
+func (s *Stream) appendLabels(b []byte) []byte {
+	b = binary.AppendUvarint(b, uint64(len(s.labels)))
+	for _, key := range s.sortedLabels() {
+		for _, v := range []string{key, s.labels[key]} {
+			b = binary.AppendUvarint(b, uint64(len(v)))
+			b = append(b, v...)
+		}
+	}
+	return b
+}
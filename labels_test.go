@@ -0,0 +1,51 @@
+package topk
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestLabels(t *testing.T) {
+	s := New(10, WithLabels(map[string]string{"tenant": "t1", "host": "a"}))
+	s.Insert("x", 1)
+
+	for _, encode := range []func(*bytes.Buffer) error{
+		func(b *bytes.Buffer) error { return s.Encode(b) },
+		func(b *bytes.Buffer) error { return s.EncodeCompact(b) },
+	} {
+		var buf bytes.Buffer
+		if err := encode(&buf); err != nil {
+			t.Fatal(err)
+		}
+		d := New(10, WithLabels(map[string]string{"stale": "label"}))
+		if err := d.Decode(bytes.NewReader(buf.Bytes())); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(d.Labels(), s.Labels()) {
+			t.Errorf("expected labels %v, got %v", s.Labels(), d.Labels())
+		}
+
+		m := New(10, WithLabels(map[string]string{"tenant": "t1", "host": "b"}))
+		if err := m.MergeEncoded(buf.Bytes()); err != nil {
+			t.Fatal(err)
+		}
+		if want := map[string]string{"tenant": "t1", "host": "a,b"}; !reflect.DeepEqual(m.Labels(), want) {
+			t.Errorf("expected labels %v, got %v", want, m.Labels())
+		}
+	}
+
+	other := New(10, WithLabels(map[string]string{"host": "c"}))
+	other.SetLabel("tenant", "t2")
+	strict := New(10, WithLabels(map[string]string{"tenant": "t1"}), WithRequiredLabels("tenant"))
+	if err := strict.Merge(other); !errors.Is(err, ErrIncompatibleSketch) {
+		t.Errorf("expected ErrIncompatibleSketch, got %v", err)
+	}
+	if err := strict.Merge(s); err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]string{"tenant": "t1", "host": "a"}; !reflect.DeepEqual(strict.Labels(), want) {
+		t.Errorf("expected labels %v, got %v", want, strict.Labels())
+	}
+}
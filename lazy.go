@@ -0,0 +1,148 @@
+package topk
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"sort"
+
+	"github.com/dgryski/go-metro"
+)
+
+// Lazy is a stream in the compact encoding, see EncodeCompact, that is only
+// decoded as far as a query needs it. Opening it reads just the header, Top
+// and Estimate scan the buffer without materializing the alphas or the heap,
+// and Stream decodes everything once for merges and full queries.
+//
+// Keys returned by Top and Estimate reference the buffer, which must not be
+// modified while the Lazy is in use.
+type Lazy struct {
+	b      []byte
+	n      int
+	alphas []byte // the encoded alphas
+	nalpha int
+	elts   []byte // the encoded elements
+	nelts  int
+
+	full *Stream
+}
+
+// OpenLazy reads the header of the stream encoded in b
+func OpenLazy(b []byte) (*Lazy, error) {
+	_, br, n, err := openCompact(b)
+	if err != nil {
+		return nil, err
+	}
+	l := &Lazy{b: b, n: n, nalpha: br.uvarint()}
+	l.alphas = br.b
+	for i := 0; i < l.nalpha && br.err == nil; i++ {
+		br.varint()
+	}
+	l.alphas = l.alphas[:len(l.alphas)-len(br.b)]
+	l.nelts = br.uvarint()
+	l.elts = br.b
+	if br.err != nil {
+		return nil, br.err
+	}
+	if l.nalpha == 0 {
+		return nil, errNoAlphas
+	}
+	if l.nalpha < 0 || l.nelts < 0 {
+		// sizes beyond the int range wrap negative
+		return nil, errShortBuffer
+	}
+	return l, nil
+}
+
+// N returns the number of elements the stream was created to track
+func (l *Lazy) N() int { return l.n }
+
+// Len returns the number of elements in the encoding
+func (l *Lazy) Len() int { return l.nelts }
+
+// each calls fn for the encoded elements until it returns false
+func (l *Lazy) each(fn func(Element) bool) error {
+	br := bytesReader{b: l.elts}
+	for i := 0; i < l.nelts; i++ {
+		e := Element{Key: br.string(br.uvarint()), Count: br.varint(), Error: br.varint()}
+		if br.err != nil {
+			return br.err
+		}
+		if !fn(e) {
+			break
+		}
+	}
+	return nil
+}
+
+// Top returns the k most frequent elements without decoding the stream, the
+// first k of Keys of the decoded stream
+func (l *Lazy) Top(k int) ([]Element, error) {
+	if k > l.n {
+		k = l.n
+	}
+	if k <= 0 {
+		return nil, nil
+	}
+	top := make([]Element, 0, k+1)
+	err := l.each(func(e Element) bool {
+		// ordered like SortElements, ties are broken by key
+		if len(top) == k && !ElementLess(e, top[k-1]) {
+			return true
+		}
+		i := sort.Search(len(top), func(i int) bool { return ElementLess(e, top[i]) })
+		top = append(top, Element{})
+		copy(top[i+1:], top[i:])
+		top[i] = e
+		if len(top) > k {
+			top = top[:k]
+		}
+		return true
+	})
+	return top, err
+}
+
+// Estimate returns an estimate for the item x without decoding the stream
+func (l *Lazy) Estimate(x string) (Element, error) {
+	var (
+		found Element
+		ok    bool
+	)
+	err := l.each(func(e Element) bool {
+		found, ok = e, e.Key == x
+		return !ok
+	})
+	if err != nil || ok {
+		return found, err
+	}
+
+	br := bytesReader{b: l.alphas}
+	idx := int(reduce(metro.Hash64Str(x, 0), l.nalpha))
+	for i := 0; i < idx; i++ {
+		br.varint()
+	}
+	count := br.varint()
+	return Element{Key: x, Count: count, Error: count}, br.err
+}
+
+// Stream decodes the whole stream, it is decoded only once and must not be
+// modified.
+func (l *Lazy) Stream() (*Stream, error) {
+	if l.full == nil {
+		s := &Stream{}
+		if err := s.decodeCompact(context.Background(), bufio.NewReader(bytes.NewReader(l.b))); err != nil {
+			return nil, err
+		}
+		l.full = s
+	}
+	return l.full, nil
+}
+
+// MergeInto merges the stream into s
+func (l *Lazy) MergeInto(s *Stream) error {
+	full, err := l.Stream()
+	if err != nil {
+		return err
+	}
+	return s.Merge(full)
+}
@@ -0,0 +1,90 @@
+package topk
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestLazy(t *testing.T) {
+	s := New(100)
+	for i, w := range loadWords() {
+		s.Insert(w, 1+i%7)
+	}
+	buf := bytes.NewBuffer(nil)
+	if err := s.EncodeCompact(buf); err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := OpenLazy(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.N() != 100 || l.Len() != len(s.k.elts) {
+		t.Errorf("unexpected header: n=%d len=%d", l.N(), l.Len())
+	}
+	if l.full != nil {
+		t.Error("stream decoded on open")
+	}
+
+	top, err := l.Top(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := s.Keys()
+	if len(top) != 10 {
+		t.Fatalf("expected 10 elements, got %d", len(top))
+	}
+	for i, e := range top {
+		if e.Count != keys[i].Count {
+			t.Errorf("top %d: expected count %d, got %v", i, keys[i].Count, e)
+		}
+	}
+	for _, x := range []string{keys[0].Key, "not a word"} {
+		got, err := l.Estimate(x)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := s.Estimate(x); got != want {
+			t.Errorf("estimate of %q differs: %v != %v", x, got, want)
+		}
+	}
+	if l.full != nil {
+		t.Error("stream decoded by queries")
+	}
+
+	merged := New(100)
+	if err := l.MergeInto(merged); err != nil {
+		t.Fatal(err)
+	}
+	if !merged.Equal(s) {
+		t.Error("merged stream differs")
+	}
+}
+
+func TestLazyTopTies(t *testing.T) {
+	s := New(50)
+	for i := 0; i < 50; i++ {
+		s.Insert(fmt.Sprintf("key-%d", i), 1+i%3)
+	}
+	buf := bytes.NewBuffer(nil)
+	if err := s.EncodeCompact(buf); err != nil {
+		t.Fatal(err)
+	}
+	l, err := OpenLazy(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys := s.Keys()
+	for k := 1; k <= len(keys); k++ {
+		top, err := l.Top(k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(top, keys[:k]) {
+			t.Fatalf("top %d differs from the keys:\n%v\n%v", k, top, keys[:k])
+		}
+	}
+}
@@ -0,0 +1,19 @@
+package topk
+
+import (
+	"bufio"
+	"io"
+)
+
+// CountLines inserts every line read from r into s with a count of 1. Line
+// endings are stripped, empty lines are skipped.
+// It returns the first error encountered reading r, if any.
+func CountLines(r io.Reader, s *Stream) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if line := scanner.Text(); line != "" {
+			s.Insert(line, 1)
+		}
+	}
+	return scanner.Err()
+}
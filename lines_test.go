@@ -0,0 +1,53 @@
+package topk
+
+import (
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestCountLines(t *testing.T) {
+	f, err := os.Open("testdata/domains.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	tk := New(100)
+	if err := CountLines(f, tk); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	b, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exact := exactCount(strings.Fields(string(b)))
+	top := exactTop(exact)
+	skTop := tk.Keys()
+	for i, w := range top[:10] {
+		if w != skTop[i].Key && exact[w] != skTop[i].Count {
+			t.Errorf("Expected top %d to be '%s'(%d) found '%s'(%d)", i, w, exact[w], skTop[i].Key, skTop[i].Count)
+		}
+	}
+}
+
+type failingReader struct{}
+
+func (failingReader) Read([]byte) (int, error) { return 0, errors.New("broken") }
+
+func TestCountLinesError(t *testing.T) {
+	r := io.MultiReader(strings.NewReader("a\nb\n"), failingReader{})
+	tk := New(10)
+	if err := CountLines(r, tk); err == nil || err.Error() != "broken" {
+		t.Errorf("expected the read error, got %v", err)
+	}
+	if tk.Estimate("a").Count != 1 {
+		t.Error("expected the lines before the error to be counted")
+	}
+}
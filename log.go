@@ -0,0 +1,81 @@
+package topk
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// LogWriter writes inserts to an append-only log that Replay turns into a
+// stream, so that tiny agents can ship raw observations instead of keeping a
+// stream themselves.
+//
+// Each record is the uvarint length of the key, the key and the varint
+// count. The log has no header, logs can be concatenated and appended to.
+type LogWriter struct {
+	w   *bufio.Writer
+	buf []byte
+}
+
+// NewLogWriter returns a LogWriter appending to w
+func NewLogWriter(w io.Writer) *LogWriter {
+	return &LogWriter{w: bufio.NewWriter(w)}
+}
+
+// Insert appends an insert of x with the given count to the log
+func (l *LogWriter) Insert(x string, count int) error {
+	l.buf = binary.AppendUvarint(l.buf[:0], uint64(len(x)))
+	l.buf = append(l.buf, x...)
+	l.buf = binary.AppendVarint(l.buf, int64(count))
+	_, err := l.w.Write(l.buf)
+	return err
+}
+
+// Flush writes any buffered records to the underlying writer
+func (l *LogWriter) Flush() error {
+	return l.w.Flush()
+}
+
+// Replay inserts all records of the log read from r into s in order. A
+// record cut short at the end of the log returns io.ErrUnexpectedEOF after
+// the complete records have been inserted.
+func Replay(r io.Reader, s *Stream) error {
+	br := bufio.NewReader(r)
+	var key []byte
+	for {
+		sz, err := binary.ReadUvarint(br)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if sz > uint64(maxLogKeyLen) {
+			return errors.New("topk: log key too long")
+		}
+		if cap(key) < int(sz) {
+			key = make([]byte, sz)
+		}
+		key = key[:sz]
+		if _, err := io.ReadFull(br, key); err != nil {
+			return unexpected(err)
+		}
+		count, err := binary.ReadVarint(br)
+		if err != nil {
+			return unexpected(err)
+		}
+		s.Insert(string(key), int(count))
+	}
+}
+
+// maxLogKeyLen bounds the allocation of a corrupted key length
+const maxLogKeyLen = 1 << 20
+
+// unexpected turns an EOF in the middle of a record into ErrUnexpectedEOF
+func unexpected(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,36 @@
+package topk
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestReplay(t *testing.T) {
+	words := loadWords()
+	want := New(100)
+	buf := bytes.NewBuffer(nil)
+	log := NewLogWriter(buf)
+	for i, w := range words {
+		want.Insert(w, 1+i%3)
+		if err := log.Insert(w, 1+i%3); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := log.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	b := buf.Bytes()
+
+	s := New(100)
+	if err := Replay(bytes.NewReader(b), s); err != nil {
+		t.Fatal(err)
+	}
+	if !s.Equal(want) {
+		t.Error("replayed stream differs")
+	}
+
+	if err := Replay(bytes.NewReader(b[:len(b)-1]), New(100)); err != io.ErrUnexpectedEOF {
+		t.Errorf("expected an unexpected EOF for a truncated log, got %v", err)
+	}
+}
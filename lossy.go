@@ -0,0 +1,263 @@
+package topk
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+
+	"github.com/tinylib/msgp/msgp"
+)
+
+const lossyMagic = "topk/lossy"
+
+// LossyCounting estimates the frequent elements of a stream with the Lossy
+// Counting algorithm of Manku and Motwani, as an alternative to the Filtered
+// Space-Saving of Stream.
+//
+// The stream is divided into buckets of 1/epsilon occurrences, at the end of
+// each bucket the keys that can't have a frequency above epsilon are pruned.
+// Counts are underestimated by at most epsilon times the stream length, the
+// error of each element bounds it.
+type LossyCounting struct {
+	epsilon float64
+	width   int // occurrences per bucket
+	total   int
+	entries map[string]lossyEntry
+}
+
+type lossyEntry struct {
+	count int // occurrences since the key was tracked
+	delta int // maximum occurrences before that
+}
+
+// NewLossyCounting returns a LossyCounting with the error epsilon, which
+// has to be in (0, 1)
+func NewLossyCounting(epsilon float64) (*LossyCounting, error) {
+	width, err := lossyWidth(epsilon)
+	if err != nil {
+		return nil, err
+	}
+	return &LossyCounting{
+		epsilon: epsilon,
+		width:   width,
+		entries: make(map[string]lossyEntry),
+	}, nil
+}
+
+// lossyWidth returns the bucket width for epsilon
+func lossyWidth(epsilon float64) (int, error) {
+	// NaN fails the range check too
+	if !(epsilon > 0 && epsilon < 1) {
+		return 0, fmt.Errorf("topk: epsilon %v out of range (0, 1)", epsilon)
+	}
+	width := math.Ceil(1 / epsilon)
+	if width >= math.MaxInt {
+		return 0, fmt.Errorf("topk: epsilon %v too small", epsilon)
+	}
+	return int(width), nil
+}
+
+// bucket returns the id of the current bucket
+func (s *LossyCounting) bucket() int {
+	return s.total/s.width + 1
+}
+
+// Insert adds count occurrences of x to the stream.
+// It returns an estimation for the just inserted element
+func (s *LossyCounting) Insert(x string, count int) Element {
+	for count > 0 {
+		// process the occurrences up to the end of the bucket
+		step := s.width - s.total%s.width
+		if count < step {
+			step = count
+		}
+
+		e, ok := s.entries[x]
+		if !ok {
+			e.delta = s.bucket() - 1
+		}
+		e.count += step
+		s.entries[x] = e
+		s.total += step
+		count -= step
+
+		if s.total%s.width == 0 {
+			s.prune()
+		}
+	}
+	return s.Estimate(x)
+}
+
+// prune drops the entries that can't be frequent at the end of a bucket
+func (s *LossyCounting) prune() {
+	b := s.total / s.width
+	for x, e := range s.entries {
+		if e.count+e.delta <= b {
+			delete(s.entries, x)
+		}
+	}
+}
+
+// Estimate returns an estimate for the item x, keys not tracked occurred at
+// most once per bucket
+func (s *LossyCounting) Estimate(x string) Element {
+	if e, ok := s.entries[x]; ok {
+		return Element{Key: x, Count: e.count + e.delta, Error: e.delta}
+	}
+	b := s.bucket() - 1
+	return Element{Key: x, Count: b, Error: b}
+}
+
+// Keys returns all tracked elements ordered like Stream.Keys
+func (s *LossyCounting) Keys() []Element {
+	elts := make([]Element, 0, len(s.entries))
+	for x, e := range s.entries {
+		elts = append(elts, Element{Key: x, Count: e.count + e.delta, Error: e.delta})
+	}
+	SortElements(elts)
+	return elts
+}
+
+// Frequent returns the elements with a frequency of at least support,
+// possibly including some with a frequency of support-epsilon
+func (s *LossyCounting) Frequent(support float64) []Element {
+	min := (support - s.epsilon) * float64(s.total)
+	var elts []Element
+	for _, e := range s.Keys() {
+		if float64(e.LowerBound()) >= min {
+			elts = append(elts, e)
+		}
+	}
+	return elts
+}
+
+// Total returns the sum of the counts of all inserted elements
+func (s *LossyCounting) Total() int {
+	return s.total
+}
+
+// MergeSketch merges other, which has to be a *LossyCounting, into s like Merge
+func (s *LossyCounting) MergeSketch(other Sketch) error {
+	o, ok := other.(*LossyCounting)
+	if !ok {
+		if other == nil {
+			return ErrNilSketch
+		}
+		return incompatible("cannot merge %T into %T", other, s)
+	}
+	return s.Merge(o)
+}
+
+// Merge adds other to s. Keys tracked by only one of the summaries get the
+// other one's bound for untracked keys added to their count and error, the
+// merged summary is pruned against the combined length.
+func (s *LossyCounting) Merge(other *LossyCounting) error {
+	if other == nil {
+		return ErrNilSketch
+	}
+	if s.width != other.width {
+		return incompatible("expected lossy counting with bucket width %d, got %d", s.width, other.width)
+	}
+
+	ownMissing, otherMissing := s.bucket()-1, other.bucket()-1
+	for x, e := range s.entries {
+		if o, ok := other.entries[x]; ok {
+			e.count += o.count
+			e.delta += o.delta
+		} else {
+			e.delta += otherMissing
+		}
+		s.entries[x] = e
+	}
+	for x, o := range other.entries {
+		if _, ok := s.entries[x]; !ok {
+			o.delta += ownMissing
+			s.entries[x] = o
+		}
+	}
+	s.total += other.total
+	s.prune()
+	return nil
+}
+
+// Encode writes the summary to w
+func (s *LossyCounting) Encode(w io.Writer) error {
+	wrt := msgp.NewWriter(w)
+	if err := encodeHeader(wrt, lossyMagic); err != nil {
+		return err
+	}
+	if err := wrt.WriteFloat64(s.epsilon); err != nil {
+		return err
+	}
+	if err := wrt.WriteInt(s.total); err != nil {
+		return err
+	}
+
+	keys := make([]string, 0, len(s.entries))
+	for x := range s.entries {
+		keys = append(keys, x)
+	}
+	sort.Strings(keys)
+	if err := wrt.WriteArrayHeader(uint32(len(keys))); err != nil {
+		return err
+	}
+	for _, x := range keys {
+		e := s.entries[x]
+		if err := wrt.WriteString(x); err != nil {
+			return err
+		}
+		if err := wrt.WriteInt(e.count); err != nil {
+			return err
+		}
+		if err := wrt.WriteInt(e.delta); err != nil {
+			return err
+		}
+	}
+	return wrt.Flush()
+}
+
+// Decode replaces s with the summary read from r. s is left unchanged on
+// errors.
+func (s *LossyCounting) Decode(r io.Reader) error {
+	rdr := msgp.NewReader(r)
+	if err := expectHeader(rdr, lossyMagic); err != nil {
+		return err
+	}
+
+	epsilon, err := rdr.ReadFloat64()
+	if err != nil {
+		return err
+	}
+	d, err := NewLossyCounting(epsilon)
+	if err != nil {
+		return fmt.Errorf("topk: corrupt encoding: %w", err)
+	}
+	if d.total, err = rdr.ReadInt(); err != nil {
+		return err
+	}
+
+	sz, err := rdr.ReadArrayHeader()
+	if err != nil {
+		return err
+	}
+	if _, err := decodeLen(int(sz)); err != nil {
+		return err
+	}
+	for i := uint32(0); i < sz; i++ {
+		var e lossyEntry
+		x, err := readString(rdr)
+		if err != nil {
+			return err
+		}
+		if e.count, err = rdr.ReadInt(); err != nil {
+			return err
+		}
+		if e.delta, err = rdr.ReadInt(); err != nil {
+			return err
+		}
+		d.entries[x] = e
+	}
+	*s = *d
+	return nil
+}
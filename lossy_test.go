@@ -0,0 +1,109 @@
+package topk
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/tinylib/msgp/msgp"
+)
+
+// newLossy returns a LossyCounting with the valid error epsilon
+func newLossy(t testing.TB, epsilon float64) *LossyCounting {
+	t.Helper()
+	s, err := NewLossyCounting(epsilon)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestLossyCounting(t *testing.T) {
+	insert := func(s *LossyCounting, offset int) {
+		for i := 0; i < 50000; i++ {
+			switch {
+			case i%5 == 0:
+				s.Insert("a", 1)
+			case i%10 == 1:
+				s.Insert("b", 1)
+			default:
+				s.Insert(fmt.Sprintf("noise-%d", i+offset), 1)
+			}
+		}
+	}
+
+	s := newLossy(t, 0.001)
+	insert(s, 0)
+	if len(s.entries) > 2000 {
+		t.Errorf("expected the noise to be pruned, got %d entries", len(s.entries))
+	}
+
+	check := func(s *LossyCounting, scale int) {
+		t.Helper()
+		keys := s.Frequent(0.05)
+		if len(keys) != 2 || keys[0].Key != "a" || keys[1].Key != "b" {
+			t.Fatalf("expected a and b, got %v", keys)
+		}
+		for key, exact := range map[string]int{"a": 10000 * scale, "b": 5000 * scale} {
+			e := s.Estimate(key)
+			if e.LowerBound() > exact || e.UpperBound() < exact || e.Error > s.Total()/1000 {
+				t.Errorf("expected %d for %s within the bounds of %v", exact, key, e)
+			}
+		}
+		if e := s.Estimate("noise-1"); e.Count > s.Total()/1000 {
+			t.Errorf("expected untracked keys to be bounded by epsilon N, got %v", e)
+		}
+	}
+	check(s, 1)
+
+	other := newLossy(t, 0.001)
+	insert(other, 50000)
+	if err := s.Merge(other); err != nil {
+		t.Fatal(err)
+	}
+	check(s, 2)
+	if err := s.Merge(newLossy(t, 0.01)); err == nil {
+		t.Error("expected merging a different epsilon to fail")
+	}
+
+	buf := bytes.NewBuffer(nil)
+	if err := s.Encode(buf); err != nil {
+		t.Fatal(err)
+	}
+	enc := buf.Bytes()
+	decoded := &LossyCounting{}
+	if err := decoded.Decode(bytes.NewReader(enc)); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, s) {
+		t.Error("decoded summary differs")
+	}
+	if err := New(10).Decode(bytes.NewReader(enc)); err == nil {
+		t.Error("expected decoding a lossy counting as stream to fail")
+	}
+}
+
+func TestLossyCountingEpsilon(t *testing.T) {
+	for _, epsilon := range []float64{0, -0.1, 1, 2, math.Inf(1), math.NaN(), 1e-300} {
+		if _, err := NewLossyCounting(epsilon); err == nil {
+			t.Errorf("expected an error for epsilon %v", epsilon)
+		}
+
+		var buf bytes.Buffer
+		w := msgp.NewWriter(&buf)
+		encodeHeader(w, lossyMagic)
+		w.WriteFloat64(epsilon)
+		w.WriteInt(0)
+		w.WriteArrayHeader(0)
+		w.Flush()
+		s := newLossy(t, 0.1)
+		if err := s.Decode(&buf); err == nil {
+			t.Errorf("expected decoding epsilon %v to fail", epsilon)
+		}
+		if s.epsilon != 0.1 {
+			t.Errorf("expected the failed decode to leave epsilon, got %v", s.epsilon)
+		}
+	}
+}
@@ -0,0 +1,497 @@
+package topk
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"time"
+
+	"github.com/dgryski/go-metro"
+	"github.com/tinylib/msgp/msgp"
+)
+
+// MergeEncodedAll merges the encoded sketches read from readers into a new
+// Stream.
+//
+// The sketches are folded one at a time straight from their encoding: only the
+// merged elements and a single scratch alpha array are kept in memory, no
+// intermediate Stream, map or heap is built for the individual inputs.
+// Elements are only trimmed to n once all inputs are merged.
+func MergeEncodedAll(readers []io.Reader) (*Stream, error) {
+	return MergeEncodedAllContext(context.Background(), readers)
+}
+
+// MergeEncodedAllContext is like MergeEncodedAll but gives up with the
+// context's error once ctx is done
+func MergeEncodedAllContext(ctx context.Context, readers []io.Reader) (*Stream, error) {
+	type acc struct {
+		e Element
+		// sum of the alphas of the inputs tracking the element, the alphas of
+		// all other inputs are added on top once everything has been read
+		alpha int
+	}
+
+	var (
+		s        *Stream
+		scratch  []int
+		merged   = make(map[string]*acc)
+		distinct = newHLL()
+	)
+
+	// start checks the size of input i, sets up s with the first one and
+	// adds the alphas of the input, which are kept in scratch
+	start := func(i, n int, alphas []int) error {
+		if s == nil {
+			// sizes are checked like those of a decoded stream
+			first := &Stream{n: n, alphas: alphas}
+			if err := first.checkDecoded(); err != nil {
+				return err
+			}
+			s = &Stream{n: n, alphas: make([]int, len(alphas))}
+		}
+		if n != s.n {
+			return incompatible("sketch %d: expected stream of size n %d, got %d", i, s.n, n)
+		}
+		if len(alphas) != len(s.alphas) {
+			return incompatible("sketch %d: expected %d alphas, got %d", i, len(s.alphas), len(alphas))
+		}
+		for j, a := range alphas {
+			s.alphas[j] = satAdd(s.alphas[j], a)
+		}
+		scratch = alphas
+		return nil
+	}
+	// add merges an element of the input whose alphas are in scratch
+	add := func(e Element) {
+		a, ok := merged[e.Key]
+		if !ok {
+			a = &acc{e: Element{Key: e.Key}}
+			merged[e.Key] = a
+		}
+		a.e.Count = satAdd(a.e.Count, e.Count)
+		a.e.Error = satAdd(a.e.Error, e.Error)
+		a.alpha = satAdd(a.alpha, scratch[reduce(metro.Hash64Str(e.Key, 0), len(scratch))])
+	}
+	// addFields merges the fields of an input
+	addFields := func(fields *Stream) {
+		s.total = satAdd(s.total, fields.total)
+		s.inserts = satAdd(s.inserts, fields.inserts)
+		s.admissions = satAdd(s.admissions, fields.admissions)
+		s.probabilistic = s.probabilistic || fields.probabilistic
+		if fields.replacement != AlphaReplacement {
+			s.replacement = fields.replacement
+		}
+		s.stats.Evictions = satAdd(s.stats.Evictions, fields.stats.Evictions)
+		s.combineLabels(fields.labels)
+		if fields.distinct != nil && distinct != nil {
+			distinct.merge(fields.distinct)
+		} else {
+			// the keys of one of the inputs are unknown
+			distinct = nil
+		}
+	}
+
+	for i, rd := range readers {
+		br := bufio.NewReader(rd)
+		if isCompact(br) {
+			// compact inputs are small enough to be decoded at once
+			in := &Stream{}
+			if err := in.decodeCompact(ctx, br); err != nil {
+				return nil, err
+			}
+			if err := start(i, in.n, in.alphas); err != nil {
+				return nil, err
+			}
+			for _, e := range in.k.elts {
+				add(e)
+			}
+			addFields(in)
+			continue
+		}
+
+		r := msgp.NewReader(br)
+
+		version, err := decodeHeader(r)
+		if err != nil {
+			return nil, err
+		}
+
+		n, err := r.ReadInt()
+		if err != nil {
+			return nil, err
+		}
+		sz, err := r.ReadArrayHeader()
+		if err != nil {
+			return nil, err
+		}
+		hint, err := decodeLen(int(sz))
+		if err != nil {
+			return nil, err
+		}
+		// the scratch array grows with the alphas actually read
+		alphas := scratch[:0]
+		if alphas == nil {
+			alphas = make([]int, 0, hint)
+		}
+		for j := 0; j < int(sz); j++ {
+			if err := canceled(ctx, j); err != nil {
+				return nil, err
+			}
+			a, err := r.ReadInt()
+			if err != nil {
+				return nil, err
+			}
+			alphas = append(alphas, a)
+		}
+		if err := start(i, n, alphas); err != nil {
+			return nil, err
+		}
+
+		// the index map is redundant with the elements
+		if sz, err = r.ReadMapHeader(); err != nil {
+			return nil, err
+		}
+		for j := uint32(0); j < 2*sz; j++ {
+			if err := r.Skip(); err != nil {
+				return nil, err
+			}
+		}
+
+		if sz, err = r.ReadArrayHeader(); err != nil {
+			return nil, err
+		}
+		if sz > 0 && len(scratch) == 0 {
+			return nil, fmt.Errorf("topk: corrupt encoding: %d elements without alphas", sz)
+		}
+		for j := uint32(0); j < sz; j++ {
+			if err := canceled(ctx, int(j)); err != nil {
+				return nil, err
+			}
+			var e Element
+			if e.Key, err = readString(r); err != nil {
+				return nil, err
+			}
+			if e.Count, err = r.ReadInt(); err != nil {
+				return nil, err
+			}
+			if e.Error, err = r.ReadInt(); err != nil {
+				return nil, err
+			}
+			add(e)
+		}
+
+		var fields Stream
+		if version > 0 {
+			if err := fields.decodeFields(r); err != nil {
+				return nil, err
+			}
+		}
+		addFields(&fields)
+	}
+
+	if s == nil {
+		return nil, ErrNoSketches
+	}
+
+	elts := make([]Element, 0, len(merged))
+	for _, a := range merged {
+		// inputs not tracking the element contribute their alpha
+		missing := s.alphas[reduce(metro.Hash64Str(a.e.Key, 0), len(s.alphas))] - a.alpha
+		a.e.Count = satAdd(a.e.Count, missing)
+		a.e.Error = satAdd(a.e.Error, missing)
+		elts = append(elts, a.e)
+	}
+	s.distinct = distinct
+	s.replaceElements(elts)
+	return s, nil
+}
+
+// MergeWeighted merges other into s with its counts scaled by scale, e.g. to
+// combine sketches sampled at different rates. Counts and alphas are rounded
+// up and lower bounds down, so the scaled bounds still hold the scaled true
+// counts.
+func (s *Stream) MergeWeighted(other *Stream, scale float64) error {
+	if err := s.CompatibleWith(other); err != nil {
+		return err
+	}
+	if scale <= 0 || math.IsInf(scale, 0) || math.IsNaN(scale) {
+		return fmt.Errorf("topk: invalid scale %v", scale)
+	}
+	return s.Merge(other.scaled(scale))
+}
+
+// scaled returns a copy of s with its counts scaled by scale
+func (s *Stream) scaled(scale float64) *Stream {
+	c := s.clone()
+	for i, e := range c.k.elts {
+		count := satInt(math.Ceil(float64(e.Count) * scale))
+		lower := satInt(math.Floor(float64(e.LowerBound()) * scale))
+		c.k.elts[i] = Element{Key: e.Key, Count: count, Error: count - lower}
+	}
+	c.k.init()
+	for i, a := range c.alphas {
+		c.alphas[i] = satInt(math.Ceil(float64(a) * scale))
+	}
+	c.total = satInt(math.Round(float64(c.total) * scale))
+	return c
+}
+
+// MergeAbove merges other into s, but only the elements of other whose lower
+// bound is at least minCount, so the noise of many small streams doesn't
+// push heavy hitters out of s. The counts of the skipped elements raise the
+// alphas they hash to, which keeps the estimates of all keys upper bounds.
+func (s *Stream) MergeAbove(other *Stream, minCount int) error {
+	if err := s.CompatibleWith(other); err != nil {
+		return err
+	}
+	c := other.clone()
+	elts := c.k.elts[:0]
+	for _, e := range c.k.elts {
+		if e.LowerBound() >= minCount {
+			elts = append(elts, e)
+			continue
+		}
+		delete(c.k.m, e.Key)
+		if i := reduce(metro.Hash64Str(e.Key, 0), len(c.alphas)); e.Count > c.alphas[i] {
+			c.alphas[i] = e.Count
+		}
+	}
+	c.k.elts = elts
+	for i, e := range elts {
+		c.k.m[e.Key] = i
+	}
+	// a key kept twice would leave one of its elements unindexed
+	if err := c.checkDecoded(); err != nil {
+		return err
+	}
+	c.k.init()
+	return s.Merge(c)
+}
+
+// MergeDecode merges the stream read from r into s. Streams of another size
+// or hashing keys differently return ErrIncompatibleSketch.
+func (s *Stream) MergeDecode(r io.Reader) error {
+	other := &Stream{}
+	if err := other.Decode(r); err != nil {
+		return err
+	}
+	return s.Merge(other)
+}
+
+// MergeEncoded merges the stream encoded in b, by Encode or EncodeCompact,
+// into s. The elements are merged straight from the buffer without decoding
+// the stream: only the incoming alphas and the keys s doesn't track yet are
+// copied. s is left unchanged on errors.
+func (s *Stream) MergeEncoded(b []byte) error {
+	if s.timings.on {
+		defer s.timings.merged(time.Now())
+	}
+	m := &encodedMerge{
+		s:    s,
+		elts: append([]Element(nil), s.k.elts...),
+		seen: make([]bool, len(s.k.elts)),
+	}
+	var err error
+	if len(b) > 0 && b[0] == compactMagic {
+		err = m.compact(b)
+	} else {
+		err = m.msgp(b)
+	}
+	if err != nil {
+		return err
+	}
+	if err := s.checkLabels(m.fields.labels); err != nil {
+		return err
+	}
+	m.finish()
+	return nil
+}
+
+// encodedMerge is the state of MergeEncoded
+type encodedMerge struct {
+	s      *Stream
+	alphas []int     // the incoming alphas
+	elts   []Element // the merged elements, those of s first
+	seen   []bool    // which elements of s are also incoming
+	fields Stream    // the incoming counters
+}
+
+func (m *encodedMerge) start(n, alphas int) error {
+	if n != m.s.n {
+		return incompatible("expected stream of size n %d, got %d", m.s.n, n)
+	}
+	if alphas != len(m.s.alphas) {
+		return incompatible("expected %d alphas, got %d", len(m.s.alphas), alphas)
+	}
+	m.alphas = make([]int, alphas)
+	return nil
+}
+
+// add merges an incoming element
+func (m *encodedMerge) add(key []byte, count, errs int) {
+	s := m.s
+	if idx, ok := s.k.m[string(key)]; ok {
+		e := &m.elts[idx]
+		e.Count = satAdd(e.Count, count)
+		e.Error = satAdd(e.Error, errs)
+		m.seen[idx] = true
+		return
+	}
+	min := s.alphas[reduce(metro.Hash64(key, 0), len(s.alphas))]
+	m.elts = append(m.elts, Element{
+		Key:   string(key),
+		Count: satAdd(count, min),
+		Error: satAdd(errs, min),
+	})
+}
+
+// finish applies the merge to s
+func (m *encodedMerge) finish() {
+	s := m.s
+	s.own()
+	for i, seen := range m.seen {
+		if seen {
+			continue
+		}
+		e := &m.elts[i]
+		min := m.alphas[reduce(metro.Hash64Str(e.Key, 0), len(m.alphas))]
+		e.Count = satAdd(e.Count, min)
+		e.Error = satAdd(e.Error, min)
+	}
+	for i, a := range m.alphas {
+		s.alphas[i] = satAdd(s.alphas[i], a)
+	}
+	s.mergeCounters(&m.fields)
+	s.resetTier()
+	s.replaceElements(m.elts)
+	if s.hooks != nil {
+		s.hooks.OnMerge(len(s.k.elts))
+	}
+}
+
+func (m *encodedMerge) msgp(b []byte) error {
+	var (
+		version int
+		err     error
+	)
+	if msgp.NextType(b) == msgp.StrType {
+		var magic []byte
+		if magic, b, err = msgp.ReadStringZC(b); err != nil {
+			return err
+		}
+		if string(magic) != encodingMagic {
+			return fmt.Errorf("%w %q", ErrUnexpectedMagic, magic)
+		}
+		if version, b, err = msgp.ReadIntBytes(b); err != nil {
+			return err
+		}
+		if err := checkVersion(version); err != nil {
+			return err
+		}
+	}
+
+	n, b, err := msgp.ReadIntBytes(b)
+	if err != nil {
+		return err
+	}
+	sz, b, err := msgp.ReadArrayHeaderBytes(b)
+	if err != nil {
+		return err
+	}
+	if err := m.start(n, int(sz)); err != nil {
+		return err
+	}
+	for i := range m.alphas {
+		if m.alphas[i], b, err = msgp.ReadIntBytes(b); err != nil {
+			return err
+		}
+	}
+
+	// the index map is redundant with the elements
+	if sz, b, err = msgp.ReadMapHeaderBytes(b); err != nil {
+		return err
+	}
+	for i := uint32(0); i < 2*sz; i++ {
+		if b, err = msgp.Skip(b); err != nil {
+			return err
+		}
+	}
+
+	if sz, b, err = msgp.ReadArrayHeaderBytes(b); err != nil {
+		return err
+	}
+	for i := uint32(0); i < sz; i++ {
+		var (
+			key         []byte
+			count, errs int
+		)
+		if key, b, err = msgp.ReadStringZC(b); err != nil {
+			return err
+		}
+		if count, b, err = msgp.ReadIntBytes(b); err != nil {
+			return err
+		}
+		if errs, b, err = msgp.ReadIntBytes(b); err != nil {
+			return err
+		}
+		m.add(key, count, errs)
+	}
+
+	if version > 0 {
+		return m.fields.decodeFields(msgp.NewReader(bytes.NewReader(b)))
+	}
+	return nil
+}
+
+func (m *encodedMerge) compact(b []byte) error {
+	flags, br, n, err := openCompact(b)
+	if err != nil {
+		return err
+	}
+	sz := br.uvarint()
+	if br.err != nil {
+		return br.err
+	}
+	if err := m.start(n, sz); err != nil {
+		return err
+	}
+	for i := range m.alphas {
+		m.alphas[i] = br.varint()
+	}
+	sz = br.uvarint()
+	for i := 0; i < sz && br.err == nil; i++ {
+		key := br.raw(br.uvarint())
+		count, errs := br.varint(), br.varint()
+		if br.err == nil {
+			m.add(key, count, errs)
+		}
+	}
+
+	m.fields.total = br.varint()
+	m.fields.inserts = br.varint()
+	m.fields.admissions = br.varint()
+	m.fields.stats.Evictions = br.varint()
+	if flags&compactDistinct != 0 {
+		if regs := br.raw(1 << hllPrecision); br.err == nil {
+			m.fields.distinct = &hll{regs: regs}
+		}
+	}
+	if flags&compactPromotion != 0 {
+		br.uvarint()
+	}
+	if flags&compactLabels != 0 {
+		sz := br.uvarint()
+		if sz > len(br.b) {
+			return errShortBuffer
+		}
+		m.fields.labels = make(map[string]string, sz)
+		for i := 0; i < sz && br.err == nil; i++ {
+			key := string(br.raw(br.uvarint()))
+			m.fields.labels[key] = string(br.raw(br.uvarint()))
+		}
+	}
+	return br.err
+}
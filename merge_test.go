@@ -0,0 +1,189 @@
+package topk
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestMergeEncodedAll(t *testing.T) {
+	words := loadWords()
+
+	// Words in prime index positions are copied
+	for _, p := range []int{2, 3, 5, 7, 11, 13, 17, 23} {
+		for i := p; i < len(words); i += p {
+			words[i] = words[p]
+		}
+	}
+
+	var (
+		sketches []*Stream
+		readers  []io.Reader
+	)
+	for _, slice := range split(words, 2) {
+		sk := New(20)
+		for _, w := range slice {
+			sk.Insert(w, 1)
+		}
+		buf := bytes.NewBuffer(nil)
+		if err := sk.Encode(buf); err != nil {
+			t.Fatal(err)
+		}
+		sketches = append(sketches, sk)
+		readers = append(readers, buf)
+	}
+
+	merged, err := MergeEncodedAll(readers)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// for two inputs the streamed merge is the same as the pairwise one
+	if err := sketches[0].Merge(sketches[1]); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(merged.Keys(), sketches[0].Keys()) {
+		t.Errorf("%v != %v", merged.Keys(), sketches[0].Keys())
+	}
+	if !reflect.DeepEqual(merged.alphas, sketches[0].alphas) {
+		t.Errorf("merged alphas differ")
+	}
+
+	if _, err := MergeEncodedAll(nil); err != ErrNoSketches {
+		t.Errorf("expected ErrNoSketches merging no sketches, got %v", err)
+	}
+}
+
+func TestMergeWeighted(t *testing.T) {
+	a, b := New(5), New(5)
+	a.Insert("x", 10)
+	b.Insert("x", 3)
+	b.Insert("y", 5)
+	b.k.elts[b.k.m["y"]].Error = 1
+
+	if err := a.MergeWeighted(b, 2.5); err != nil {
+		t.Fatal(err)
+	}
+	// 3*2.5 is rounded up to 8 and down to 7, 5*2.5 up to 13 with the
+	// lower bound 4*2.5
+	if e := a.Estimate("x"); e.Count != 18 || e.Error != 1 {
+		t.Errorf("expected x at 18±1, got %v", e)
+	}
+	if e := a.Estimate("y"); e.Count != 13 || e.LowerBound() != 10 {
+		t.Errorf("expected y at 13 with lower bound 10, got %v", e)
+	}
+	if a.Total() != 30 {
+		t.Errorf("expected total 30, got %d", a.Total())
+	}
+	if b.Estimate("x").Count != 3 {
+		t.Error("MergeWeighted modified the merged stream")
+	}
+	if err := a.MergeWeighted(b, 0); err == nil {
+		t.Error("expected an error for scale 0")
+	}
+}
+
+func TestMergeEncoded(t *testing.T) {
+	words := loadWords()
+	a, b := New(100, WithDistinct()), New(100, WithDistinct())
+	for i, w := range words {
+		if i%3 == 0 {
+			a.Insert(w, 1)
+		} else {
+			b.Insert(w, 1)
+		}
+	}
+	want := a.clone()
+	if err := want.Merge(b); err != nil {
+		t.Fatal(err)
+	}
+
+	msgp, compact := bytes.NewBuffer(nil), bytes.NewBuffer(nil)
+	if err := b.Encode(msgp); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.EncodeCompact(compact); err != nil {
+		t.Fatal(err)
+	}
+	for _, enc := range [][]byte{msgp.Bytes(), compact.Bytes()} {
+		got := a.clone()
+		if err := got.MergeEncoded(enc); err != nil {
+			t.Fatal(err)
+		}
+		if !got.Equal(want) || got.Inserts() != want.Inserts() {
+			t.Error("merged stream differs")
+		}
+		gotN, _ := got.Distinct()
+		wantN, _ := want.Distinct()
+		if gotN != wantN {
+			t.Errorf("expected %d distinct keys, got %d", wantN, gotN)
+		}
+
+		unchanged := a.clone()
+		if err := unchanged.MergeEncoded(enc[:len(enc)-10]); err == nil {
+			t.Error("expected an error for a truncated encoding")
+		}
+		if !unchanged.Equal(a) {
+			t.Error("stream changed by a failed merge")
+		}
+	}
+
+	if err := New(10).MergeEncoded(msgp.Bytes()); !errors.Is(err, ErrIncompatibleSketch) {
+		t.Errorf("expected ErrIncompatibleSketch, got %v", err)
+	}
+}
+
+func TestMergeAbove(t *testing.T) {
+	s := New(10)
+	s.Insert("a", 5)
+
+	leaf := New(10)
+	leaf.Insert("a", 20)
+	leaf.Insert("noise", 2)
+
+	if err := s.MergeAbove(leaf, 10); err != nil {
+		t.Fatal(err)
+	}
+	if e := s.Estimate("a"); e.Count != 25 {
+		t.Errorf("expected count 25, got %v", e)
+	}
+	if _, ok := s.k.m["noise"]; ok {
+		t.Error("expected noise not to be merged")
+	}
+	if e := s.Estimate("noise"); e.Count < 2 {
+		t.Errorf("expected the estimate of noise to remain an upper bound, got %v", e)
+	}
+	if _, ok := leaf.k.m["noise"]; !ok {
+		t.Error("merge modified the other stream")
+	}
+
+	// a corrupt stream tracking a key twice
+	leaf.k.elts = append(leaf.k.elts, Element{Key: "a", Count: 30})
+	if err := s.MergeAbove(leaf, 10); err == nil {
+		t.Error("expected an error for a key tracked twice")
+	}
+	if e := s.Estimate("a"); e.Count != 25 {
+		t.Errorf("expected the failed merge to leave a at 25, got %v", e)
+	}
+}
+
+func TestCompatibleWith(t *testing.T) {
+	s := New(10)
+	s.Insert("a", 1)
+	if err := s.CompatibleWith(New(10)); err != nil {
+		t.Errorf("expected compatible streams, got %v", err)
+	}
+	for _, other := range []*Stream{New(20), {n: 10, alphas: make([]int, 10)}} {
+		if err := s.CompatibleWith(other); !errors.Is(err, ErrIncompatibleSketch) {
+			t.Errorf("expected ErrIncompatibleSketch, got %v", err)
+		}
+		if err := s.Merge(other); !errors.Is(err, ErrIncompatibleSketch) || s.Total() != 1 {
+			t.Errorf("expected the merge to fail without changes, got %v", err)
+		}
+	}
+	if err := s.CompatibleWith(nil); !errors.Is(err, ErrNilSketch) {
+		t.Errorf("expected ErrNilSketch, got %v", err)
+	}
+}
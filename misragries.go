@@ -0,0 +1,216 @@
+package topk
+
+import (
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/tinylib/msgp/msgp"
+)
+
+const misraGriesMagic = "topk/mg"
+
+// MisraGries estimates the frequent elements of a stream with the Misra-Gries
+// summary of k counters, as an alternative to the Filtered Space-Saving of
+// Stream.
+//
+// When a new key finds all counters taken, all counters are decremented
+// instead of evicting one. Counts are underestimated by at most the total of
+// these decrements, which is at most N/(k+1), the error of each element
+// bounds it.
+type MisraGries struct {
+	k          int
+	total      int
+	decrements int // sum of the decrements of all counters
+	counts     map[string]int
+}
+
+// NewMisraGries returns a MisraGries summary of k counters
+func NewMisraGries(k int) *MisraGries {
+	return &MisraGries{k: k, counts: make(map[string]int, k)}
+}
+
+// Insert adds count occurrences of x to the stream.
+// It returns an estimation for the just inserted element
+func (s *MisraGries) Insert(x string, count int) Element {
+	s.total += count
+	if _, ok := s.counts[x]; ok || len(s.counts) < s.k {
+		s.counts[x] += count
+		return s.Estimate(x)
+	}
+
+	// decrement all counters and x by the smallest of them
+	dec := count
+	for _, c := range s.counts {
+		if c < dec {
+			dec = c
+		}
+	}
+	for y, c := range s.counts {
+		if c == dec {
+			delete(s.counts, y)
+		} else {
+			s.counts[y] = c - dec
+		}
+	}
+	s.decrements += dec
+	if count > dec {
+		s.counts[x] = count - dec
+	}
+	return s.Estimate(x)
+}
+
+// Estimate returns an estimate for the item x
+func (s *MisraGries) Estimate(x string) Element {
+	return Element{Key: x, Count: s.counts[x] + s.decrements, Error: s.decrements}
+}
+
+// Keys returns the tracked elements ordered like Stream.Keys
+func (s *MisraGries) Keys() []Element {
+	elts := make([]Element, 0, len(s.counts))
+	for x, c := range s.counts {
+		elts = append(elts, Element{Key: x, Count: c + s.decrements, Error: s.decrements})
+	}
+	SortElements(elts)
+	return elts
+}
+
+// Total returns the sum of the counts of all inserted elements
+func (s *MisraGries) Total() int {
+	return s.total
+}
+
+// MergeSketch merges other, which has to be a *MisraGries, into s like Merge
+func (s *MisraGries) MergeSketch(other Sketch) error {
+	o, ok := other.(*MisraGries)
+	if !ok {
+		if other == nil {
+			return ErrNilSketch
+		}
+		return incompatible("cannot merge %T into %T", other, s)
+	}
+	return s.Merge(o)
+}
+
+// Merge adds other to s: the counters are summed up and, if there are more
+// than k of them, all are decremented by the (k+1)-th largest one
+func (s *MisraGries) Merge(other *MisraGries) error {
+	if other == nil {
+		return ErrNilSketch
+	}
+	if s.k != other.k {
+		return incompatible("expected misra-gries of %d counters, got %d", s.k, other.k)
+	}
+
+	for x, c := range other.counts {
+		s.counts[x] += c
+	}
+	s.total += other.total
+	s.decrements += other.decrements
+	if len(s.counts) <= s.k {
+		return nil
+	}
+
+	counts := make([]int, 0, len(s.counts))
+	for _, c := range s.counts {
+		counts = append(counts, c)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(counts)))
+	dec := counts[s.k]
+	for x, c := range s.counts {
+		if c <= dec {
+			delete(s.counts, x)
+		} else {
+			s.counts[x] = c - dec
+		}
+	}
+	s.decrements += dec
+	return nil
+}
+
+// Encode writes the summary to w
+func (s *MisraGries) Encode(w io.Writer) error {
+	wrt := msgp.NewWriter(w)
+	if err := encodeHeader(wrt, misraGriesMagic); err != nil {
+		return err
+	}
+	for _, v := range []int{s.k, s.total, s.decrements} {
+		if err := wrt.WriteInt(v); err != nil {
+			return err
+		}
+	}
+	if err := encodeCounts(wrt, s.counts); err != nil {
+		return err
+	}
+	return wrt.Flush()
+}
+
+// Decode replaces s with the summary read from r. s is left unchanged on
+// errors.
+func (s *MisraGries) Decode(r io.Reader) error {
+	rdr := msgp.NewReader(r)
+	if err := expectHeader(rdr, misraGriesMagic); err != nil {
+		return err
+	}
+	var (
+		d   MisraGries
+		err error
+	)
+	for _, v := range []*int{&d.k, &d.total, &d.decrements} {
+		if *v, err = rdr.ReadInt(); err != nil {
+			return err
+		}
+	}
+	if d.counts, err = decodeCounts(rdr); err != nil {
+		return err
+	}
+	if d.k < 0 || len(d.counts) > d.k {
+		return fmt.Errorf("topk: corrupt encoding: %d counters for k %d", len(d.counts), d.k)
+	}
+	*s = d
+	return nil
+}
+
+// encodeCounts writes counts as an array of key and count pairs ordered by
+// key
+func encodeCounts(w *msgp.Writer, counts map[string]int) error {
+	keys := make([]string, 0, len(counts))
+	for x := range counts {
+		keys = append(keys, x)
+	}
+	sort.Strings(keys)
+	if err := w.WriteArrayHeader(uint32(len(keys))); err != nil {
+		return err
+	}
+	for _, x := range keys {
+		if err := w.WriteString(x); err != nil {
+			return err
+		}
+		if err := w.WriteInt(counts[x]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func decodeCounts(r *msgp.Reader) (map[string]int, error) {
+	sz, err := r.ReadArrayHeader()
+	if err != nil {
+		return nil, err
+	}
+	hint, err := decodeLen(int(sz))
+	if err != nil {
+		return nil, err
+	}
+	counts := make(map[string]int, hint)
+	for i := uint32(0); i < sz; i++ {
+		x, err := readString(r)
+		if err != nil {
+			return nil, err
+		}
+		if counts[x], err = r.ReadInt(); err != nil {
+			return nil, err
+		}
+	}
+	return counts, nil
+}
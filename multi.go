@@ -0,0 +1,145 @@
+package topk
+
+import (
+	"fmt"
+	"sort"
+)
+
+// MultiElement is a TopK item carrying a value for each metric of a
+// MultiStream
+type MultiElement struct {
+	Element
+	// Values holds the counters in the order of the metrics. Values[0] equals
+	// Count, the others are summed since the key was last admitted and are
+	// lower bounds of the true sums.
+	Values []int `json:"values"`
+}
+
+// MultiStream calculates the TopK elements of a stream where each key carries
+// a fixed set of named counters, e.g. requests, bytes and errors. The sketch
+// is maintained for the first metric, the other counters are kept alongside
+// for as long as the key is tracked, so a single sketch can be ranked by any
+// of them without hashing the stream once per metric.
+type MultiStream struct {
+	s       *Stream
+	metrics []string
+	values  map[string][]int
+}
+
+// NewMulti returns a MultiStream tracking the top n elements by the first of
+// metrics. Without metrics it tracks a single one named "count".
+func NewMulti(n int, metrics ...string) *MultiStream {
+	if len(metrics) == 0 {
+		// the first counter is the count of the sketch
+		metrics = []string{"count"}
+	}
+	return &MultiStream{
+		s:       New(n),
+		metrics: metrics,
+		values:  make(map[string][]int, n),
+	}
+}
+
+// Metrics returns the names of the counters
+func (m *MultiStream) Metrics() []string { return m.metrics }
+
+// Insert adds values to the counters of x, they are given in the order of
+// the metrics and missing ones count as 0.
+// It returns an estimation for the just inserted element
+func (m *MultiStream) Insert(x string, values ...int) MultiElement {
+	var primary int
+	if len(values) > 0 {
+		primary = values[0]
+	}
+
+	_, tracked := m.s.k.m[x]
+	var victim string
+	if !tracked && len(m.s.k.elts) == m.s.n {
+		victim = m.s.k.elts[0].Key
+	}
+
+	e := m.s.Insert(x, primary)
+	if _, ok := m.s.k.m[x]; !ok {
+		return MultiElement{Element: e}
+	}
+	if !tracked {
+		delete(m.values, victim)
+		m.values[x] = make([]int, len(m.metrics))
+	}
+
+	v := m.values[x]
+	for i := 1; i < len(v) && i < len(values); i++ {
+		v[i] += values[i]
+	}
+	v[0] = e.Count
+	return MultiElement{Element: e, Values: append([]int(nil), v...)}
+}
+
+// Keys returns the tracked elements ranked by descending value of metric,
+// ties are broken by ascending key
+func (m *MultiStream) Keys(metric string) ([]MultiElement, error) {
+	idx := -1
+	for i, name := range m.metrics {
+		if name == metric {
+			idx = i
+			break
+		}
+	}
+	if idx < 0 {
+		return nil, fmt.Errorf("topk: unknown metric %q", metric)
+	}
+
+	elts := make([]MultiElement, 0, len(m.s.k.elts))
+	for _, e := range m.s.k.elts {
+		v := append([]int(nil), m.values[e.Key]...)
+		v[0] = e.Count
+		elts = append(elts, MultiElement{Element: e, Values: v})
+	}
+	sort.Slice(elts, func(i, j int) bool {
+		if vi, vj := elts[i].Values[idx], elts[j].Values[idx]; vi != vj {
+			return vi > vj
+		}
+		return elts[i].Key < elts[j].Key
+	})
+	return elts, nil
+}
+
+// Estimate returns an estimate for the item x, the values are nil if x is not
+// tracked
+func (m *MultiStream) Estimate(x string) MultiElement {
+	e := MultiElement{Element: m.s.Estimate(x)}
+	if v, ok := m.values[x]; ok {
+		e.Values = append([]int(nil), v...)
+		e.Values[0] = e.Count
+	}
+	return e
+}
+
+// Merge adds the counters of other to m. The counters of a key tracked by
+// only one of the streams are taken from that stream alone.
+func (m *MultiStream) Merge(other *MultiStream) error {
+	if len(m.metrics) != len(other.metrics) {
+		return incompatible("expected metrics %v, got %v", m.metrics, other.metrics)
+	}
+	for i, name := range m.metrics {
+		if other.metrics[i] != name {
+			return incompatible("expected metrics %v, got %v", m.metrics, other.metrics)
+		}
+	}
+	if err := m.s.Merge(other.s); err != nil {
+		return err
+	}
+
+	values := make(map[string][]int, len(m.s.k.elts))
+	for _, e := range m.s.k.elts {
+		v := make([]int, len(m.metrics))
+		for _, src := range [][]int{m.values[e.Key], other.values[e.Key]} {
+			for i := 1; i < len(src); i++ {
+				v[i] += src[i]
+			}
+		}
+		values[e.Key] = v
+	}
+	m.values = values
+	return nil
+}
@@ -0,0 +1,80 @@
+package topk
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestMultiStream(t *testing.T) {
+	m := NewMulti(3, "requests", "bytes", "errors")
+	if got := m.Metrics(); len(got) != 3 || got[1] != "bytes" {
+		t.Fatalf("unexpected metrics %v", got)
+	}
+
+	for i := 0; i < 10; i++ {
+		m.Insert("/small", 1, 10, 0)
+		m.Insert("/large", 1, 1000)
+	}
+	for i := 0; i < 5; i++ {
+		m.Insert("/broken", 1, 10, 1)
+	}
+	// one-off keys don't replace the heavy ones
+	for i := 0; i < 3; i++ {
+		m.Insert(fmt.Sprintf("/other-%d", i), 1, 1, 1)
+	}
+
+	for metric, want := range map[string]string{
+		"requests": "/large",
+		"bytes":    "/large",
+		"errors":   "/broken",
+	} {
+		keys, err := m.Keys(metric)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if keys[0].Key != want {
+			t.Errorf("expected %s on top by %s, got %v", want, metric, keys)
+		}
+	}
+	if e := m.Estimate("/large"); e.Count != 10 || e.Values[1] != 10000 || e.Values[2] != 0 {
+		t.Errorf("unexpected estimate %v", e)
+	}
+	if _, err := m.Keys("latency"); err == nil {
+		t.Error("expected an error for an unknown metric")
+	}
+
+	other := NewMulti(3, "requests", "bytes", "errors")
+	other.Insert("/broken", 1, 10, 1)
+	if err := m.Merge(other); err != nil {
+		t.Fatal(err)
+	}
+	if e := m.Estimate("/broken"); e.Count != 6 || e.Values[1] != 60 || e.Values[2] != 6 {
+		t.Errorf("unexpected merged estimate %v", e)
+	}
+	if len(m.values) != len(m.s.k.elts) {
+		t.Errorf("expected values for %d tracked keys, got %d", len(m.s.k.elts), len(m.values))
+	}
+
+	if err := m.Merge(NewMulti(3, "requests")); err == nil {
+		t.Error("expected merging different metrics to fail")
+	}
+}
+
+func TestMultiStreamNoMetrics(t *testing.T) {
+	m := NewMulti(2)
+	if got := m.Metrics(); len(got) != 1 || got[0] != "count" {
+		t.Fatalf("expected a single count metric, got %v", got)
+	}
+	m.Insert("a", 3)
+	m.Insert("b")
+	if e := m.Estimate("a"); e.Count != 3 || len(e.Values) != 1 || e.Values[0] != 3 {
+		t.Errorf("unexpected estimate %v", e)
+	}
+	keys, err := m.Keys("count")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 || keys[0].Key != "a" {
+		t.Errorf("unexpected keys %v", keys)
+	}
+}
@@ -0,0 +1,303 @@
+package topk
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/dgryski/go-metro"
+)
+
+// Option configures a Stream
+type Option func(*Stream)
+
+// WithDeferredHeap trades a little staleness for insert throughput: updates
+// to already tracked elements only bump their counters, restoring the heap
+// ordering is deferred until the minimum is needed to decide about a new
+// element, or until Consolidate is called.
+//
+// This pays off for bursty streams where most inserts hit tracked elements.
+func WithDeferredHeap() Option {
+	return func(s *Stream) {
+		s.deferred = true
+	}
+}
+
+// WithKeyTransform normalizes keys with f before they are hashed and stored,
+// e.g. to lowercase or trim them. It applies to inserts and estimates alike so
+// both always agree on the key.
+func WithKeyTransform(f func(string) string) Option {
+	return func(s *Stream) {
+		s.transform = f
+	}
+}
+
+// KeyLengthPolicy defines how keys longer than the maximum key length are
+// handled
+type KeyLengthPolicy int
+
+const (
+	// RejectLongKeys drops inserts of long keys
+	RejectLongKeys KeyLengthPolicy = iota
+	// TruncateLongKeys tracks long keys by their first max bytes, keys
+	// sharing that prefix are counted together
+	TruncateLongKeys
+	// HashLongKeys tracks long keys by a prefix followed by the hash of the
+	// whole key, so keys sharing a prefix are still told apart
+	HashLongKeys
+	// PrefixHashLongKeys tracks long keys by their first max bytes followed
+	// by the hash of the whole key. Unlike HashLongKeys the whole prefix is
+	// kept, the tracked keys exceed max by the 17 bytes of the hash, and
+	// Keys and Estimate report them as Truncated.
+	PrefixHashLongKeys
+)
+
+// shorten returns x cut down to max bytes
+func (p KeyLengthPolicy) shorten(x string, max int) string {
+	switch p {
+	case HashLongKeys:
+	case PrefixHashLongKeys:
+		return fmt.Sprintf("%s#%016x", x[:max], metro.Hash64Str(x, 0))
+	default:
+		return x[:max]
+	}
+	h := fmt.Sprintf("#%016x", metro.Hash64Str(x, 0))
+	if max <= len(h) {
+		return h[len(h)-max:]
+	}
+	return x[:max-len(h)] + h
+}
+
+// WithMaxKeyLength limits the length of the keys in bytes, keys exceeding
+// it are handled according to policy and counted in Stats. This prevents a
+// few huge keys from being pinned in memory for the lifetime of the sketch.
+func WithMaxKeyLength(max int, policy KeyLengthPolicy) Option {
+	return func(s *Stream) {
+		s.maxKeyLen = max
+		s.keyPolicy = policy
+	}
+}
+
+// FilterAction defines what happens to inserts of filtered keys
+type FilterAction int
+
+const (
+	// DropFiltered ignores inserts of filtered keys entirely
+	DropFiltered FilterAction = iota
+	// AlphaOnlyFiltered counts filtered keys in the alphas only, they still
+	// add to the error of the other keys but never take up a tracked slot
+	AlphaOnlyFiltered
+)
+
+// WithFilter filters inserts of keys for which pred returns true according to
+// action, keeping the tracked slots for interesting keys. Filtered inserts
+// are counted in Stats.
+func WithFilter(pred func(string) bool, action FilterAction) Option {
+	return func(s *Stream) {
+		s.filter = pred
+		s.filterAction = action
+	}
+}
+
+// WithDenyList filters inserts of the given keys according to action, see
+// WithFilter
+func WithDenyList(action FilterAction, keys ...string) Option {
+	deny := make(map[string]struct{}, len(keys))
+	for _, k := range keys {
+		deny[k] = struct{}{}
+	}
+	return WithFilter(func(x string) bool {
+		_, ok := deny[x]
+		return ok
+	}, action)
+}
+
+// WithSampling only processes a random sample of the inserts, each one is
+// kept with probability rate and its count is scaled by 1/rate, rounded at
+// random to stay unbiased.
+//
+// Counts and the total remain unbiased estimates. The reported errors account
+// for the sampling: the lower bound of an element is the share of its count
+// that was actually sampled, the rest of the count is estimated. Rates outside
+// of (0, 1) disable sampling.
+func WithSampling(rate float64) Option {
+	return func(s *Stream) {
+		if rate <= 0 || rate >= 1 {
+			s.sampleRate, s.rng = 0, nil
+			return
+		}
+		s.sampleRate = rate
+		s.rng = s.newRand()
+	}
+}
+
+// WithDeterministic makes the results reproducible: two streams given the
+// same inserts and merges in the same order return identical results and
+// encodings. The random choices of WithSampling and WithProbabilisticUpdate
+// are drawn from seed, and elements tied on count and error are ranked and
+// evicted by key instead of by their position in the heap.
+func WithDeterministic(seed int64) Option {
+	return func(s *Stream) {
+		s.seed = &seed
+		s.k.stable = true
+		if s.tier != nil {
+			s.tier.k.stable = true
+		}
+		if s.rng != nil {
+			s.rng = s.newRand()
+		}
+	}
+}
+
+// newRand returns the source of the stream's random choices
+func (s *Stream) newRand() *rand.Rand {
+	if s.seed != nil {
+		return rand.New(rand.NewSource(*s.seed))
+	}
+	return rand.New(rand.NewSource(time.Now().UnixNano()))
+}
+
+// WithDoorkeeper puts a Bloom filter of the given number of bits in front of
+// the sketch which holds back the first occurrence of every key. Keys that
+// occur only once never reach the heap or the alphas, the held occurrence is
+// added back when a key occurs for the second time. Only inserts with a count
+// of 1 are held, the total always includes the held inserts.
+//
+// The filters are reset once they have held bits/8 keys, a key straddling a
+// reset or hit by a false positive may be counted one off.
+func WithDoorkeeper(bits int) Option {
+	return func(s *Stream) {
+		s.door = newDoorkeeper(bits)
+	}
+}
+
+// WithScore ranks elements by score instead of their count: the element with
+// the lowest score is the one evicted, a new element only replaces it if it
+// scores at least as high, and Keys returns the elements by descending score.
+// The counts and errors are maintained as usual and passed to score, e.g. to
+// weight the count by the key length.
+func WithScore(score func(Element) float64) Option {
+	return func(s *Stream) {
+		s.k.score = score
+	}
+}
+
+// WithDistinct maintains a HyperLogLog of all inserted keys next to the
+// sketch, see Distinct. It costs 4KiB and is persisted in the encoding.
+func WithDistinct() Option {
+	return func(s *Stream) {
+		s.distinct = newHLL()
+	}
+}
+
+// WithShadow is a debugging aid validating the sketch sizing on live
+// traffic: it keeps exact counts for the given fraction of the keys, chosen
+// by hash, and passes a ShadowReport to report after every inserts. The
+// exact counts cost memory linear in the number of sampled keys and are
+// neither encoded nor merged.
+func WithShadow(rate float64, every int, report func(ShadowReport)) Option {
+	return func(s *Stream) {
+		s.shadow = &shadow{
+			limit:  uint64(rate * (1 << 32)),
+			exact:  make(map[string]int),
+			every:  every,
+			report: report,
+		}
+	}
+}
+
+// WithClock sets the clock used to timestamp events such as evictions, the
+// default is SystemClock
+func WithClock(c Clock) Option {
+	return func(s *Stream) {
+		s.clock = c
+		if s.timings.on {
+			// WithTimings came first and started by the previous clock
+			s.timings.since = s.now()
+		}
+	}
+}
+
+// WithEvictionLog records the last size evictions, see Evictions. It helps
+// auditing which near heavy hitters got dropped to tune n.
+func WithEvictionLog(size int) Option {
+	return func(s *Stream) {
+		if size > 0 {
+			s.evictions = &evictionLog{buf: make([]Eviction, size)}
+		}
+	}
+}
+
+// WithHooks sets hooks receiving the events of the stream
+func WithHooks(h Hooks) Option {
+	return func(s *Stream) {
+		s.hooks = h
+	}
+}
+
+// WithAutoTune monitors the eviction churn and the error of the tracked
+// elements and doubles n, up to max, when the stream turns out less skewed
+// than n is sized for. With max not above n it only recommends growing, see
+// Hooks.OnTune.
+func WithAutoTune(max int) Option {
+	return func(s *Stream) {
+		s.tune = &tuner{max: max}
+	}
+}
+
+// ReplacementPolicy defines the estimate of a new key replacing the minimum
+// element
+type ReplacementPolicy int
+
+const (
+	// AlphaReplacement counts the new key from the alpha it hashes to, the
+	// filtered space saving of the paper. It is the default.
+	AlphaReplacement ReplacementPolicy = iota
+	// InheritMinReplacement counts the new key from the count of the evicted
+	// minimum like the classic space saving. It is more conservative: every
+	// new key replaces the minimum and gets a larger error.
+	InheritMinReplacement
+)
+
+// WithReplacement sets the replacement policy, the default is
+// AlphaReplacement. The setting is persisted in the encoding.
+func WithReplacement(p ReplacementPolicy) Option {
+	return func(s *Stream) {
+		s.replacement = p
+	}
+}
+
+// WithPromotion makes tracked elements authoritative once their count
+// exceeds threshold: they are never replaced, new keys are only counted in
+// the alphas while the minimum is promoted. Their error stays the one from
+// their admission, so their bounds remain sound. The setting is persisted in
+// the encoding.
+func WithPromotion(threshold int) Option {
+	return func(s *Stream) {
+		s.promotion = threshold
+	}
+}
+
+// WithDecrements allows inserts with negative counts to retract earlier
+// inserts. A tracked element's count is decreased down to zero and its error
+// capped at its count. Untracked keys and the alphas are left unchanged since
+// the alphas have to stay upper bounds of all keys hashing to them, so the
+// estimates of retracted untracked keys stay overestimated. Without it
+// negative counts are ignored and reported as Invalid by InsertDetailed.
+func WithDecrements() Option {
+	return func(s *Stream) {
+		s.decrements = true
+	}
+}
+
+// WithProbabilisticUpdate makes a new key replace the minimum element only
+// with a probability of count/(min+count), it is counted in the alphas
+// otherwise. Like the randomized admission of Ben-Basat et al. this reduces
+// the overestimation on extremely long tailed streams, where the minimum is
+// otherwise replaced on nearly every insert. The setting is persisted in the
+// encoding.
+func WithProbabilisticUpdate() Option {
+	return func(s *Stream) {
+		s.probabilistic = true
+	}
+}
@@ -0,0 +1,456 @@
+package topk
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDeferredHeap(t *testing.T) {
+	// stable tie-breaks make the minimum independent of the heap layout
+	plain := New(100, WithDeterministic(0))
+	deferred := New(100, WithDeferredHeap(), WithDeterministic(0))
+	for i, w := range loadWords() {
+		plain.Insert(w, 1+i%3)
+		deferred.Insert(w, 1+i%3)
+	}
+	// an update of a tracked key only bumps its counter
+	last := plain.Keys()[len(plain.Keys())-1].Key
+	plain.Insert(last, 1000)
+	deferred.Insert(last, 1000)
+
+	// the minimum is restored before every decision about a new key, so
+	// deferring the ordering doesn't change what is tracked
+	if !deferred.stale {
+		t.Error("expected the heap ordering to be deferred")
+	}
+	if got, want := deferred.Keys(), plain.Keys(); !reflect.DeepEqual(got, want) {
+		t.Errorf("keys differ from a plain stream:\n%v\n%v", got, want)
+	}
+
+	deferred.Consolidate()
+	if deferred.stale {
+		t.Error("expected Consolidate to restore the ordering")
+	}
+	if err := deferred.Validate(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestKeyTransform(t *testing.T) {
+	s := New(10, WithKeyTransform(func(x string) string {
+		return strings.ToLower(strings.TrimSpace(x))
+	}))
+	s.Insert("Foo", 1)
+	s.Insert(" foo ", 2)
+	s.InsertMany([]string{"FOO", "bar"})
+
+	if e := s.Estimate("fOo"); e.Key != "foo" || e.Count != 4 {
+		t.Errorf("expected foo with count 4, got %v", e)
+	}
+	if keys := s.Keys(); len(keys) != 2 {
+		t.Errorf("expected 2 keys, got %v", keys)
+	}
+}
+
+func TestMaxKeyLength(t *testing.T) {
+	long1 := strings.Repeat("a", 100) + "1"
+	long2 := strings.Repeat("a", 100) + "2"
+
+	cases := []struct {
+		policy KeyLengthPolicy
+		keys   int
+	}{
+		{RejectLongKeys, 1},
+		{TruncateLongKeys, 2},
+		{HashLongKeys, 3},
+	}
+	for _, c := range cases {
+		s := New(10, WithMaxKeyLength(32, c.policy))
+		s.Insert("short", 1)
+		s.Insert(long1, 1)
+		s.InsertMany([]string{long2})
+
+		if got := len(s.Keys()); got != c.keys {
+			t.Errorf("policy %d: expected %d keys, got %v", c.policy, c.keys, s.Keys())
+		}
+		for _, e := range s.Keys() {
+			if len(e.Key) > 32 {
+				t.Errorf("policy %d: key %q exceeds the maximum length", c.policy, e.Key)
+			}
+		}
+		if c.policy != RejectLongKeys && s.Estimate(long1).Count < 1 {
+			t.Errorf("policy %d: expected to find %q", c.policy, long1)
+		}
+		if s.Stats().LongKeys != 2 {
+			t.Errorf("policy %d: expected 2 long keys, got %d", c.policy, s.Stats().LongKeys)
+		}
+	}
+}
+
+func TestPrefixHashLongKeys(t *testing.T) {
+	prefix := "/search?q=" + strings.Repeat("x", 22)
+	s := New(10, WithMaxKeyLength(32, PrefixHashLongKeys))
+	s.Insert("/", 1)
+	s.Insert(prefix+"&page=1", 2)
+	s.Insert(prefix+"&page=2", 1)
+
+	keys := s.Keys()
+	if len(keys) != 3 {
+		t.Fatalf("expected 3 keys, got %v", keys)
+	}
+	for _, e := range keys {
+		if long := e.Key != "/"; e.Truncated != long {
+			t.Errorf("expected %q truncated %v", e.Key, long)
+		}
+		if e.Truncated && (len(e.Key) != 32+17 || !strings.HasPrefix(e.Key, prefix)) {
+			t.Errorf("expected the prefix and hash, got %q", e.Key)
+		}
+	}
+	if e := s.Estimate(prefix + "&page=1"); e.Count != 2 || !e.Truncated {
+		t.Errorf("expected truncated count 2, got %v", e)
+	}
+	for name, got := range map[string][]Element{
+		"Query":        s.Query(),
+		"KeysPage":     s.KeysPage(0, 10),
+		"KeysMatching": s.KeysMatching(func(string) bool { return true }),
+	} {
+		if !reflect.DeepEqual(got, keys) {
+			t.Errorf("%s: expected %v, got %v", name, keys, got)
+		}
+	}
+}
+
+func TestFilter(t *testing.T) {
+	for _, action := range []FilterAction{DropFiltered, AlphaOnlyFiltered} {
+		s := New(10, WithDenyList(action, "/health", "/ready"))
+		s.Insert("/health", 100)
+		s.InsertMany([]string{"/ready", "/api"})
+		s.Insert("/api", 1)
+
+		if keys := s.Keys(); len(keys) != 1 || keys[0].Key != "/api" {
+			t.Errorf("action %d: expected only /api to be tracked, got %v", action, keys)
+		}
+		if s.Stats().FilteredKeys != 2 {
+			t.Errorf("action %d: expected 2 filtered keys, got %d", action, s.Stats().FilteredKeys)
+		}
+
+		total := 2
+		if action == AlphaOnlyFiltered {
+			total = 103
+		}
+		if s.Total() != total {
+			t.Errorf("action %d: expected total %d, got %d", action, total, s.Total())
+		}
+	}
+}
+
+func TestSampling(t *testing.T) {
+	s := New(10, WithSampling(0.1), WithDeterministic(1))
+	for i := 0; i < 100000; i++ {
+		s.Insert("a", 1)
+		s.InsertMany([]string{"b", "b"})
+	}
+
+	st := s.Stats()
+	if st.SampleRate != 0.1 {
+		t.Errorf("expected sample rate 0.1, got %v", st.SampleRate)
+	}
+	if st.SkippedInserts < 250000 || st.SkippedInserts > 290000 {
+		t.Errorf("expected about 270000 skipped inserts, got %d", st.SkippedInserts)
+	}
+
+	// the scaled counts are within a few percent
+	for key, want := range map[string]int{"a": 100000, "b": 200000} {
+		if c := s.Estimate(key).Count; math.Abs(float64(c-want)) > 0.05*float64(want) {
+			t.Errorf("expected %s to be about %d, got %d", key, want, c)
+		}
+	}
+}
+
+func TestSamplingRates(t *testing.T) {
+	// rates whose inverse isn't an integer can't be scaled by rounding
+	for _, rate := range []float64{0.3, 0.6} {
+		s := New(10, WithSampling(rate), WithDeterministic(1))
+		for i := 0; i < 100000; i++ {
+			s.Insert("a", 1)
+		}
+		e := s.Estimate("a")
+		if math.Abs(float64(e.Count-100000)) > 0.02*100000 {
+			t.Errorf("rate %v: expected a to be about 100000, got %d", rate, e.Count)
+		}
+		// the lower bound is what was sampled
+		if lb := e.LowerBound(); lb > 100000 || math.Abs(float64(lb)-rate*100000) > 0.02*100000 {
+			t.Errorf("rate %v: expected a lower bound of about %v, got %d", rate, rate*100000, lb)
+		}
+		if keys := s.Keys(); len(keys) != 1 || keys[0] != e {
+			t.Errorf("rate %v: expected Keys to report %v, got %v", rate, e, keys)
+		}
+	}
+}
+
+func TestScore(t *testing.T) {
+	byLength := WithScore(func(e Element) float64 {
+		return float64(e.Count * len(e.Key))
+	})
+
+	s := New(2, byLength)
+	s.Insert("a", 10)
+	s.Insert("bbbbb", 3)
+
+	// scores 8, lower than a's 10
+	s.Insert("cc", 4)
+	if _, ok := s.k.m["cc"]; ok {
+		t.Errorf("expected cc not to be tracked, got %v", s.Keys())
+	}
+	// scores 20, replacing a
+	s.Insert("dddddddddd", 2)
+
+	keys := s.Keys()
+	if len(keys) != 2 || keys[0].Key != "dddddddddd" || keys[1].Key != "bbbbb" {
+		t.Errorf("expected dddddddddd and bbbbb by score, got %v", keys)
+	}
+
+	// the decoded heap is ordered by the score of the decoding stream
+	buf := bytes.NewBuffer(nil)
+	plain := New(3)
+	plain.Insert("a", 10)
+	plain.Insert("bbbbb", 3)
+	plain.Insert("cc", 4)
+	if err := plain.Encode(buf); err != nil {
+		t.Fatal(err)
+	}
+	got := New(3, byLength)
+	if err := got.Decode(buf); err != nil {
+		t.Fatal(err)
+	}
+	if min := got.k.elts[0]; min.Key != "cc" {
+		t.Errorf("expected cc to be the minimum by score, got %v", min)
+	}
+}
+
+func TestProbabilisticUpdate(t *testing.T) {
+	run := func(opts ...Option) *Stream {
+		s := New(10, opts...)
+		for i := 0; i < 50000; i++ {
+			s.Insert(fmt.Sprintf("heavy-%d", i%5), 1)
+			s.Insert(fmt.Sprintf("tail-%d", i), 1)
+		}
+		return s
+	}
+	plain, prob := run(), run(WithProbabilisticUpdate())
+
+	if p, q := plain.Stats().Evictions, prob.Stats().Evictions; q*5 > p {
+		t.Errorf("expected far fewer evictions, got %d instead of %d", q, p)
+	}
+	for i, e := range prob.Keys()[:5] {
+		if !strings.HasPrefix(e.Key, "heavy-") {
+			t.Errorf("expected heavy keys on top, got %v at %d", e, i)
+		}
+	}
+
+	buf := bytes.NewBuffer(nil)
+	if err := prob.Encode(buf); err != nil {
+		t.Fatal(err)
+	}
+	decoded := New(10)
+	if err := decoded.Decode(buf); err != nil {
+		t.Fatal(err)
+	}
+	if !decoded.probabilistic {
+		t.Error("expected the probabilistic update to be preserved in the encoding")
+	}
+
+	for _, encode := range []func(*Stream, io.Writer) error{(*Stream).Encode, (*Stream).EncodeCompact} {
+		buf := bytes.NewBuffer(nil)
+		if err := encode(plain, buf); err != nil {
+			t.Fatal(err)
+		}
+		if err := prob.Decode(buf); err != nil {
+			t.Fatal(err)
+		}
+		if prob.probabilistic {
+			t.Error("expected decoding a plain stream to reset the probabilistic update")
+		}
+		prob.probabilistic = true
+	}
+}
+
+func TestReplacement(t *testing.T) {
+	run := func(opts ...Option) *Stream {
+		s := New(2, opts...)
+		s.Insert("a", 10)
+		s.Insert("b", 5)
+		s.Insert("c", 1)
+		return s
+	}
+
+	if _, ok := run().k.m["c"]; ok {
+		t.Error("expected c to be rejected with its alpha below the minimum")
+	}
+
+	s := run(WithReplacement(InheritMinReplacement))
+	if got := s.Estimate("c"); got != (Element{Key: "c", Count: 6, Error: 5}) {
+		t.Errorf("expected c to inherit the minimum, got %v", got)
+	}
+	if err := s.Validate(); err != nil {
+		t.Error(err)
+	}
+
+	for _, encode := range []func(*Stream, io.Writer) error{(*Stream).Encode, (*Stream).EncodeCompact} {
+		buf := bytes.NewBuffer(nil)
+		if err := encode(s, buf); err != nil {
+			t.Fatal(err)
+		}
+		decoded := New(2)
+		if err := decoded.Decode(buf); err != nil {
+			t.Fatal(err)
+		}
+		if decoded.replacement != InheritMinReplacement {
+			t.Error("expected the replacement policy to be preserved in the encoding")
+		}
+
+		buf.Reset()
+		if err := encode(run(), buf); err != nil {
+			t.Fatal(err)
+		}
+		if err := decoded.Decode(buf); err != nil {
+			t.Fatal(err)
+		}
+		if decoded.replacement != AlphaReplacement {
+			t.Error("expected decoding a plain stream to reset the replacement policy")
+		}
+	}
+}
+
+func TestPromotion(t *testing.T) {
+	s := New(2, WithPromotion(100), WithReplacement(InheritMinReplacement))
+	s.Insert("a", 50)
+	s.Insert("b", 10)
+	// c replaces b and inherits its count as error
+	s.Insert("c", 20)
+	s.Insert("c", 100)
+	if e := s.Estimate("c"); e.Error != 10 || e.Count != 130 {
+		t.Errorf("expected c promoted with its admission error, got %v", e)
+	}
+	if e := s.Estimate("c"); e.LowerBound() != 120 {
+		t.Errorf("expected the lower bound of c to be its exact count 120, got %v", e)
+	}
+
+	s.Insert("a", 51)
+	// all tracked elements are promoted, nothing replaces them
+	s.Insert("d", 1000)
+	if _, ok := s.k.m["d"]; ok {
+		t.Error("expected a promoted minimum not to be replaced")
+	}
+	if e := s.Estimate("d"); e.Count < 1000 {
+		t.Errorf("expected d counted in the alphas, got %v", e)
+	}
+
+	for _, encode := range []func(*Stream, io.Writer) error{(*Stream).Encode, (*Stream).EncodeCompact} {
+		buf := bytes.NewBuffer(nil)
+		if err := encode(s, buf); err != nil {
+			t.Fatal(err)
+		}
+		decoded := New(2)
+		if err := decoded.Decode(buf); err != nil {
+			t.Fatal(err)
+		}
+		if decoded.promotion != 100 {
+			t.Errorf("expected the promotion threshold to be preserved in the encoding, got %d", decoded.promotion)
+		}
+
+		buf.Reset()
+		if err := encode(New(2), buf); err != nil {
+			t.Fatal(err)
+		}
+		if err := decoded.Decode(buf); err != nil {
+			t.Fatal(err)
+		}
+		if decoded.promotion != 0 {
+			t.Errorf("expected decoding a plain stream to reset the promotion threshold, got %d", decoded.promotion)
+		}
+	}
+}
+
+func TestDecrements(t *testing.T) {
+	s := New(3)
+	s.Insert("a", 5)
+	if e, o := s.InsertDetailed("a", -2); o != Invalid || e.Count != 5 {
+		t.Errorf("expected the decrement to be ignored, got %v %v", e, o)
+	}
+
+	s = New(3, WithDecrements())
+	s.Insert("a", 5)
+	s.Insert("b", 3)
+	s.Insert("c", 4)
+	if e, o := s.InsertDetailed("a", -2); o != Updated || e.Count != 3 {
+		t.Errorf("expected a decremented to 3, got %v %v", e, o)
+	}
+	if e := s.Insert("b", -10); e.Count != 0 || e.Error != 0 {
+		t.Errorf("expected b clamped at zero, got %v", e)
+	}
+	if s.k.elts[0].Key != "b" {
+		t.Errorf("expected b on top of the heap, got %v", s.k.elts)
+	}
+	if _, o := s.InsertDetailed("d", -1); o != Rejected {
+		t.Errorf("expected the decrement of an untracked key to be rejected, got %v", o)
+	}
+	// only the count b actually had is retracted
+	if s.Total() != 6 {
+		t.Errorf("expected total 6, got %d", s.Total())
+	}
+	if err := s.Validate(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDecrementsDeferredHeap(t *testing.T) {
+	s := New(4, WithDeferredHeap(), WithDecrements())
+	for i, x := range []string{"a", "b", "c", "d"} {
+		s.Insert(x, i+1)
+	}
+	// the deferred heap is restored by the decrement, which moves elements
+	s.Insert("a", 100)
+	s.Insert("b", 50)
+	if e := s.Insert("c", -3); e.Key != "c" || e.Count != 0 {
+		t.Errorf("expected c decremented to 0, got %v", e)
+	}
+	if e := s.Estimate("a"); e.Count != 101 {
+		t.Errorf("expected a to stay at 101, got %v", e)
+	}
+	if err := s.Validate(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDeterministic(t *testing.T) {
+	// without stable tie-breaks the minimum is whichever tied element sits
+	// at the root of the heap, a
+	s := New(2, WithDeterministic(0))
+	for _, x := range []string{"a", "b", "c"} {
+		s.Insert(x, 1)
+	}
+	if _, ok := s.k.m["a"]; !ok {
+		t.Errorf("expected b to be evicted before a, got %v", s.Keys())
+	}
+
+	run := func() []byte {
+		s := New(20, WithSampling(0.5), WithProbabilisticUpdate(), WithDeterministic(42))
+		for i, w := range loadWords() {
+			s.Insert(w, 1+i%3)
+		}
+		var b bytes.Buffer
+		if err := s.Encode(&b); err != nil {
+			t.Fatal(err)
+		}
+		return b.Bytes()
+	}
+	if !bytes.Equal(run(), run()) {
+		t.Error("expected identical encodings")
+	}
+}
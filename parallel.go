@@ -0,0 +1,134 @@
+package topk
+
+import (
+	"context"
+	"sync"
+)
+
+// ParallelCount consumes items with the given number of workers, each
+// counting into its own Stream of size n, and merges the per-worker sketches
+// once items is closed.
+//
+// It returns ctx.Err() if the context is cancelled before all items have been
+// consumed.
+func ParallelCount(ctx context.Context, n int, items <-chan string, workers int) (*Stream, error) {
+	if workers < 1 {
+		workers = 1
+	}
+
+	sketches := make([]*Stream, workers)
+	var wg sync.WaitGroup
+	for i := range sketches {
+		sketches[i] = New(n)
+		wg.Add(1)
+		go func(s *Stream) {
+			defer wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case x, ok := <-items:
+					if !ok {
+						return
+					}
+					s.Insert(x, 1)
+				}
+			}
+		}(sketches[i])
+	}
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	res := sketches[0]
+	for _, s := range sketches[1:] {
+		if err := res.Merge(s); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+// MergeConcurrent merges the streams received on streams with the given
+// number of workers, each folding streams into its own partial result, and
+// reduces the partial results once streams is closed. The received streams
+// are not modified.
+//
+// It returns the first merge error, ErrNilSketch for a nil stream,
+// ErrNoSketches if no stream is received, or ctx.Err() if the context is
+// cancelled before streams is closed.
+func MergeConcurrent(ctx context.Context, streams <-chan *Stream, parallelism int) (*Stream, error) {
+	if parallelism < 1 {
+		parallelism = 1
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		once     sync.Once
+		firstErr error
+		partial  = make([]*Stream, parallelism)
+	)
+	fail := func(err error) {
+		once.Do(func() {
+			firstErr = err
+			cancel()
+		})
+	}
+	for i := range partial {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case s, ok := <-streams:
+					if !ok {
+						return
+					}
+					if s == nil {
+						fail(ErrNilSketch)
+						return
+					}
+					if partial[i] == nil {
+						partial[i] = s.clone()
+						continue
+					}
+					if err := partial[i].MergeContext(ctx, s); err != nil {
+						fail(err)
+						return
+					}
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var res *Stream
+	for _, s := range partial {
+		switch {
+		case s == nil:
+		case res == nil:
+			res = s
+		default:
+			if err := res.MergeContext(ctx, s); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if res == nil {
+		return nil, ErrNoSketches
+	}
+	return res, nil
+}
@@ -0,0 +1,129 @@
+package topk
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestParallelCount(t *testing.T) {
+	words := loadWords()
+
+	// Words in prime index positions are copied
+	for _, p := range []int{2, 3, 5, 7, 11, 13, 17, 23} {
+		for i := p; i < len(words); i += p {
+			words[i] = words[p]
+		}
+	}
+
+	items := make(chan string, 128)
+	go func() {
+		for _, w := range words {
+			items <- w
+		}
+		close(items)
+	}()
+
+	s, err := ParallelCount(context.Background(), 20, items, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exact := exactCount(words)
+	top := exactTop(exact)
+	skTop := s.Keys()
+	rank := make(map[string]int, len(skTop))
+	for i, e := range skTop {
+		rank[e.Key] = i
+	}
+
+	// the split across workers is not deterministic, so elements may only
+	// trade places where their error bounds overlap
+	for i, w := range top[:8] {
+		r, ok := rank[w]
+		if !ok {
+			t.Errorf("Expected top %d '%s'(%d) in result", i, w, exact[w])
+			continue
+		}
+		e := skTop[r]
+		if e.Count-e.Error > exact[w] || e.Count < exact[w] {
+			t.Errorf("Expected '%s'(%d) within [%d, %d]", w, exact[w], e.Count-e.Error, e.Count)
+		}
+		for _, v := range top[:i] {
+			if rv, ok := rank[v]; ok && rv > r && exact[v] > exact[w] && skTop[rv].Count < e.Count-e.Error {
+				t.Errorf("Expected '%s'(%d) ranked above '%s'(%d)", v, exact[v], w, exact[w])
+			}
+		}
+	}
+}
+
+func TestParallelCountCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// items is never closed, only the cancellation can stop the workers
+	if _, err := ParallelCount(ctx, 20, make(chan string), 4); err != context.Canceled {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+}
+
+func TestMergeConcurrent(t *testing.T) {
+	streams := make(chan *Stream)
+	go func() {
+		for i := 0; i < 20; i++ {
+			s := New(100)
+			s.Insert("a", i)
+			s.Insert(fmt.Sprintf("key-%d", i), 1)
+			streams <- s
+		}
+		close(streams)
+	}()
+
+	s, err := MergeConcurrent(context.Background(), streams, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e := s.Estimate("a"); e.Count != 190 {
+		t.Errorf("expected merged count 190, got %v", e)
+	}
+	if s.Total() != 210 || len(s.Keys()) != 21 {
+		t.Errorf("expected total 210 over 21 keys, got %d over %d", s.Total(), len(s.Keys()))
+	}
+}
+
+func TestMergeConcurrentError(t *testing.T) {
+	streams := make(chan *Stream, 3)
+	streams <- New(100)
+	streams <- New(10)
+	streams <- New(100)
+	close(streams)
+	if _, err := MergeConcurrent(context.Background(), streams, 1); !errors.Is(err, ErrIncompatibleSketch) {
+		t.Errorf("expected ErrIncompatibleSketch, got %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	// streams is never closed, only the cancellation can stop the workers
+	if _, err := MergeConcurrent(ctx, make(chan *Stream), 4); err != context.Canceled {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+	empty := make(chan *Stream)
+	close(empty)
+	if _, err := MergeConcurrent(context.Background(), empty, 4); err != ErrNoSketches {
+		t.Errorf("expected ErrNoSketches without streams, got %v", err)
+	}
+
+	// a nil stream fails whether or not it is the first one of a worker
+	for _, first := range []bool{true, false} {
+		streams := make(chan *Stream, 2)
+		if !first {
+			streams <- New(10)
+		}
+		streams <- nil
+		close(streams)
+		if _, err := MergeConcurrent(context.Background(), streams, 1); err != ErrNilSketch {
+			t.Errorf("expected ErrNilSketch, got %v", err)
+		}
+	}
+}
@@ -0,0 +1,261 @@
+package topk
+
+import (
+	"container/heap"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Order defines how query results are ordered
+type Order int
+
+const (
+	// ByCount orders like Keys: by descending count, or by descending score
+	// with WithScore
+	ByCount Order = iota
+	// ByLowerBound orders by descending guaranteed count, so overestimated
+	// elements can't be ranked above others they are not known to exceed
+	ByLowerBound
+	// ByError orders by ascending error, the most accurate estimates first
+	ByError
+	// ByKey orders lexicographically by key
+	ByKey
+)
+
+// less reports whether a is ordered before b, ties are ordered like Keys
+func (o Order) less(tk *keys, a, b Element) bool {
+	switch o {
+	case ByLowerBound:
+		if a.LowerBound() != b.LowerBound() {
+			return a.LowerBound() > b.LowerBound()
+		}
+	case ByError:
+		if a.Error != b.Error {
+			return a.Error < b.Error
+		}
+	case ByKey:
+		return a.Key < b.Key
+	}
+	return tk.ranked(a, b)
+}
+
+type query struct {
+	order Order
+	share bool
+}
+
+// QueryOption configures a Query
+type QueryOption func(*query)
+
+// OrderBy sets the order of the query results
+func OrderBy(o Order) QueryOption {
+	return func(q *query) {
+		q.order = o
+	}
+}
+
+// WithShare sets the Share of the returned elements against the stream's
+// total, which is persisted by Encode and summed by Merge
+func WithShare() QueryOption {
+	return func(q *query) {
+		q.share = true
+	}
+}
+
+// Query returns the current estimates for the most frequent elements, shaped
+// by opts. Without any options it is equivalent to Keys.
+func (s *Stream) Query(opts ...QueryOption) []Element {
+	var q query
+	for _, opt := range opts {
+		opt(&q)
+	}
+
+	elts := append([]Element(nil), s.k.elts...)
+	sort.Slice(elts, func(i, j int) bool {
+		return q.order.less(&s.k, elts[i], elts[j])
+	})
+	if len(elts) > s.n {
+		elts = elts[:s.n]
+	}
+	if q.share && s.total != 0 {
+		for i := range elts {
+			elts[i].Share = float64(elts[i].Count) / float64(s.total)
+		}
+	}
+	s.reportAll(elts)
+	return elts
+}
+
+// KeysPage returns limit elements of Keys starting at offset.
+//
+// Only the elements up to offset+limit are selected and sorted, so paging
+// through the head of a large sketch doesn't sort all n elements for every
+// page.
+func (s *Stream) KeysPage(offset, limit int) []Element {
+	if offset < 0 || limit <= 0 || offset >= len(s.k.elts) {
+		return nil
+	}
+	// limit may be as large as math.MaxInt
+	m := offset + min(limit, len(s.k.elts)-offset)
+
+	elts := s.best(m).elts
+	s.k.sort(elts)
+	elts = elts[offset:]
+	s.reportAll(elts)
+	return elts
+}
+
+// Cutoff returns the estimated count and error of the k-th element in the
+// order of Keys, the count a key has to exceed to enter the top k, without
+// sorting the elements. With WithScore the element is the k-th by score.
+// It returns zeros if fewer than k elements are tracked.
+func (s *Stream) Cutoff(k int) (count, errs int) {
+	if k <= 0 || k > len(s.k.elts) {
+		return 0, 0
+	}
+	e := s.report(s.best(k).elts[0])
+	return e.Count, e.Error
+}
+
+// best returns the m highest ranked elements, the lowest of them on top
+func (s *Stream) best(m int) *worstFirst {
+	h := &worstFirst{elts: make([]Element, 0, m), tk: &s.k}
+	for _, e := range s.k.elts {
+		if len(h.elts) < m {
+			heap.Push(h, e)
+		} else if s.k.ranked(e, h.elts[0]) {
+			h.elts[0] = e
+			heap.Fix(h, 0)
+		}
+	}
+	return h
+}
+
+type worstFirst struct {
+	elts []Element
+	tk   *keys
+}
+
+func (h *worstFirst) Len() int           { return len(h.elts) }
+func (h *worstFirst) Less(i, j int) bool { return h.tk.ranked(h.elts[j], h.elts[i]) }
+func (h *worstFirst) Swap(i, j int)      { h.elts[i], h.elts[j] = h.elts[j], h.elts[i] }
+func (h *worstFirst) Push(x interface{}) { h.elts = append(h.elts, x.(Element)) }
+func (h *worstFirst) Pop() interface{} {
+	e := h.elts[len(h.elts)-1]
+	h.elts = h.elts[:len(h.elts)-1]
+	return e
+}
+
+// HeavyHitter is an element returned by KeysAboveShare
+type HeavyHitter struct {
+	Element
+	// Guaranteed is set if even the lower bound of the element's count
+	// exceeds the requested share
+	Guaranteed bool `json:"guaranteed"`
+}
+
+// KeysAboveShare returns the elements estimated to account for more than the
+// fraction p of the total count of all inserted elements, in the order of
+// Keys.
+func (s *Stream) KeysAboveShare(p float64) []HeavyHitter {
+	threshold := p * float64(s.total)
+
+	var res []HeavyHitter
+	for _, e := range s.Keys() {
+		// with WithScore the order of Keys isn't by count
+		if float64(e.Count) <= threshold {
+			continue
+		}
+		res = append(res, HeavyHitter{
+			Element:    e,
+			Guaranteed: float64(e.LowerBound()) > threshold,
+		})
+	}
+	return res
+}
+
+// KeysMatching returns the tracked elements whose key satisfies pred, in the
+// order of Keys. Only the matching elements are copied and sorted.
+func (s *Stream) KeysMatching(pred func(string) bool) []Element {
+	var elts []Element
+	for _, e := range s.k.elts {
+		if pred(e.Key) {
+			elts = append(elts, e)
+		}
+	}
+	s.k.sort(elts)
+	s.reportAll(elts)
+	return elts
+}
+
+// KeysWithPrefix returns the tracked elements whose key starts with prefix,
+// in the order of Keys
+func (s *Stream) KeysWithPrefix(prefix string) []Element {
+	return s.KeysMatching(func(x string) bool {
+		return strings.HasPrefix(x, prefix)
+	})
+}
+
+// Membership is whether a key belongs to the true top k, see InTop
+type Membership uint8
+
+const (
+	// NotInTop is certain: at least k tracked keys are guaranteed to be
+	// more frequent
+	NotInTop Membership = iota
+	// MaybeInTop is undecided by the bounds of the estimates
+	MaybeInTop
+	// InTop is certain: fewer than k keys can be as frequent
+	InTop
+)
+
+func (m Membership) String() string {
+	switch m {
+	case NotInTop:
+		return "not-in-top"
+	case MaybeInTop:
+		return "maybe-in-top"
+	case InTop:
+		return "in-top"
+	}
+	return fmt.Sprintf("Membership(%d)", uint8(m))
+}
+
+// InTop reports whether x is among the k most frequent keys, comparing the
+// bounds of its estimate with those of the other keys: untracked keys can
+// be as frequent as the largest alpha.
+func (s *Stream) InTop(x string, k int) Membership {
+	if k <= 0 {
+		return NotInTop
+	}
+	x, _ = s.key(x)
+	e := s.estimate(x)
+
+	// keys guaranteed to be more frequent than x can be, and keys that may
+	// be at least as frequent as x is guaranteed to be
+	var above, rivals int
+	for _, o := range s.k.elts {
+		if o.Key == x {
+			continue
+		}
+		if o.LowerBound() > e.UpperBound() {
+			above++
+		}
+		if o.UpperBound() >= e.LowerBound() {
+			rivals++
+		}
+	}
+	if above >= k {
+		return NotInTop
+	}
+	if _, tracked := s.k.m[x]; !tracked || rivals >= k {
+		return MaybeInTop
+	}
+	for _, a := range s.alphas {
+		if a >= e.LowerBound() {
+			return MaybeInTop
+		}
+	}
+	return InTop
+}
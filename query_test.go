@@ -0,0 +1,218 @@
+package topk
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestQueryOrder(t *testing.T) {
+	s := New(3)
+	s.Insert("a", 10)
+	s.Insert("b", 5)
+	s.Insert("c", 8)
+	// replaces b
+	s.Insert("d", 6)
+	// b comes back replacing d, with its count at eviction as error
+	s.Insert("b", 7)
+
+	keysOf := func(elts []Element) []string {
+		res := make([]string, len(elts))
+		for i, e := range elts {
+			res[i] = e.Key
+		}
+		return res
+	}
+
+	cases := []struct {
+		order Order
+		want  []string
+	}{
+		{ByCount, []string{"b", "a", "c"}},
+		{ByLowerBound, []string{"a", "c", "b"}},
+		{ByError, []string{"a", "c", "b"}},
+		{ByKey, []string{"a", "b", "c"}},
+	}
+	for _, c := range cases {
+		if got := keysOf(s.Query(OrderBy(c.order))); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("order %d: got %v, want %v", c.order, got, c.want)
+		}
+	}
+
+	if !reflect.DeepEqual(s.Query(), s.Keys()) {
+		t.Error("default query differs from Keys")
+	}
+}
+
+func TestKeysPage(t *testing.T) {
+	s := New(100)
+	for _, w := range loadWords() {
+		s.Insert(w, 1)
+	}
+	keys := s.Keys()
+
+	for _, c := range []struct{ offset, limit int }{
+		{0, 10}, {10, 10}, {95, 10}, {0, 100}, {0, 1000}, {99, 1}, {10, math.MaxInt},
+	} {
+		end := c.offset + min(c.limit, len(keys)-c.offset)
+		if got := s.KeysPage(c.offset, c.limit); !reflect.DeepEqual(got, keys[c.offset:end]) {
+			t.Errorf("page %d+%d: got %v, want %v", c.offset, c.limit, got, keys[c.offset:end])
+		}
+	}
+
+	for _, c := range []struct{ offset, limit int }{
+		{100, 10}, {0, 0}, {-1, 10},
+	} {
+		if got := s.KeysPage(c.offset, c.limit); len(got) != 0 {
+			t.Errorf("page %d+%d: expected no elements, got %v", c.offset, c.limit, got)
+		}
+	}
+}
+
+func TestCutoff(t *testing.T) {
+	s := New(100)
+	for _, w := range loadWords() {
+		s.Insert(w, 1)
+	}
+	keys := s.Keys()
+	for _, k := range []int{1, 10, 100} {
+		if count, errs := s.Cutoff(k); count != keys[k-1].Count || errs != keys[k-1].Error {
+			t.Errorf("k %d: expected %v, got %d %d", k, keys[k-1], count, errs)
+		}
+	}
+	for _, k := range []int{0, 101} {
+		if count, errs := s.Cutoff(k); count != 0 || errs != 0 {
+			t.Errorf("k %d: expected zeros, got %d %d", k, count, errs)
+		}
+	}
+}
+
+func TestKeysAboveShare(t *testing.T) {
+	s := New(4)
+	s.Insert("a", 50)
+	s.Insert("b", 5)
+	s.Insert("c", 25)
+	s.Insert("d", 15)
+	// pretend c was admitted with an error
+	s.k.elts[s.k.m["c"]].Error = 10
+
+	if s.Total() != 95 {
+		t.Fatalf("expected total 95, got %d", s.Total())
+	}
+
+	got := s.KeysAboveShare(0.25)
+	want := []HeavyHitter{
+		{Element: Element{Key: "a", Count: 50}, Guaranteed: true},
+		{Element: Element{Key: "c", Count: 25, Error: 10}, Guaranteed: false},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	if got := s.KeysAboveShare(0.6); len(got) != 0 {
+		t.Errorf("expected no elements above 60%%, got %v", got)
+	}
+}
+
+func TestKeysMatching(t *testing.T) {
+	s := New(10)
+	s.Insert("/api/v1/users", 7)
+	s.Insert("/api/v2/users", 3)
+	s.Insert("/api/v2/orders", 5)
+	s.Insert("/health", 100)
+
+	want := []Element{
+		{Key: "/api/v2/orders", Count: 5},
+		{Key: "/api/v2/users", Count: 3},
+	}
+	if got := s.KeysWithPrefix("/api/v2/"); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	if got := s.KeysMatching(func(string) bool { return false }); len(got) != 0 {
+		t.Errorf("expected no elements, got %v", got)
+	}
+}
+
+func TestQueryScore(t *testing.T) {
+	s := New(10, WithScore(func(e Element) float64 {
+		return -float64(e.Count)
+	}))
+	s.Insert("small", 1)
+	s.Insert("medium", 10)
+	s.Insert("large", 100)
+
+	keys := s.Keys()
+	if keys[0].Key != "small" {
+		t.Fatalf("expected small ranked first by score, got %v", keys)
+	}
+	for name, got := range map[string][]Element{
+		"Query":        s.Query(),
+		"KeysPage":     s.KeysPage(0, 3),
+		"KeysMatching": s.KeysMatching(func(string) bool { return true }),
+	} {
+		if !reflect.DeepEqual(got, keys) {
+			t.Errorf("%s: expected the order of Keys %v, got %v", name, keys, got)
+		}
+	}
+	if got := s.KeysPage(1, 1); len(got) != 1 || got[0].Key != "medium" {
+		t.Errorf("expected medium on the second page, got %v", got)
+	}
+	if count, _ := s.Cutoff(1); count != 1 {
+		t.Errorf("expected the cutoff of the top ranked small, got %d", count)
+	}
+	if got := s.KeysAboveShare(0.1); len(got) != 1 || got[0].Key != "large" {
+		t.Errorf("expected large above the share, got %v", got)
+	}
+}
+
+func TestQueryShare(t *testing.T) {
+	s := New(10)
+	s.Insert("a", 3)
+	s.Insert("b", 1)
+
+	// the share survives encoding since the total is persisted
+	data, err := s.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded := &Stream{}
+	if err := decoded.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Element{{Key: "a", Count: 3, Share: 0.75}, {Key: "b", Count: 1, Share: 0.25}}
+	if got := decoded.Query(WithShare()); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+	if got := s.Query(); got[0].Share != 0 {
+		t.Errorf("expected no share without WithShare, got %v", got)
+	}
+}
+
+func TestInTop(t *testing.T) {
+	s := New(2)
+	s.Insert("a", 100)
+	s.Insert("b", 5)
+	// c evicts b, which keeps its count as the alpha of its slot
+	s.Insert("c", 5)
+
+	cases := []struct {
+		key  string
+		k    int
+		want Membership
+	}{
+		{"a", 1, InTop},
+		{"a", 0, NotInTop},
+		{"b", 1, NotInTop},
+		{"c", 1, NotInTop},
+		// b could be as frequent as c
+		{"c", 2, MaybeInTop},
+		{"b", 2, MaybeInTop},
+	}
+	for _, c := range cases {
+		if got := s.InTop(c.key, c.k); got != c.want {
+			t.Errorf("%s in top %d: expected %v, got %v", c.key, c.k, c.want, got)
+		}
+	}
+}
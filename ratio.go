@@ -0,0 +1,176 @@
+package topk
+
+import (
+	"container/heap"
+	"sort"
+)
+
+// RatioElement is a key tracked by a RatioStream with its two counters,
+// e.g. errors and requests
+type RatioElement struct {
+	Key string `json:"key"`
+	Num int    `json:"num"`
+	Den int    `json:"den"`
+	// Error is the denominator of the element the key replaced on
+	// admission, occurrences before that were not counted
+	Error int `json:"error"`
+}
+
+// Ratio returns Num/Den, 0 for an empty denominator
+func (e RatioElement) Ratio() float64 {
+	if e.Den == 0 {
+		return 0
+	}
+	return float64(e.Num) / float64(e.Den)
+}
+
+// RatioStream calculates the TopK elements of a stream ranked by the ratio of
+// two counters, e.g. the error rate or click-through rate of a key.
+//
+// Keys with a denominator below the minimum are not ranked by their ratio
+// yet: they are the first to be replaced, the one with the smallest
+// denominator first, so keys with a single occurrence can't evict keys that
+// have built up a meaningful ratio.
+type RatioStream struct {
+	n      int
+	minDen int
+	k      ratioKeys
+}
+
+// NewRatio returns a RatioStream estimating the top n elements by ratio,
+// only ranking keys whose denominator reached minDen
+func NewRatio(n, minDen int) *RatioStream {
+	return &RatioStream{
+		n:      n,
+		minDen: minDen,
+		k:      ratioKeys{m: make(map[string]int, n), elts: make([]RatioElement, 0, n), minDen: minDen},
+	}
+}
+
+// Insert adds num and den to the counters of x.
+// It returns the current counters of x
+func (s *RatioStream) Insert(x string, num, den int) RatioElement {
+	if idx, ok := s.k.m[x]; ok {
+		s.k.elts[idx].Num += num
+		s.k.elts[idx].Den += den
+		e := s.k.elts[idx]
+		heap.Fix(&s.k, idx)
+		return e
+	}
+
+	e := RatioElement{Key: x, Num: num, Den: den}
+	if len(s.k.elts) < s.n {
+		heap.Push(&s.k, e)
+		return e
+	}
+
+	// replace the current minimum element
+	minElement := s.k.elts[0]
+	e.Error = minElement.Den
+	delete(s.k.m, minElement.Key)
+	s.k.elts[0] = e
+	s.k.m[x] = 0
+	heap.Fix(&s.k, 0)
+	return e
+}
+
+// Estimate returns the counters of x, zero if x is not tracked
+func (s *RatioStream) Estimate(x string) RatioElement {
+	if idx, ok := s.k.m[x]; ok {
+		return s.k.elts[idx]
+	}
+	return RatioElement{Key: x}
+}
+
+// Keys returns the tracked elements by descending ratio, keys below the
+// minimum denominator come last
+func (s *RatioStream) Keys() []RatioElement {
+	elts := append([]RatioElement(nil), s.k.elts...)
+	sort.Slice(elts, func(i, j int) bool {
+		return s.k.less(elts[j], elts[i])
+	})
+	return elts
+}
+
+// Merge adds the counters of other to s. Keys tracked by only one of the
+// streams keep their counters, so the merged counters are lower bounds.
+func (s *RatioStream) Merge(other *RatioStream) error {
+	if s.n != other.n || s.minDen != other.minDen {
+		return incompatible("expected ratio stream of size n %d and minimum denominator %d, got %d and %d", s.n, s.minDen, other.n, other.minDen)
+	}
+
+	merged := make(map[string]RatioElement, len(s.k.elts)+len(other.k.elts))
+	for _, e := range s.k.elts {
+		merged[e.Key] = e
+	}
+	for _, e := range other.k.elts {
+		m := merged[e.Key]
+		m.Key = e.Key
+		m.Num += e.Num
+		m.Den += e.Den
+		m.Error += e.Error
+		merged[e.Key] = m
+	}
+
+	elts := make([]RatioElement, 0, len(merged))
+	for _, e := range merged {
+		elts = append(elts, e)
+	}
+	sort.Slice(elts, func(i, j int) bool {
+		return s.k.less(elts[j], elts[i]) || (!s.k.less(elts[i], elts[j]) && elts[i].Key < elts[j].Key)
+	})
+	if len(elts) > s.n {
+		elts = elts[:s.n]
+	}
+
+	s.k.m = make(map[string]int, s.n)
+	s.k.elts = s.k.elts[:0]
+	for _, e := range elts {
+		heap.Push(&s.k, e)
+	}
+	return nil
+}
+
+type ratioKeys struct {
+	m      map[string]int
+	elts   []RatioElement
+	minDen int
+}
+
+// less orders the elements to be replaced first: keys below the minimum
+// denominator by denominator, then the others by ratio
+func (tk *ratioKeys) less(a, b RatioElement) bool {
+	aRanked, bRanked := a.Den >= tk.minDen, b.Den >= tk.minDen
+	switch {
+	case aRanked != bRanked:
+		return bRanked
+	case !aRanked:
+		return a.Den < b.Den
+	}
+	// compare a.Num/a.Den < b.Num/b.Den without dividing
+	if l, r := a.Num*b.Den, b.Num*a.Den; l != r {
+		return l < r
+	}
+	return a.Den < b.Den
+}
+
+func (tk *ratioKeys) Len() int           { return len(tk.elts) }
+func (tk *ratioKeys) Less(i, j int) bool { return tk.less(tk.elts[i], tk.elts[j]) }
+func (tk *ratioKeys) Swap(i, j int) {
+	tk.elts[i], tk.elts[j] = tk.elts[j], tk.elts[i]
+	tk.m[tk.elts[i].Key] = i
+	tk.m[tk.elts[j].Key] = j
+}
+
+func (tk *ratioKeys) Push(x interface{}) {
+	e := x.(RatioElement)
+	tk.m[e.Key] = len(tk.elts)
+	tk.elts = append(tk.elts, e)
+}
+
+func (tk *ratioKeys) Pop() interface{} {
+	var e RatioElement
+	e, tk.elts = tk.elts[len(tk.elts)-1], tk.elts[:len(tk.elts)-1]
+	delete(tk.m, e.Key)
+	return e
+}
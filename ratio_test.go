@@ -0,0 +1,51 @@
+package topk
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestRatioStream(t *testing.T) {
+	s := NewRatio(5, 10)
+
+	// three endpoints with established error rates
+	for i := 0; i < 100; i++ {
+		s.Insert("/a", boolInt(i%2 == 0), 1)  // 50%
+		s.Insert("/b", boolInt(i%10 == 0), 1) // 10%
+		s.Insert("/c", boolInt(i%4 == 0), 1)  // 25%
+	}
+	// a flood of one-off failing requests doesn't evict them
+	for i := 0; i < 1000; i++ {
+		s.Insert(fmt.Sprintf("/noise-%d", i), 1, 1)
+	}
+
+	keys := s.Keys()
+	for i, want := range []string{"/a", "/c", "/b"} {
+		if keys[i].Key != want {
+			t.Errorf("expected %s at %d, got %v", want, i, keys)
+		}
+	}
+	if r := s.Estimate("/c").Ratio(); r != 0.25 {
+		t.Errorf("expected /c at 25%%, got %v", r)
+	}
+
+	other := NewRatio(5, 10)
+	other.Insert("/b", 90, 100)
+	if err := s.Merge(other); err != nil {
+		t.Fatal(err)
+	}
+	if e := s.Keys()[0]; e.Key != "/b" || e.Num != 100 || e.Den != 200 {
+		t.Errorf("expected merged /b on top, got %v", e)
+	}
+
+	if err := s.Merge(NewRatio(5, 1)); err == nil {
+		t.Error("expected merging different minimum denominators to fail")
+	}
+}
+
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,102 @@
+package topk
+
+import (
+	"math"
+	"sort"
+)
+
+// ShardLoad describes the state of a shard of a Sharded
+type ShardLoad struct {
+	// Tracked is the number of tracked elements, Capacity the number of
+	// elements the shard can track
+	Tracked, Capacity int
+	// Total is the sum of the counts inserted into the shard
+	Total int
+}
+
+// Loads returns the state of each shard
+func (sh *Sharded) Loads() []ShardLoad {
+	loads := make([]ShardLoad, len(sh.shards))
+	for i := range sh.shards {
+		shd := &sh.shards[i]
+		shd.mu.Lock()
+		loads[i] = ShardLoad{Tracked: len(shd.s.k.elts), Capacity: shd.s.n, Total: shd.s.total}
+		shd.mu.Unlock()
+	}
+	return loads
+}
+
+// Imbalance returns the total count inserted into the busiest shard relative
+// to the mean of all shards, 1 for perfectly balanced shards. A few heavy
+// keys or adversarial ones hashing to the same shard make it grow.
+func (sh *Sharded) Imbalance() float64 {
+	return imbalance(sh.Loads())
+}
+
+func imbalance(loads []ShardLoad) float64 {
+	var sum, max int
+	for _, l := range loads {
+		sum += l.Total
+		if l.Total > max {
+			max = l.Total
+		}
+	}
+	if sum == 0 {
+		return 1
+	}
+	return float64(max) * float64(len(loads)) / float64(sum)
+}
+
+// Rebalance redistributes the capacity of the shards in proportion to the
+// counts inserted into them: busy shards borrow capacity from idle ones,
+// which keep at least n/shards of it. The capacities add up to at most n
+// times the number of shards. Shrunk shards keep the counts of the elements
+// they drop in their alphas, see Stream.Resize.
+func (sh *Sharded) Rebalance() {
+	loads := sh.Loads()
+	// totals may be saturated, their sum and shares are computed in float64
+	var sum float64
+	for _, l := range loads {
+		sum += float64(l.Total)
+	}
+	if sum == 0 {
+		return
+	}
+
+	budget := sh.n * len(sh.shards)
+	floor := sh.n / len(sh.shards)
+	if floor < 1 {
+		floor = 1
+	}
+	// every shard keeps the floor, the rest is shared by load and what
+	// rounding down leaves goes to the largest fractions
+	rest := budget - floor*len(sh.shards)
+	caps := make([]int, len(sh.shards))
+	fracs := make([]float64, len(sh.shards))
+	left := rest
+	for i, l := range loads {
+		share := float64(rest) * float64(l.Total) / sum
+		caps[i] = floor + int(share)
+		fracs[i] = share - math.Floor(share)
+		left -= int(share)
+	}
+	order := make([]int, len(sh.shards))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool { return fracs[order[a]] > fracs[order[b]] })
+	for _, i := range order[:left] {
+		caps[i]++
+	}
+
+	for i, c := range caps {
+		shd := &sh.shards[i]
+		shd.mu.Lock()
+		if c != shd.s.n {
+			// c is at least the floor of 1
+			shd.s.Resize(c)
+			shd.dirty = true
+		}
+		shd.mu.Unlock()
+	}
+}
@@ -0,0 +1,67 @@
+package topk
+
+import (
+	"fmt"
+	"math"
+	"testing"
+)
+
+func TestRebalance(t *testing.T) {
+	sh := NewSharded(10, 4)
+	if im := sh.Imbalance(); im != 1 {
+		t.Errorf("expected an empty sketch to be balanced, got %v", im)
+	}
+
+	// flood the shard of one key with distinct keys hashing to it
+	hot := shardOf("hot", 4)
+	for i := 0; len(sh.shards[hot].s.k.elts) < 10 || i < 1000; i++ {
+		if x := fmt.Sprintf("key-%d", i); shardOf(x, 4) == hot {
+			sh.Insert(x, 1)
+		}
+	}
+	for i := 0; i < 4; i++ {
+		sh.Insert(fmt.Sprintf("cold-%d", i), 1)
+	}
+
+	if im := sh.Imbalance(); im < 3 {
+		t.Errorf("expected an imbalance of about 4, got %v", im)
+	}
+
+	sh.Rebalance()
+	loads := sh.Loads()
+	var capacity int
+	for i, l := range loads {
+		capacity += l.Capacity
+		if uint32(i) != hot && l.Capacity != 2 {
+			t.Errorf("expected idle shard %d to keep the minimum capacity, got %+v", i, l)
+		}
+	}
+	if c := loads[hot].Capacity; c < 30 {
+		t.Errorf("expected the hot shard to borrow capacity, got %d", c)
+	}
+	if capacity > 4*10 {
+		t.Errorf("expected the capacity to stay within the budget, got %d", capacity)
+	}
+
+	if ep := sh.Advance(); len(ep.Keys()) != 10 {
+		t.Errorf("expected 10 keys, got %v", ep.Keys())
+	}
+}
+
+func TestRebalanceSaturated(t *testing.T) {
+	sh := NewSharded(10, 3)
+	for i := range sh.shards {
+		sh.shards[i].s.total = math.MaxInt - i
+	}
+	sh.Rebalance()
+	var capacity int
+	for i, l := range sh.Loads() {
+		capacity += l.Capacity
+		if l.Capacity < 3 {
+			t.Errorf("expected shard %d to keep the minimum capacity, got %+v", i, l)
+		}
+	}
+	if capacity != 3*10 {
+		t.Errorf("expected the whole budget of 30 to be distributed, got %d", capacity)
+	}
+}
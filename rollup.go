@@ -0,0 +1,266 @@
+package topk
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"time"
+)
+
+// DefaultResolutions are the per-minute, per-hour and per-day resolutions
+// of a Rollup
+var DefaultResolutions = []time.Duration{time.Minute, time.Hour, 24 * time.Hour}
+
+// Rollup maintains sketches of the top n elements at several resolutions,
+// e.g. per minute, hour and day. Inserts only go to the bucket of the finest
+// resolution, which is merged into the bucket of the next coarser resolution
+// once it is sealed, and so on: every insert is fed to all resolutions for
+// the cost of one insert and a merge per finest bucket.
+//
+// Buckets are aligned to their resolution like time.Truncate and sealed
+// once their time has passed, empty buckets are skipped.
+type Rollup struct {
+	n      int
+	opts   []Option
+	clock  Clock
+	levels []rollupLevel // finest first
+
+	spill       SnapshotStore // see SpillTo
+	spillName   string
+	spillErrors func(error)
+}
+
+type rollupLevel struct {
+	size    time.Duration
+	cur     *Stream
+	start   time.Time
+	buckets []RollupBucket // sealed, oldest first
+	retain  int            // see SetRetention
+}
+
+// RollupBucket is a sealed bucket of a Rollup
+type RollupBucket struct {
+	Start, End time.Time
+	*View
+}
+
+// NewRollup returns a Rollup of streams created by New(n, opts...) at the
+// given resolutions, each of them has to be a multiple of the next finer
+// one. A nil clock is the system clock.
+func NewRollup(n int, resolutions []time.Duration, clock Clock, opts ...Option) (*Rollup, error) {
+	if len(resolutions) == 0 {
+		return nil, fmt.Errorf("topk: no resolutions")
+	}
+	for i, res := range resolutions {
+		if res <= 0 {
+			return nil, fmt.Errorf("topk: resolution %v not positive", res)
+		}
+		if i > 0 && (res <= resolutions[i-1] || res%resolutions[i-1] != 0) {
+			return nil, fmt.Errorf("topk: resolution %v is not a multiple of %v", res, resolutions[i-1])
+		}
+	}
+	if clock == nil {
+		clock = SystemClock{}
+	}
+
+	r := &Rollup{n: n, opts: opts, clock: clock}
+	now := clock.Now()
+	for _, res := range resolutions {
+		r.levels = append(r.levels, rollupLevel{size: res, cur: New(n, opts...), start: now.Truncate(res)})
+	}
+	return r, nil
+}
+
+// Insert adds an element to the bucket of the finest resolution.
+// It returns the estimation of the element in that bucket
+func (r *Rollup) Insert(x string, count int) Element {
+	r.advance()
+	return r.levels[0].cur.Insert(x, count)
+}
+
+// advance seals the buckets whose time has passed
+func (r *Rollup) advance() {
+	now := r.clock.Now()
+	for i := range r.levels {
+		r.roll(i, now)
+	}
+}
+
+// roll seals the current bucket of level i if now is past its end, and
+// merges it into the coarser level
+func (r *Rollup) roll(i int, now time.Time) {
+	l := &r.levels[i]
+	end := l.start.Add(l.size)
+	if now.Before(end) {
+		return
+	}
+	if l.cur.total > 0 || l.cur.Len() > 0 {
+		b := RollupBucket{Start: l.start, End: end, View: l.cur.Freeze()}
+		l.buckets = append(l.buckets, b)
+		r.trim(l)
+		if i+1 < len(r.levels) {
+			// the coarser bucket b belongs to may have passed as well
+			r.roll(i+1, b.Start)
+			_ = r.levels[i+1].cur.Merge(&b.View.s) // same n
+		}
+		l.cur = New(r.n, r.opts...)
+	}
+	l.start = now.Truncate(l.size)
+}
+
+// level returns the level of the given resolution
+func (r *Rollup) level(resolution time.Duration) (*rollupLevel, int, error) {
+	for i := range r.levels {
+		if r.levels[i].size == resolution {
+			return &r.levels[i], i, nil
+		}
+	}
+	return nil, 0, fmt.Errorf("topk: no resolution %v", resolution)
+}
+
+// Current returns the bucket of the given resolution in progress, including
+// the inserts still in the buckets of the finer resolutions
+func (r *Rollup) Current(resolution time.Duration) (RollupBucket, error) {
+	r.advance()
+	l, i, err := r.level(resolution)
+	if err != nil {
+		return RollupBucket{}, err
+	}
+	s := l.cur.clone()
+	for _, finer := range r.levels[:i] {
+		_ = s.Merge(finer.cur) // same n
+	}
+	return RollupBucket{Start: l.start, End: l.start.Add(l.size), View: s.Freeze()}, nil
+}
+
+// Buckets returns the sealed buckets of the given resolution, oldest first
+func (r *Rollup) Buckets(resolution time.Duration) ([]RollupBucket, error) {
+	r.advance()
+	l, _, err := r.level(resolution)
+	if err != nil {
+		return nil, err
+	}
+	return append([]RollupBucket(nil), l.buckets...), nil
+}
+
+// Keys returns the estimates for the most frequent elements in the bucket of
+// the given resolution in progress
+func (r *Rollup) Keys(resolution time.Duration) ([]Element, error) {
+	b, err := r.Current(resolution)
+	if err != nil {
+		return nil, err
+	}
+	return b.Keys(), nil
+}
+
+// SetRetention keeps the last buckets sealed buckets of the given resolution,
+// e.g. 1440 minutes, 168 hours and 90 days, older ones expire. A retention
+// of 0, the default, keeps all of them.
+func (r *Rollup) SetRetention(resolution time.Duration, buckets int) error {
+	l, _, err := r.level(resolution)
+	if err != nil {
+		return err
+	}
+	l.retain = max(buckets, 0)
+	r.trim(l)
+	return nil
+}
+
+// trim expires the buckets of l beyond its retention
+func (r *Rollup) trim(l *rollupLevel) {
+	for l.retain > 0 && len(l.buckets) > l.retain {
+		r.expire(l.size, l.buckets[0])
+		l.buckets[0] = RollupBucket{} // release the expired stream
+		l.buckets = l.buckets[1:]
+	}
+}
+
+// SpillTo writes the expiring buckets to store before they are dropped, as
+// snapshots at their start named after name and their resolution, e.g.
+// "requests-1h0m0s". Errors of the store are passed to onError if not nil.
+func (r *Rollup) SpillTo(store SnapshotStore, name string, onError func(error)) {
+	r.spill, r.spillName, r.spillErrors = store, name, onError
+}
+
+// expire spills the bucket b of the given resolution
+func (r *Rollup) expire(resolution time.Duration, b RollupBucket) {
+	if r.spill == nil {
+		return
+	}
+	var buf bytes.Buffer
+	err := b.Encode(&buf)
+	if err == nil {
+		err = r.spill.Put(context.Background(), fmt.Sprintf("%s-%v", r.spillName, resolution), b.Start, &buf)
+	}
+	if err != nil && r.spillErrors != nil {
+		r.spillErrors(err)
+	}
+}
+
+// MemoryUsage returns an estimate of the bytes held by the buckets of all
+// resolutions, see Stream.MemoryUsage
+func (r *Rollup) MemoryUsage() int {
+	var size int
+	for _, l := range r.levels {
+		size += l.cur.MemoryUsage()
+		for _, b := range l.buckets {
+			size += b.View.s.MemoryUsage()
+		}
+	}
+	return size
+}
+
+// Query returns a snapshot of the top k elements between from and to, merged
+// from the buckets within that range. The coarsest buckets are used where
+// they fit and the finer ones fill the rest, e.g. the hours of a day
+// followed by the minutes of the hour in progress. Buckets only partially
+// within the range are left out, a to past the present includes the buckets
+// in progress. The error bound of the snapshot is that of the merged result.
+func (r *Rollup) Query(from, to time.Time, k int) (Snapshot, error) {
+	if !from.Before(to) {
+		return Snapshot{}, fmt.Errorf("topk: empty time range %v to %v", from, to)
+	}
+	r.advance()
+
+	type span struct{ start, end time.Time }
+	var covered []span
+	free := func(b RollupBucket) bool {
+		if b.Start.Before(from) || b.End.After(to) {
+			return false
+		}
+		for _, c := range covered {
+			if b.Start.Before(c.end) && c.start.Before(b.End) {
+				return false
+			}
+		}
+		return true
+	}
+
+	s := New(r.n, r.opts...)
+	for i := len(r.levels) - 1; i >= 0; i-- {
+		l := &r.levels[i]
+		buckets := l.buckets
+		if cur, err := r.Current(l.size); err == nil {
+			buckets = append(buckets[:len(buckets):len(buckets)], cur)
+		}
+		for _, b := range buckets {
+			if !free(b) {
+				continue
+			}
+			covered = append(covered, span{b.Start, b.End})
+			if err := s.Merge(&b.View.s); err != nil {
+				return Snapshot{}, err
+			}
+		}
+	}
+
+	s.clock = r.clock
+	snap := s.Snapshot()
+	if k >= 0 && len(snap.Keys) > k {
+		for _, e := range snap.Keys[k:] {
+			delete(snap.ranks, e.Key)
+		}
+		snap.Keys = snap.Keys[:k]
+	}
+	return snap, nil
+}
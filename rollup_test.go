@@ -0,0 +1,158 @@
+package topk
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRollup(t *testing.T) {
+	if _, err := NewRollup(10, []time.Duration{time.Minute, 90 * time.Second}, nil); err == nil {
+		t.Error("expected an error for resolutions that are not multiples")
+	}
+
+	clock := NewFakeClock(time.Unix(0, 0))
+	r, err := NewRollup(10, DefaultResolutions, clock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 3 hours of one insert per minute, and a burst in the second hour
+	for i := 0; i < 180; i++ {
+		r.Insert("steady", 1)
+		if i/60 == 1 {
+			r.Insert("burst", 2)
+		}
+		clock.Advance(time.Minute)
+	}
+	r.Insert("steady", 1)
+
+	minutes, err := r.Buckets(time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(minutes) != 180 || minutes[0].End.Sub(minutes[0].Start) != time.Minute {
+		t.Errorf("expected 180 sealed minutes, got %d", len(minutes))
+	}
+	hours, _ := r.Buckets(time.Hour)
+	if len(hours) != 3 {
+		t.Fatalf("expected 3 sealed hours, got %d", len(hours))
+	}
+	if e := hours[1].Estimate("burst"); e.Count != 120 {
+		t.Errorf("expected the burst in the second hour, got %v", e)
+	}
+	if e := hours[2].Estimate("burst"); e.Count != 0 {
+		t.Errorf("expected no burst in the third hour, got %v", e)
+	}
+
+	// the day in progress includes the minute in progress
+	day, err := r.Current(24 * time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e := day.Estimate("steady"); e.Count != 181 {
+		t.Errorf("expected 181 steady inserts today, got %v", e)
+	}
+	if keys, _ := r.Keys(time.Hour); len(keys) != 1 || keys[0].Count != 1 {
+		t.Errorf("expected a single insert in the hour in progress, got %v", keys)
+	}
+	if _, err := r.Keys(time.Second); err == nil {
+		t.Error("expected an error for an unknown resolution")
+	}
+}
+
+func TestRollupRetention(t *testing.T) {
+	clock := NewFakeClock(time.Unix(0, 0))
+	r, err := NewRollup(10, DefaultResolutions, clock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := NewDirStore(t.TempDir())
+	var spillErrors int
+	r.SpillTo(store, "test", func(error) { spillErrors++ })
+	if err := r.SetRetention(time.Minute, 30); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.SetRetention(time.Second, 30); err == nil {
+		t.Error("expected an error for an unknown resolution")
+	}
+
+	for i := 0; i < 90; i++ {
+		r.Insert("x", 1)
+		clock.Advance(time.Minute)
+	}
+	before := r.MemoryUsage()
+	r.Insert("x", 1)
+
+	minutes, _ := r.Buckets(time.Minute)
+	if len(minutes) != 30 || !minutes[0].Start.Equal(time.Unix(60*60, 0)) {
+		t.Errorf("expected the last 30 minutes, got %d from %v", len(minutes), minutes[0].Start)
+	}
+	spilled, err := store.List(context.Background(), "test-1m0s")
+	if err != nil || len(spilled) != 60 || spillErrors != 0 {
+		t.Fatalf("expected 60 spilled minutes, got %d: %v", len(spilled), err)
+	}
+	rc, err := store.Get(context.Background(), "test-1m0s", spilled[0].Time)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	s := New(10)
+	if err := s.Decode(rc); err != nil || s.Estimate("x").Count != 1 {
+		t.Errorf("expected a spilled minute with x, got %v %v", s.Keys(), err)
+	}
+
+	// shrinking the retention expires buckets right away
+	if err := r.SetRetention(time.Minute, 10); err != nil {
+		t.Fatal(err)
+	}
+	if after := r.MemoryUsage(); after <= 0 || after >= before {
+		t.Errorf("expected the memory usage to shrink from %d, got %d", before, after)
+	}
+}
+
+func TestRollupQuery(t *testing.T) {
+	start := time.Unix(0, 0)
+	clock := NewFakeClock(start)
+	r, err := NewRollup(10, []time.Duration{time.Minute, time.Hour}, clock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// one insert of a per minute for 3 hours, b only in the first hour
+	for i := 0; i < 180; i++ {
+		r.Insert("a", 1)
+		if i < 60 {
+			r.Insert("b", 3)
+		}
+		clock.Advance(time.Minute)
+	}
+	r.Insert("a", 1)
+
+	cases := []struct {
+		from, to time.Duration
+		a, b     int
+	}{
+		// whole hours
+		{0, 2 * time.Hour, 120, 180},
+		// the minutes around an hour
+		{30 * time.Minute, 90 * time.Minute, 60, 90},
+		// up to the minute in progress
+		{2 * time.Hour, 4 * time.Hour, 61, 0},
+		// partial minutes are left out
+		{30 * time.Second, 150 * time.Second, 1, 3},
+	}
+	for _, c := range cases {
+		snap, err := r.Query(start.Add(c.from), start.Add(c.to), 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if a, b := snap.Estimate("a").Count, snap.Estimate("b").Count; a != c.a || b != c.b {
+			t.Errorf("%v to %v: expected a %d and b %d, got %d and %d", c.from, c.to, c.a, c.b, a, b)
+		}
+		if len(snap.Keys) > 1 || snap.ErrorBound != 0 {
+			t.Errorf("%v to %v: expected the top 1 without errors, got %v and bound %d", c.from, c.to, snap.Keys, snap.ErrorBound)
+		}
+	}
+	if _, err := r.Query(start, start, 1); err == nil {
+		t.Error("expected an error for an empty range")
+	}
+}
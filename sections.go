@@ -0,0 +1,160 @@
+package topk
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/tinylib/msgp/msgp"
+)
+
+// The stream can be encoded as two sections: the elements, small and
+// changing with every insert, and the alphas, large and changing slowly.
+const (
+	elementsMagic = "topk/elements"
+	alphasMagic   = "topk/alphas"
+)
+
+// EncodeElements writes the elements section of the stream to w: the tracked
+// elements and the counters, but not the alphas
+func (s *Stream) EncodeElements(w io.Writer) error {
+	s.Consolidate()
+
+	wrt := msgp.NewWriter(w)
+	if err := encodeHeader(wrt, elementsMagic); err != nil {
+		return err
+	}
+	if err := wrt.WriteInt(s.n); err != nil {
+		return err
+	}
+	if err := wrt.WriteInt(len(s.alphas)); err != nil {
+		return err
+	}
+	if err := s.k.EncodeMsgp(wrt); err != nil {
+		return err
+	}
+	if err := s.encodeFields(wrt); err != nil {
+		return err
+	}
+	return wrt.Flush()
+}
+
+// EncodeAlphas writes the alphas section of the stream to w
+func (s *Stream) EncodeAlphas(w io.Writer) error {
+	wrt := msgp.NewWriter(w)
+	if err := encodeHeader(wrt, alphasMagic); err != nil {
+		return err
+	}
+	if err := wrt.WriteInt(s.n); err != nil {
+		return err
+	}
+	if err := wrt.WriteArrayHeader(uint32(len(s.alphas))); err != nil {
+		return err
+	}
+	for _, a := range s.alphas {
+		if err := wrt.WriteInt(a); err != nil {
+			return err
+		}
+	}
+	return wrt.Flush()
+}
+
+// DecodeSection replaces the section of s read from r, written by either
+// EncodeElements or EncodeAlphas, and keeps the other one. s is left
+// unchanged on errors.
+//
+// A stream mirroring another one from its sections can be merged like the
+// original. An elements section is only decoded into a stream with as many
+// alphas as it records: a stream created by New with the size of the
+// original, whose alphas are zero until the first alphas section is decoded,
+// which underestimates the counts of the keys it doesn't track when merged,
+// or a stream that already decoded an alphas section.
+func (s *Stream) DecodeSection(r io.Reader) error {
+	rdr := msgp.NewReader(r)
+	magic, err := readString(rdr)
+	if err != nil {
+		return err
+	}
+	if magic != elementsMagic && magic != alphasMagic {
+		return fmt.Errorf("%w %q", ErrUnexpectedMagic, magic)
+	}
+	version, err := rdr.ReadInt()
+	if err != nil {
+		return err
+	}
+	if err := checkVersion(version); err != nil {
+		return err
+	}
+
+	n, err := rdr.ReadInt()
+	if err != nil {
+		return err
+	}
+	if s.n != 0 && n != s.n {
+		return incompatible("expected stream of size n %d, got %d", s.n, n)
+	}
+
+	if magic == alphasMagic {
+		sz, err := rdr.ReadArrayHeader()
+		if err != nil {
+			return err
+		}
+		hint, err := decodeLen(int(sz))
+		if err != nil {
+			return err
+		}
+		alphas := make([]int, 0, hint)
+		for i := 0; i < int(sz); i++ {
+			a, err := rdr.ReadInt()
+			if err != nil {
+				return err
+			}
+			alphas = append(alphas, a)
+		}
+		// the tracked elements were hashed into as many alphas as s has
+		if len(s.alphas) != 0 && len(alphas) != len(s.alphas) {
+			return incompatible("expected %d alphas, got %d", len(s.alphas), len(alphas))
+		}
+		if len(alphas) < n {
+			return incompatible("expected at least n %d alphas, got %d", n, len(alphas))
+		}
+		s.own()
+		s.n = n
+		s.alphas = alphas
+		s.resetTier()
+		return nil
+	}
+
+	sz, err := rdr.ReadInt()
+	if err != nil {
+		return err
+	}
+	if _, err := decodeLen(sz); err != nil {
+		return err
+	}
+	// the alphas are only allocated as they are read from an alphas section
+	if sz != len(s.alphas) {
+		return incompatible("expected %d alphas, got %d", len(s.alphas), sz)
+	}
+	// decode into a copy, which only replaces s once the section is verified
+	d := *s
+	d.own()
+	d.n = n
+	d.k = keys{score: s.k.score, stable: s.k.stable}
+	if err := d.k.decode(context.Background(), rdr); err != nil {
+		return err
+	}
+	if d.k.score != nil || d.k.stable {
+		d.k.init()
+	}
+	if err := d.checkDecoded(); err != nil {
+		return err
+	}
+	d.internKeys()
+	d.resetDecoded()
+	if err := d.decodeFields(rdr); err != nil {
+		return err
+	}
+	*s = d
+	return nil
+}
@@ -0,0 +1,113 @@
+package topk
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestSections(t *testing.T) {
+	words := loadWords()
+	agent, other := New(100), New(100)
+	for i, w := range words {
+		if i%2 == 0 {
+			agent.Insert(w, 1)
+		} else {
+			other.Insert(w, 1)
+		}
+	}
+
+	elements, alphas := bytes.NewBuffer(nil), bytes.NewBuffer(nil)
+	if err := agent.EncodeElements(elements); err != nil {
+		t.Fatal(err)
+	}
+	if err := agent.EncodeAlphas(alphas); err != nil {
+		t.Fatal(err)
+	}
+	alphasSection := alphas.Bytes()
+
+	// the size of the alphas isn't taken from an elements section
+	elementsSection := elements.Bytes()
+	if err := (&Stream{}).DecodeSection(bytes.NewReader(elementsSection)); !errors.Is(err, ErrIncompatibleSketch) {
+		t.Errorf("expected ErrIncompatibleSketch, got %v", err)
+	}
+	fromAlphas := &Stream{}
+	if err := fromAlphas.DecodeSection(bytes.NewReader(alphasSection)); err != nil {
+		t.Fatal(err)
+	}
+	if err := fromAlphas.DecodeSection(bytes.NewReader(elementsSection)); err != nil {
+		t.Fatal(err)
+	}
+	if !fromAlphas.Equal(agent) {
+		t.Error("stream decoded from the alphas first differs from the agent")
+	}
+
+	mirror := New(100)
+	if err := mirror.DecodeSection(bytes.NewReader(elementsSection)); err != nil {
+		t.Fatal(err)
+	}
+	if len(mirror.alphas) != len(agent.alphas) {
+		t.Fatalf("expected %d zero alphas, got %d", len(agent.alphas), len(mirror.alphas))
+	}
+	// the elements alone can already be merged
+	partial := other.clone()
+	if err := partial.Merge(mirror); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := mirror.DecodeSection(bytes.NewReader(alphasSection)); err != nil {
+		t.Fatal(err)
+	}
+	if !mirror.Equal(agent) {
+		t.Error("mirror differs from the agent")
+	}
+
+	want, got := other.clone(), other.clone()
+	if err := want.Merge(agent); err != nil {
+		t.Fatal(err)
+	}
+	if err := got.Merge(mirror); err != nil {
+		t.Fatal(err)
+	}
+	if !got.Equal(want) {
+		t.Error("merge of the mirror differs")
+	}
+
+	if err := New(10).DecodeSection(bytes.NewReader(alphasSection)); !errors.Is(err, ErrIncompatibleSketch) {
+		t.Errorf("expected ErrIncompatibleSketch, got %v", err)
+	}
+
+	// an alphas section of another length than the elements were hashed into
+	short := New(100)
+	short.alphas = short.alphas[:len(short.alphas)-1]
+	alphas.Reset()
+	if err := short.EncodeAlphas(alphas); err != nil {
+		t.Fatal(err)
+	}
+	if err := mirror.DecodeSection(bytes.NewReader(alphas.Bytes())); !errors.Is(err, ErrIncompatibleSketch) {
+		t.Errorf("expected ErrIncompatibleSketch, got %v", err)
+	}
+	if !mirror.Equal(agent) {
+		t.Error("the rejected section changed the mirror")
+	}
+	// and one with fewer alphas than elements can be tracked
+	short.alphas = short.alphas[:99]
+	alphas.Reset()
+	if err := short.EncodeAlphas(alphas); err != nil {
+		t.Fatal(err)
+	}
+	if err := (&Stream{}).DecodeSection(bytes.NewReader(alphas.Bytes())); !errors.Is(err, ErrIncompatibleSketch) {
+		t.Errorf("expected ErrIncompatibleSketch, got %v", err)
+	}
+
+	// a truncated elements section leaves the stream as it was
+	total := mirror.Total()
+	for _, i := range []int{len(elementsSection) / 2, len(elementsSection) - 1} {
+		if err := mirror.DecodeSection(bytes.NewReader(elementsSection[:i])); err == nil {
+			t.Errorf("truncation at %d of %d decoded", i, len(elementsSection))
+		}
+		if !mirror.Equal(agent) || mirror.Total() != total {
+			t.Errorf("truncation at %d changed the mirror", i)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package topk
+
+// Intersect returns the elements tracked by both s and other, e.g. the keys
+// hot in two regions. The count of an element is the smaller of its two
+// counts, the error widens it down to the smaller of its two lower bounds,
+// so the true smaller count lies within the error.
+func (s *Stream) Intersect(other *Stream) []Element {
+	var elts []Element
+	for _, e1 := range s.k.elts {
+		idx, ok := other.k.m[e1.Key]
+		if !ok {
+			continue
+		}
+		e2 := other.k.elts[idx]
+		count, lower := e1.Count, e1.LowerBound()
+		if e2.Count < count {
+			count = e2.Count
+		}
+		if lb := e2.LowerBound(); lb < lower {
+			lower = lb
+		}
+		elts = append(elts, Element{Key: e1.Key, Count: count, Error: count - lower})
+	}
+	SortElements(elts)
+	return elts
+}
+
+// Subtract returns the elements of s with the contribution of other removed,
+// e.g. the traffic unique to a window or cluster. The count of an element is
+// its count in s less its lower bound in other, the error widens it down to
+// its lower bound in s less its count in other, both clamped at zero. Keys
+// not tracked by other are estimated by its alphas.
+func (s *Stream) Subtract(other *Stream) []Element {
+	elts := make([]Element, 0, len(s.k.elts))
+	for _, e1 := range s.k.elts {
+		e2 := other.estimate(e1.Key)
+		count := e1.Count - e2.LowerBound()
+		lower := e1.LowerBound() - e2.Count
+		if count < 0 {
+			count = 0
+		}
+		if lower < 0 {
+			lower = 0
+		}
+		elts = append(elts, Element{Key: e1.Key, Count: count, Error: count - lower})
+	}
+	SortElements(elts)
+	return elts
+}
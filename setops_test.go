@@ -0,0 +1,49 @@
+package topk
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestIntersect(t *testing.T) {
+	a, b := New(5), New(5)
+	a.Insert("both", 10)
+	a.Insert("small", 2)
+	a.Insert("a-only", 7)
+	b.Insert("both", 4)
+	b.Insert("small", 6)
+	b.Insert("b-only", 9)
+	a.k.elts[a.k.m["both"]].Error = 1
+	b.k.elts[b.k.m["small"]].Error = 5
+
+	want := []Element{
+		{Key: "both", Count: 4, Error: 0},
+		{Key: "small", Count: 2, Error: 1},
+	}
+	if got := a.Intersect(b); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+	if got := a.Intersect(New(5)); len(got) != 0 {
+		t.Errorf("expected no common keys, got %v", got)
+	}
+}
+
+func TestSubtract(t *testing.T) {
+	a, b := New(5), New(5)
+	a.Insert("x", 10)
+	a.Insert("y", 3)
+	a.Insert("z", 4)
+	b.Insert("x", 4)
+	b.Insert("y", 5)
+	b.k.elts[b.k.m["x"]].Error = 1
+
+	want := []Element{
+		// 10 less between 3 and 4
+		{Key: "x", Count: 7, Error: 1},
+		{Key: "z", Count: 4, Error: 0},
+		{Key: "y", Count: 0, Error: 0},
+	}
+	if got := a.Subtract(b); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+}
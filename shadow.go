@@ -0,0 +1,83 @@
+package topk
+
+import "math"
+
+// ShadowReport compares the estimates of the keys sampled by WithShadow to
+// their exact counts
+type ShadowReport struct {
+	// Keys is the number of sampled keys inserted so far, Tracked the
+	// number of them currently tracked
+	Keys    int
+	Tracked int
+	// MeanError and MaxError are the mean and maximum difference between
+	// estimate and exact count
+	MeanError float64
+	MaxError  int
+	// MeanRelativeError is the mean of the difference relative to the exact
+	// count
+	MeanRelativeError float64
+	// Underestimated is the number of keys estimated below their exact
+	// count, which the algorithm should never do
+	Underestimated int
+}
+
+// shadow keeps exact counts of a sample of the keys
+type shadow struct {
+	limit  uint64 // keys with a hash sample below limit are counted
+	exact  map[string]int
+	every  int
+	since  int
+	report func(ShadowReport)
+}
+
+// count adds count to x if it is sampled
+func (sh *shadow) count(x string, h uint64, count int) {
+	// the low and high 32 bits are taken, sample on bits from both
+	if (h>>16)&math.MaxUint32 < sh.limit {
+		sh.exact[x] += count
+	}
+}
+
+// tick reports after every sh.every inserts
+func (sh *shadow) tick(s *Stream) {
+	if sh.every <= 0 || sh.report == nil {
+		return
+	}
+	if sh.since++; sh.since >= sh.every {
+		sh.since = 0
+		sh.report(s.ShadowReport())
+	}
+}
+
+// ShadowReport compares the current estimates of the keys sampled by
+// WithShadow to their exact counts, it is zero without WithShadow
+func (s *Stream) ShadowReport() ShadowReport {
+	var r ShadowReport
+	if s.shadow == nil {
+		return r
+	}
+	var sum, rel float64
+	for x, exact := range s.shadow.exact {
+		r.Keys++
+		if _, ok := s.k.m[x]; ok {
+			r.Tracked++
+		}
+		diff := s.estimate(x).Count - exact
+		if diff < 0 {
+			r.Underestimated++
+			diff = -diff
+		}
+		if diff > r.MaxError {
+			r.MaxError = diff
+		}
+		sum += float64(diff)
+		if exact != 0 {
+			rel += float64(diff) / math.Abs(float64(exact))
+		}
+	}
+	if r.Keys > 0 {
+		r.MeanError = sum / float64(r.Keys)
+		r.MeanRelativeError = rel / float64(r.Keys)
+	}
+	return r
+}
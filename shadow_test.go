@@ -0,0 +1,45 @@
+package topk
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestShadow(t *testing.T) {
+	var reports []ShadowReport
+	s := New(10, WithShadow(1, 1000, func(r ShadowReport) {
+		reports = append(reports, r)
+	}))
+	for i := 0; i < 5000; i++ {
+		s.Insert(fmt.Sprintf("key-%d", i%50), 1)
+	}
+
+	if len(reports) != 5 {
+		t.Fatalf("expected 5 reports, got %d", len(reports))
+	}
+	r := s.ShadowReport()
+	if last := reports[4]; r.MaxError != last.MaxError || r.MeanError != last.MeanError {
+		t.Errorf("expected the last report %+v, got %+v", reports[4], r)
+	}
+	if r.Keys != 50 || r.Tracked != 10 {
+		t.Errorf("expected 50 sampled keys with 10 tracked, got %+v", r)
+	}
+	if r.Underestimated != 0 {
+		t.Errorf("expected no underestimates, got %d", r.Underestimated)
+	}
+	if r.MaxError == 0 || r.MeanError == 0 || r.MeanRelativeError == 0 {
+		t.Errorf("expected errors with 50 keys in 10 slots, got %+v", r)
+	}
+
+	// a sketch large enough is exact
+	s = New(100, WithShadow(0.5, 0, nil))
+	for i := 0; i < 5000; i++ {
+		s.Insert(fmt.Sprintf("key-%d", i%50), 1)
+	}
+	if r := s.ShadowReport(); r.Keys == 0 || r.Keys == 50 || r.MaxError != 0 {
+		t.Errorf("expected about half the keys to be sampled without errors, got %+v", r)
+	}
+	if r := New(10).ShadowReport(); r != (ShadowReport{}) {
+		t.Errorf("expected an empty report without WithShadow, got %+v", r)
+	}
+}
@@ -0,0 +1,205 @@
+package topk
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/dgryski/go-metro"
+)
+
+// Sharded calculates the TopK elements for a stream that is written to from
+// many goroutines at once.
+//
+// Keys are partitioned across shards by hash, so every key is only ever
+// tracked by a single shard and each shard is guarded by its own mutex.
+// Concurrent writers only contend when they happen to hit the same shard,
+// there is no global lock on the insert path. Since the shards track
+// disjoint key sets, combining them on query is a plain union and doesn't add
+// any error on top of that of the individual shards.
+type Sharded struct {
+	n      int
+	shards []shard
+
+	advance sync.Mutex
+	epoch   atomic.Pointer[Epoch]
+}
+
+type shard struct {
+	mu    sync.Mutex
+	s     *Stream
+	dirty bool     // modified since the last epoch
+	_     [40]byte // keep shards on separate cache lines
+}
+
+// NewSharded returns a Sharded estimating the top n most frequent elements
+// using the given number of shards. Each shard is able to hold n elements so
+// a skewed key distribution can't push heavy hitters out of the result.
+//
+// The options apply to every shard. State passed to them, like Hooks or an
+// Interner, is used by the shards concurrently.
+func NewSharded(n, shards int, opts ...Option) *Sharded {
+	if shards < 1 {
+		shards = 1
+	}
+	sh := &Sharded{
+		n:      n,
+		shards: make([]shard, shards),
+	}
+	ep := &Epoch{n: n, shards: make([]*View, shards)}
+	for i := range sh.shards {
+		sh.shards[i].s = New(n, opts...)
+		ep.shards[i] = sh.shards[i].s.Freeze()
+	}
+	sh.epoch.Store(ep)
+	return sh
+}
+
+// shardOf returns the shard index tracking x
+func shardOf(x string, shards int) uint32 {
+	// the low 32 bits of the hash select the alpha slot, use the high ones
+	return reduce(metro.Hash64Str(x, 0)>>32, shards)
+}
+
+func (sh *Sharded) shardFor(x string) *shard {
+	return &sh.shards[shardOf(x, len(sh.shards))]
+}
+
+// Insert adds an element to the stream to be tracked, it is safe to call from
+// multiple goroutines.
+// It returns an estimation for the just inserted element
+func (sh *Sharded) Insert(x string, count int) Element {
+	shd := sh.shardFor(x)
+	shd.mu.Lock()
+	e := shd.s.Insert(x, count)
+	shd.dirty = true
+	shd.mu.Unlock()
+	return e
+}
+
+// Estimate returns an estimate for the item x
+func (sh *Sharded) Estimate(x string) Element {
+	shd := sh.shardFor(x)
+	shd.mu.Lock()
+	e := shd.s.Estimate(x)
+	shd.mu.Unlock()
+	return e
+}
+
+// Keys returns the current estimates for the most frequent elements across
+// all shards, ordered and reported like Stream.Keys
+func (sh *Sharded) Keys() []Element {
+	// the shards are locked together for a consistent result, Epoch
+	// queries don't block writers
+	for i := range sh.shards {
+		sh.shards[i].mu.Lock()
+	}
+	defer func() {
+		for i := range sh.shards {
+			sh.shards[i].mu.Unlock()
+		}
+	}()
+
+	elts := make([]Element, 0, sh.n)
+	for i := range sh.shards {
+		elts = append(elts, sh.shards[i].s.k.elts...)
+	}
+	return sh.shards[0].s.unionKeys(elts, sh.n)
+}
+
+// unionKeys returns the n highest ranked of elts, the elements of shards
+// tracking disjoint keys with the options of s, ranked and reported like the
+// Keys of s
+func (s *Stream) unionKeys(elts []Element, n int) []Element {
+	s.k.sort(elts)
+	if len(elts) > n {
+		elts = elts[:n]
+	}
+	s.reportAll(elts)
+	return elts
+}
+
+// Len returns the number of elements tracked across all shards
+func (sh *Sharded) Len() int {
+	n := 0
+	for i := range sh.shards {
+		shd := &sh.shards[i]
+		shd.mu.Lock()
+		n += shd.s.Len()
+		shd.mu.Unlock()
+	}
+	return n
+}
+
+// Cap returns the number of elements all shards track at most
+func (sh *Sharded) Cap() int {
+	return sh.n * len(sh.shards)
+}
+
+// FillRatio returns the fraction of the capacity of all shards in use
+func (sh *Sharded) FillRatio() float64 {
+	if sh.Cap() == 0 {
+		return 0
+	}
+	return float64(sh.Len()) / float64(sh.Cap())
+}
+
+// Epoch is an immutable view of a Sharded as of a call to Advance. It can be
+// queried from any number of goroutines without synchronization.
+type Epoch struct {
+	seq    uint64
+	n      int
+	shards []*View
+}
+
+// Epoch returns the most recently published epoch. Readers never wait on
+// writers, they see the state as of the last call to Advance.
+func (sh *Sharded) Epoch() *Epoch {
+	return sh.epoch.Load()
+}
+
+// Advance publishes a new epoch reflecting all inserts so far and returns it.
+// Shards are frozen rather than copied, a shard only copies its state on the
+// first insert after it has been published.
+func (sh *Sharded) Advance() *Epoch {
+	sh.advance.Lock()
+	defer sh.advance.Unlock()
+
+	prev := sh.epoch.Load()
+	next := &Epoch{
+		seq:    prev.seq + 1,
+		n:      sh.n,
+		shards: make([]*View, len(sh.shards)),
+	}
+	for i := range sh.shards {
+		shd := &sh.shards[i]
+		shd.mu.Lock()
+		if shd.dirty {
+			next.shards[i] = shd.s.Freeze()
+			shd.dirty = false
+		} else {
+			next.shards[i] = prev.shards[i]
+		}
+		shd.mu.Unlock()
+	}
+	sh.epoch.Store(next)
+	return next
+}
+
+// Seq returns the sequence number of the epoch, it increases with every
+// call to Advance
+func (ep *Epoch) Seq() uint64 { return ep.seq }
+
+// Estimate returns an estimate for the item x
+func (ep *Epoch) Estimate(x string) Element {
+	return ep.shards[shardOf(x, len(ep.shards))].Estimate(x)
+}
+
+// Keys returns the estimates for the most frequent elements in the epoch,
+// ordered and reported like Stream.Keys
+func (ep *Epoch) Keys() []Element {
+	elts := make([]Element, 0, ep.n)
+	for _, v := range ep.shards {
+		elts = append(elts, v.s.k.elts...)
+	}
+	return ep.shards[0].s.unionKeys(elts, ep.n)
+}
@@ -0,0 +1,91 @@
+package topk
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestSharded(t *testing.T) {
+	key := func(i int) string {
+		if i%5 == 0 {
+			return fmt.Sprintf("a-much-longer-key-%d", i)
+		}
+		return fmt.Sprintf("key-%d", i)
+	}
+	for name, opts := range map[string][]Option{
+		"count": nil,
+		"score": {WithScore(func(e Element) float64 { return float64(e.Count * len(e.Key)) })},
+		"long":  {WithMaxKeyLength(8, PrefixHashLongKeys)},
+	} {
+		// the serial stream and every shard hold all keys, both are exact
+		sh := NewSharded(100, 8, opts...)
+		serial := New(100, opts...)
+
+		var wg sync.WaitGroup
+		for g := 0; g < 8; g++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := 0; i < 60; i++ {
+					sh.Insert(key(i), 1+i%7)
+				}
+			}()
+			for i := 0; i < 60; i++ {
+				serial.Insert(key(i), 1+i%7)
+			}
+		}
+		wg.Wait()
+
+		if got, want := sh.Keys(), serial.Keys(); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: keys differ from a serial stream:\n%v\n%v", name, got, want)
+		}
+		if got, want := sh.Advance().Keys(), serial.Keys(); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: epoch keys differ from a serial stream:\n%v\n%v", name, got, want)
+		}
+		for i := 0; i < 61; i++ {
+			if got, want := sh.Estimate(key(i)), serial.Estimate(key(i)); got != want {
+				t.Errorf("%s: estimate of %s differs: %v != %v", name, key(i), got, want)
+			}
+		}
+	}
+}
+
+func TestShardedEpoch(t *testing.T) {
+	sh := NewSharded(10, 4)
+
+	ep := sh.Epoch()
+	if len(ep.Keys()) != 0 {
+		t.Fatalf("expected an empty initial epoch")
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 10000; i++ {
+			sh.Insert(fmt.Sprintf("word-%d", i%20), 1)
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		// readers must see an immutable view while inserts continue
+		keys := sh.Advance().Keys()
+		for j := 0; j < 3; j++ {
+			if got := sh.Epoch().Keys(); len(got) != len(keys) {
+				t.Fatalf("epoch changed under reader: %v != %v", got, keys)
+			}
+		}
+	}
+	<-done
+
+	ep = sh.Advance()
+	if e := ep.Estimate("word-3"); e.Count != 500 {
+		t.Errorf("expected count 500, got %v", e)
+	}
+	if ep.Seq() != 101 {
+		t.Errorf("expected seq 101, got %d", ep.Seq())
+	}
+	if next := sh.Advance(); next.shards[0] != ep.shards[0] {
+		t.Errorf("unchanged shard was copied")
+	}
+}
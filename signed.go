@@ -0,0 +1,59 @@
+package topk
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"io"
+)
+
+// ErrInvalidSignature is returned when a signed sketch was not signed with the
+// given key or has been tampered with
+var ErrInvalidSignature = errors.New("topk: invalid sketch signature")
+
+// EncodeSigned encodes the stream like Encode followed by an HMAC-SHA256 of
+// the encoding under key, so sketches shipped over untrusted links can be
+// authenticated by the receiver
+func (s *Stream) EncodeSigned(w io.Writer, key []byte) error {
+	buf := bytes.NewBuffer(nil)
+	if err := s.Encode(buf); err != nil {
+		return err
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write(buf.Bytes())
+	buf.Write(mac.Sum(nil))
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// DecodeSigned decodes a sketch written by EncodeSigned, it returns
+// ErrInvalidSignature without touching s if the signature doesn't verify
+func (s *Stream) DecodeSigned(r io.Reader, key []byte) error {
+	payload, err := VerifySigned(r, key)
+	if err != nil {
+		return err
+	}
+	return s.Decode(payload)
+}
+
+// VerifySigned reads a sketch written by EncodeSigned and checks its
+// signature. It returns a reader over the verified encoding, e.g. to pass on
+// to MergeEncodedAll.
+func VerifySigned(r io.Reader, key []byte) (io.Reader, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < sha256.Size {
+		return nil, ErrInvalidSignature
+	}
+	payload, sig := data[:len(data)-sha256.Size], data[len(data)-sha256.Size:]
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write(payload)
+	if !hmac.Equal(sig, mac.Sum(nil)) {
+		return nil, ErrInvalidSignature
+	}
+	return bytes.NewReader(payload), nil
+}
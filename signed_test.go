@@ -0,0 +1,54 @@
+package topk
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestSigned(t *testing.T) {
+	key := []byte("secret")
+
+	s := New(10)
+	s.Insert("a", 3)
+	s.Insert("b", 1)
+
+	buf := bytes.NewBuffer(nil)
+	if err := s.EncodeSigned(buf, key); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	got := New(10)
+	if err := got.DecodeSigned(bytes.NewReader(data), key); err != nil {
+		t.Fatal(err)
+	}
+	if !got.Equal(s) {
+		t.Errorf("decoded stream differs: %v != %v", got, s)
+	}
+
+	if err := New(10).DecodeSigned(bytes.NewReader(data), []byte("other")); err != ErrInvalidSignature {
+		t.Errorf("expected ErrInvalidSignature for wrong key, got %v", err)
+	}
+
+	forged := append([]byte(nil), data...)
+	forged[len(forged)/2] ^= 1
+	if err := New(10).DecodeSigned(bytes.NewReader(forged), key); err != ErrInvalidSignature {
+		t.Errorf("expected ErrInvalidSignature for forged payload, got %v", err)
+	}
+	if _, err := VerifySigned(bytes.NewReader(data[:10]), key); err != ErrInvalidSignature {
+		t.Errorf("expected ErrInvalidSignature for truncated payload, got %v", err)
+	}
+
+	r, err := VerifySigned(bytes.NewReader(data), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	merged, err := MergeEncodedAll([]io.Reader{r})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e := merged.Estimate("a"); e.Count != 3 {
+		t.Errorf("expected count 3 for a, got %v", e)
+	}
+}
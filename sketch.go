@@ -0,0 +1,59 @@
+package topk
+
+import "io"
+
+// Inserter adds elements to a sketch
+type Inserter interface {
+	// Insert adds count occurrences of x and returns its estimate
+	Insert(x string, count int) Element
+}
+
+// Querier answers queries about the frequent elements
+type Querier interface {
+	// Keys returns the estimates of the frequent elements ordered by
+	// descending count
+	Keys() []Element
+	// Estimate returns an estimate for the item x
+	Estimate(x string) Element
+}
+
+// Merger combines sketches
+type Merger interface {
+	// MergeSketch adds the counts of other to the sketch, which has to be
+	// of the same engine
+	MergeSketch(other Sketch) error
+}
+
+// TopK describes the behavior of Stream, so applications can mock it or wrap
+// it with middleware without depending on the concrete type
+type TopK interface {
+	Inserter
+	Querier
+	Merger
+}
+
+// Sketch is implemented by the engines estimating the frequent elements of a
+// stream, so applications can switch algorithms by configuration. MergeSketch
+// only accepts sketches of the same engine.
+type Sketch interface {
+	TopK
+	// Encode writes the sketch to w
+	Encode(w io.Writer) error
+	// Decode replaces the sketch with the one read from r
+	Decode(r io.Reader) error
+}
+
+var (
+	_ TopK     = (*Stream)(nil)
+	_ Querier  = (*View)(nil)
+	_ Querier  = (*Epoch)(nil)
+	_ Querier  = (*BytesView)(nil)
+	_ Inserter = (*Sharded)(nil)
+	_ Querier  = (*Sharded)(nil)
+
+	_ Sketch = (*Stream)(nil)
+	_ Sketch = (*StickySampling)(nil)
+	_ Sketch = (*LossyCounting)(nil)
+	_ Sketch = (*MisraGries)(nil)
+	_ Sketch = (*CountMinHeap)(nil)
+)
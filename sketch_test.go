@@ -0,0 +1,113 @@
+package topk
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestSketches(t *testing.T) {
+	engines := map[string]func() Sketch{
+		"fss":    func() Sketch { return New(20) },
+		"sticky": func() Sketch { return newSticky(t, 0.05, 0.005, 0.01) },
+		"lossy":  func() Sketch { return newLossy(t, 0.005) },
+		"mg":     func() Sketch { return NewMisraGries(100) },
+		"cms":    func() Sketch { return newCountMin(t, 20, 1000, 4) },
+	}
+	for name, newSketch := range engines {
+		a, b := newSketch(), newSketch()
+		for i := 0; i < 20000; i++ {
+			for _, s := range []Sketch{a, b} {
+				switch {
+				case i%4 == 0:
+					s.Insert("a", 1)
+				case i%8 == 1:
+					s.Insert("b", 1)
+				default:
+					s.Insert(fmt.Sprintf("noise-%d", i), 1)
+				}
+			}
+		}
+		if err := a.MergeSketch(b); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		buf := bytes.NewBuffer(nil)
+		if err := a.Encode(buf); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		s := newSketch()
+		if err := s.Decode(buf); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		keys := s.Keys()
+		if len(keys) < 2 || keys[0].Key != "a" || keys[1].Key != "b" {
+			t.Errorf("%s: expected a and b on top, got %v", name, keys[:2])
+		}
+		for key, exact := range map[string]int{"a": 10000, "b": 5000} {
+			e := s.Estimate(key)
+			if name != "sticky" && (e.LowerBound() > exact || e.UpperBound() < exact) {
+				t.Errorf("%s: expected %d for %s within the bounds of %v", name, exact, key, e)
+			}
+			if e.Error > exact/10 {
+				t.Errorf("%s: expected an error below 10%% for %s, got %v", name, key, e)
+			}
+		}
+
+		for other := range engines {
+			if other != name && s.MergeSketch(engines[other]()) == nil {
+				t.Errorf("expected merging %s into %s to fail", other, name)
+			}
+		}
+		if err := s.MergeSketch(nil); !errors.Is(err, ErrNilSketch) {
+			t.Errorf("%s: expected ErrNilSketch, got %v", name, err)
+		}
+	}
+}
+
+func TestMisraGries(t *testing.T) {
+	s := NewMisraGries(2)
+	s.Insert("a", 5)
+	s.Insert("b", 3)
+	// decrements a and b by 2, c doesn't make it
+	s.Insert("c", 2)
+	// decrements a and b by 1 and tracks d with 3
+	s.Insert("d", 4)
+
+	want := map[string]Element{
+		"a": {Key: "a", Count: 5, Error: 3},
+		"d": {Key: "d", Count: 6, Error: 3},
+	}
+	keys := s.Keys()
+	if len(keys) != 2 || keys[0] != want["d"] || keys[1] != want["a"] {
+		t.Errorf("expected %v, got %v", want, keys)
+	}
+	if e := s.Estimate("b"); e.Count != 3 {
+		t.Errorf("expected b to be estimated at most 3, got %v", e)
+	}
+}
+
+// countingTopK is a middleware counting the inserts into a TopK
+type countingTopK struct {
+	TopK
+	inserts int
+}
+
+func (c *countingTopK) Insert(x string, count int) Element {
+	c.inserts++
+	return c.TopK.Insert(x, count)
+}
+
+func TestTopKMiddleware(t *testing.T) {
+	var tk TopK = New(10)
+	c := &countingTopK{TopK: tk}
+	tk = c
+
+	tk.Insert("a", 2)
+	tk.Insert("b", 1)
+	if c.inserts != 2 || tk.Estimate("a").Count != 2 || len(tk.Keys()) != 2 {
+		t.Errorf("unexpected state after 2 inserts: %d %v", c.inserts, tk.Keys())
+	}
+}
@@ -0,0 +1,331 @@
+package topk
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrSnapshotNotFound is returned by a SnapshotStore for missing snapshots
+var ErrSnapshotNotFound = errors.New("topk: snapshot not found")
+
+// SnapshotInfo describes a stored snapshot
+type SnapshotInfo struct {
+	Name string
+	Time time.Time
+	Size int64
+}
+
+// SnapshotStore persists encoded streams by name and time. Implementations
+// for object storage only need to map them to object keys, DirStore stores
+// them on the local filesystem.
+type SnapshotStore interface {
+	// Put stores the snapshot of name at t read from r
+	Put(ctx context.Context, name string, t time.Time, r io.Reader) error
+	// Get returns the snapshot of name at t or ErrSnapshotNotFound
+	Get(ctx context.Context, name string, t time.Time) (io.ReadCloser, error)
+	// List returns the snapshots of name ordered by time
+	List(ctx context.Context, name string) ([]SnapshotInfo, error)
+}
+
+// DirStore is a SnapshotStore keeping each snapshot in a file named by its
+// time in a directory per name
+type DirStore struct {
+	dir string
+}
+
+var _ SnapshotStore = (*DirStore)(nil)
+
+// NewDirStore returns a DirStore storing snapshots below dir
+func NewDirStore(dir string) *DirStore {
+	return &DirStore{dir: dir}
+}
+
+const snapshotExt = ".topk"
+
+func (d *DirStore) path(name string, t time.Time) (string, error) {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return "", fmt.Errorf("topk: invalid snapshot name %q", name)
+	}
+	return filepath.Join(d.dir, name, strconv.FormatInt(t.UnixNano(), 10)+snapshotExt), nil
+}
+
+// Put stores the snapshot, it only becomes visible once completely written
+func (d *DirStore) Put(ctx context.Context, name string, t time.Time, r io.Reader) error {
+	path, err := d.path(name, t)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), ".put-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// Get opens the snapshot
+func (d *DirStore) Get(ctx context.Context, name string, t time.Time) (io.ReadCloser, error) {
+	path, err := d.path(name, t)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrSnapshotNotFound
+	}
+	return f, err
+}
+
+// List returns the snapshots of name
+func (d *DirStore) List(ctx context.Context, name string) ([]SnapshotInfo, error) {
+	path, err := d.path(name, time.Time{})
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var infos []SnapshotInfo
+	for _, e := range entries {
+		ns, err := strconv.ParseInt(strings.TrimSuffix(e.Name(), snapshotExt), 10, 64)
+		if err != nil || !strings.HasSuffix(e.Name(), snapshotExt) {
+			// temporary files and foreign files
+			continue
+		}
+		fi, err := e.Info()
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, SnapshotInfo{Name: name, Time: time.Unix(0, ns), Size: fi.Size()})
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Time.Before(infos[j].Time) })
+	return infos, nil
+}
+
+// LoadLatest decodes the most recent snapshot of name from store
+func LoadLatest(ctx context.Context, store SnapshotStore, name string) (*Stream, error) {
+	infos, err := store.List(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if len(infos) == 0 {
+		return nil, ErrSnapshotNotFound
+	}
+	rc, err := store.Get(ctx, name, infos[len(infos)-1].Time)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	s := &Stream{}
+	if err := s.DecodeContext(ctx, rc); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Snapshotter periodically writes views of a stream to a SnapshotStore
+type Snapshotter struct {
+	store    SnapshotStore
+	name     string
+	view     func() *View
+	interval time.Duration
+	clock    Clock
+	onError  func(error)
+}
+
+// SnapshotOption configures a Snapshotter
+type SnapshotOption func(*Snapshotter)
+
+// WithSnapshotClock sets the clock timestamping the snapshots, the default
+// is SystemClock
+func WithSnapshotClock(c Clock) SnapshotOption {
+	return func(sn *Snapshotter) {
+		sn.clock = c
+	}
+}
+
+// WithSnapshotErrors sets a function called with the errors of failed
+// snapshots in Run, by default they are ignored and retried at the next
+// interval
+func WithSnapshotErrors(fn func(error)) SnapshotOption {
+	return func(sn *Snapshotter) {
+		sn.onError = fn
+	}
+}
+
+// NewSnapshotter returns a Snapshotter storing the views returned by view
+// as name every interval, for example Ingester.View.
+func NewSnapshotter(store SnapshotStore, name string, view func() *View, interval time.Duration, opts ...SnapshotOption) *Snapshotter {
+	sn := &Snapshotter{
+		store:    store,
+		name:     name,
+		view:     view,
+		interval: interval,
+		clock:    SystemClock{},
+	}
+	for _, opt := range opts {
+		opt(sn)
+	}
+	return sn
+}
+
+// Snapshot stores the current view and returns its time
+func (sn *Snapshotter) Snapshot(ctx context.Context) (time.Time, error) {
+	buf := bytes.NewBuffer(nil)
+	if err := sn.view().Encode(buf); err != nil {
+		return time.Time{}, err
+	}
+	t := sn.clock.Now()
+	return t, sn.store.Put(ctx, sn.name, t, buf)
+}
+
+// Run takes a snapshot every interval until ctx is cancelled, and a last
+// one before returning
+func (sn *Snapshotter) Run(ctx context.Context) error {
+	ticker := time.NewTicker(sn.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			// the final snapshot must not be cancelled with the loop
+			_, err := sn.Snapshot(context.WithoutCancel(ctx))
+			return err
+		case <-ticker.C:
+			if _, err := sn.Snapshot(ctx); err != nil && sn.onError != nil {
+				sn.onError(err)
+			}
+		}
+	}
+}
+
+// Snapshot is the state of a stream's results at a point in time, a stable
+// unit to serialize and pass between services
+type Snapshot struct {
+	Keys  []Element `json:"keys"`
+	Total int       `json:"total"`
+	// Distinct is the estimated number of distinct keys, it is only set for
+	// streams maintaining it, see WithDistinct
+	Distinct *uint64 `json:"distinct,omitempty"`
+	// ErrorBound is the most an estimate of the snapshot overestimates the
+	// count of a key: the largest error of a tracked element or alpha
+	ErrorBound int            `json:"error_bound"`
+	Time       time.Time      `json:"time"`
+	Config     SnapshotConfig `json:"config"`
+
+	view  *View          // the frozen stream, nil for decoded snapshots
+	ranks map[string]int // the positions of the keys in Keys
+}
+
+// SnapshotConfig is the configuration of the stream a Snapshot was taken of
+type SnapshotConfig struct {
+	N      int    `json:"n"`
+	Alphas int    `json:"alphas"`
+	Hash   string `json:"hash"`
+}
+
+// Snapshot returns the stream's results timestamped by its clock, see
+// WithClock. The snapshot can be queried while the stream keeps changing.
+func (s *Stream) Snapshot() Snapshot {
+	v := s.Freeze()
+	snap := Snapshot{
+		Keys:  v.Keys(),
+		Total: s.total,
+		Time:  s.now(),
+		Config: SnapshotConfig{
+			N:      s.n,
+			Alphas: len(s.alphas),
+			Hash:   hashName,
+		},
+	}
+	if n, ok := s.Distinct(); ok {
+		snap.Distinct = &n
+	}
+	for _, a := range s.alphas {
+		snap.ErrorBound = max(snap.ErrorBound, a)
+	}
+	for _, e := range s.k.elts {
+		snap.ErrorBound = max(snap.ErrorBound, s.report(e).Error)
+	}
+	snap.view = v
+	snap.ranks = make(map[string]int, len(snap.Keys))
+	for i, e := range snap.Keys {
+		snap.ranks[e.Key] = i
+	}
+	return snap
+}
+
+// Estimate returns an estimate for the item x as of the snapshot. Snapshots
+// that were not returned by Stream.Snapshot, for example decoded from JSON,
+// only know the counts of their Keys and estimate all others as zero.
+func (sn Snapshot) Estimate(x string) Element {
+	if sn.view != nil {
+		return sn.view.Estimate(x)
+	}
+	if i, ok := sn.rank(x); ok {
+		return sn.Keys[i]
+	}
+	return Element{Key: x}
+}
+
+// Rank returns the position of x in Keys starting at 1 for the most frequent
+// element, ok is false if x is not among Keys
+func (sn Snapshot) Rank(x string) (rank int, ok bool) {
+	i, ok := sn.rank(x)
+	if !ok {
+		return 0, false
+	}
+	return i + 1, true
+}
+
+func (sn Snapshot) rank(x string) (int, bool) {
+	if sn.ranks != nil {
+		i, ok := sn.ranks[x]
+		return i, ok
+	}
+	for i, e := range sn.Keys {
+		if e.Key == x {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// TopN returns the n most frequent elements of the snapshot
+func (sn Snapshot) TopN(n int) []Element {
+	if n > len(sn.Keys) {
+		n = len(sn.Keys)
+	}
+	if n < 0 {
+		n = 0
+	}
+	return append([]Element(nil), sn.Keys[:n]...)
+}
@@ -0,0 +1,139 @@
+package topk
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDirStore(t *testing.T) {
+	ctx := context.Background()
+	store := NewDirStore(t.TempDir())
+	clock := NewFakeClock(time.Unix(1000, 0))
+
+	s := New(10)
+	sn := NewSnapshotter(store, "requests", s.Freeze, time.Minute, WithSnapshotClock(clock))
+	for i := 0; i < 3; i++ {
+		s.Insert("a", 1)
+		if _, err := sn.Snapshot(ctx); err != nil {
+			t.Fatal(err)
+		}
+		clock.Advance(time.Minute)
+	}
+
+	infos, err := store.List(ctx, "requests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != 3 || !infos[0].Time.Equal(time.Unix(1000, 0)) || infos[2].Size == 0 {
+		t.Fatalf("unexpected snapshots %v", infos)
+	}
+
+	latest, err := LoadLatest(ctx, store, "requests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e := latest.Estimate("a"); e.Count != 3 {
+		t.Errorf("expected the latest count 3, got %v", e)
+	}
+
+	if _, err := store.Get(ctx, "requests", time.Unix(0, 0)); err != ErrSnapshotNotFound {
+		t.Errorf("expected ErrSnapshotNotFound, got %v", err)
+	}
+	if _, err := LoadLatest(ctx, store, "other"); err != ErrSnapshotNotFound {
+		t.Errorf("expected ErrSnapshotNotFound, got %v", err)
+	}
+	if _, err := store.List(ctx, "../x"); err == nil {
+		t.Error("expected an error for an invalid name")
+	}
+}
+
+func TestSnapshotterRun(t *testing.T) {
+	store := NewDirStore(t.TempDir())
+	s := New(10)
+	s.Insert("a", 1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := NewSnapshotter(store, "s", s.Freeze, time.Hour).Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if infos, err := store.List(context.Background(), "s"); err != nil || len(infos) != 1 {
+		t.Errorf("expected a final snapshot, got %v %v", infos, err)
+	}
+}
+
+func TestStreamSnapshot(t *testing.T) {
+	clock := NewFakeClock(time.Unix(1000, 0))
+	s := New(10, WithClock(clock), WithDistinct())
+	s.Insert("a", 3)
+	s.Insert("b", 1)
+
+	snap := s.Snapshot()
+	if !reflect.DeepEqual(snap.Keys, s.Keys()) || snap.Total != 4 {
+		t.Errorf("unexpected results %v, total %d", snap.Keys, snap.Total)
+	}
+	if snap.Distinct == nil || *snap.Distinct != 2 {
+		t.Errorf("expected 2 distinct keys, got %v", snap.Distinct)
+	}
+	if !snap.Time.Equal(time.Unix(1000, 0)) {
+		t.Errorf("unexpected time %v", snap.Time)
+	}
+	if want := (SnapshotConfig{N: 10, Alphas: 60, Hash: "metro"}); snap.Config != want {
+		t.Errorf("expected config %+v, got %+v", want, snap.Config)
+	}
+	if New(10).Snapshot().Distinct != nil {
+		t.Error("expected no distinct estimate")
+	}
+}
+
+func TestSnapshotQueries(t *testing.T) {
+	s := New(2)
+	s.Insert("a", 3)
+	s.Insert("b", 2)
+	s.Insert("c", 1)
+	snap := s.Snapshot()
+	want := s.Estimate("d")
+
+	// the live stream keeps changing
+	s.Insert("d", 10)
+
+	if e := snap.Estimate("a"); e.Count != 3 {
+		t.Errorf("expected count 3, got %v", e)
+	}
+	if e := snap.Estimate("d"); e != want {
+		t.Errorf("expected the estimate %v as of the snapshot, got %v", want, e)
+	}
+	if rank, ok := snap.Rank("a"); !ok || rank != 1 {
+		t.Errorf("expected rank 1, got %d %v", rank, ok)
+	}
+	if _, ok := snap.Rank("d"); ok {
+		t.Error("expected d to be unranked")
+	}
+	if top := snap.TopN(1); len(top) != 1 || top[0].Key != "a" {
+		t.Errorf("unexpected top %v", top)
+	}
+	if top := snap.TopN(10); len(top) != 2 {
+		t.Errorf("expected all 2 keys, got %v", top)
+	}
+
+	// decoded snapshots only know their keys
+	decoded := Snapshot{Keys: snap.Keys}
+	if rank, ok := decoded.Rank(snap.Keys[1].Key); !ok || rank != 2 {
+		t.Errorf("expected rank 2, got %d %v", rank, ok)
+	}
+	if e := decoded.Estimate("d"); e.Count != 0 {
+		t.Errorf("expected a zero estimate, got %v", e)
+	}
+}
+
+func TestSnapshotErrorBound(t *testing.T) {
+	s := New(1)
+	s.Insert("a", 5)
+	// b evicts a, whose count stays in its alpha
+	s.Insert("b", 10)
+	if snap := s.Snapshot(); snap.ErrorBound != 5 {
+		t.Errorf("expected an error bound of 5, got %d", snap.ErrorBound)
+	}
+}
@@ -0,0 +1,318 @@
+package topk
+
+import (
+	"math"
+	"math/bits"
+	"sort"
+	"time"
+	"unsafe"
+)
+
+// Stats are counters describing the operation of a stream
+type Stats struct {
+	// LongKeys is the number of inserted keys exceeding the maximum key
+	// length, see WithMaxKeyLength
+	LongKeys int
+	// FilteredKeys is the number of inserts of keys matching the filter,
+	// see WithFilter
+	FilteredKeys int
+	// SkippedInserts is the number of inserts not included in the sample,
+	// see WithSampling
+	SkippedInserts int
+	// HeldInserts is the number of first occurrences held back by the
+	// doorkeeper, see WithDoorkeeper
+	HeldInserts int
+	// Evictions is the number of tracked elements replaced by new keys, it
+	// is persisted and summed up by merges
+	Evictions int
+	// SampleRate is the fraction of inserts processed, 1 without sampling
+	SampleRate float64
+
+	// The timings are only collected with WithTimings, since it was applied
+	// or ResetTimings was last called.
+
+	// InsertRate is the number of inserts per second, not counting the
+	// inserts merged from other streams
+	InsertRate float64
+	// HeapFixes is the number of heap updates restoring the order of the
+	// elements after inserts, HeapFixTime the time spent in them
+	HeapFixes   int
+	HeapFixTime time.Duration
+	// Merges is the number of streams merged into the stream, MergeTime the
+	// time spent merging them
+	Merges    int
+	MergeTime time.Duration
+}
+
+// timings are the counters of WithTimings
+type timings struct {
+	on      bool
+	since   time.Time
+	inserts int
+
+	fixes   int
+	fixTime time.Duration
+
+	merges    int
+	mergeTime time.Duration
+}
+
+// WithTimings makes Stats report the insert rate and the time spent in heap
+// updates and merges, to attribute CPU in production without profiling. It
+// costs two clock reads per heap update. The insert rate is measured by the
+// stream's clock, see WithClock.
+func WithTimings() Option {
+	return func(s *Stream) {
+		s.timings = timings{on: true, since: s.now()}
+	}
+}
+
+// ResetTimings starts over the timings of WithTimings, calling it after
+// every scrape of Stats reports them per scrape interval
+func (s *Stream) ResetTimings() {
+	if s.timings.on {
+		s.timings = timings{on: true, since: s.now()}
+	}
+}
+
+// fix restores the heap order after the element at i changed
+func (s *Stream) fix(i int) {
+	if !s.timings.on {
+		s.k.fix(i)
+		return
+	}
+	start := time.Now()
+	s.k.fix(i)
+	s.timings.fixed(start)
+}
+
+func (t *timings) fixed(start time.Time) {
+	t.fixes++
+	t.fixTime += time.Since(start)
+}
+
+func (t *timings) merged(start time.Time) {
+	t.merges++
+	t.mergeTime += time.Since(start)
+}
+
+// Stats returns the stream's counters
+func (s *Stream) Stats() Stats {
+	st := s.stats
+	st.SampleRate = 1
+	if s.rng != nil {
+		st.SampleRate = s.sampleRate
+	}
+	if t := s.timings; t.on {
+		if elapsed := s.now().Sub(t.since); elapsed > 0 {
+			st.InsertRate = float64(t.inserts) / elapsed.Seconds()
+		}
+		st.HeapFixes, st.HeapFixTime = t.fixes, t.fixTime
+		st.Merges, st.MergeTime = t.merges, t.mergeTime
+	}
+	return st
+}
+
+// MemoryUsage returns an estimate of the bytes held by the stream: the
+// alphas, the elements and their keys, and the index of the keys
+func (s *Stream) MemoryUsage() int {
+	// a map entry is the key's string header, the index and the overhead
+	// of the buckets
+	const mapEntry = 48
+
+	size := int(unsafe.Sizeof(*s)) + cap(s.alphas)*int(unsafe.Sizeof(0))
+	size += s.k.memoryUsage(mapEntry)
+	if s.tier != nil {
+		size += s.tier.k.memoryUsage(mapEntry)
+	}
+	if s.distinct != nil {
+		size += len(s.distinct.regs)
+	}
+	return size
+}
+
+func (tk *keys) memoryUsage(mapEntry int) int {
+	size := cap(tk.elts)*int(unsafe.Sizeof(Element{})) + len(tk.m)*mapEntry
+	for _, e := range tk.elts {
+		size += len(e.Key)
+	}
+	return size
+}
+
+// ErrorStats summarize the overestimation of the tracked elements
+type ErrorStats struct {
+	// Tracked is the number of tracked elements
+	Tracked int
+	// Exact is the number of tracked elements with an error of 0
+	Exact int
+	// MeanError and MaxError are the mean and maximum error of the tracked
+	// elements
+	MeanError float64
+	MaxError  int
+	// MeanRelativeError is the mean of Error/Count of the tracked elements
+	MeanRelativeError float64
+	// P50Error, P90Error and P99Error are quantiles of the errors
+	P50Error, P90Error, P99Error int
+	// MaxAlpha is the largest alpha, the most a key is overestimated when
+	// it is admitted or estimated without being tracked
+	MaxAlpha int
+	// Histogram counts the tracked elements by error: bucket 0 holds the
+	// exact ones, bucket i > 0 the ones with an error in [2^(i-1), 2^i)
+	Histogram []int
+}
+
+// ErrorStats returns a summary of the errors of the currently tracked
+// elements
+func (s *Stream) ErrorStats() ErrorStats {
+	st := ErrorStats{Tracked: len(s.k.elts)}
+	for _, a := range s.alphas {
+		if a > st.MaxAlpha {
+			st.MaxAlpha = a
+		}
+	}
+	if st.Tracked == 0 {
+		return st
+	}
+
+	errs := make([]int, 0, st.Tracked)
+	var sum, rel float64
+	for _, e := range s.k.elts {
+		errs = append(errs, e.Error)
+		sum += float64(e.Error)
+		if e.Count > 0 {
+			rel += float64(e.Error) / float64(e.Count)
+		}
+
+		st.Histogram = addLog2(st.Histogram, e.Error)
+	}
+	sort.Ints(errs)
+
+	st.Exact = st.Histogram[0]
+	st.MeanError = sum / float64(st.Tracked)
+	st.MeanRelativeError = rel / float64(st.Tracked)
+	st.MaxError = errs[len(errs)-1]
+	quantile := func(q float64) int {
+		return errs[int(q*float64(len(errs)-1))]
+	}
+	st.P50Error, st.P90Error, st.P99Error = quantile(0.5), quantile(0.9), quantile(0.99)
+	return st
+}
+
+// CountHistogram counts the tracked elements by count, in the buckets of
+// ErrorStats.Histogram: bucket 0 holds the elements with a count of 0,
+// bucket i > 0 the ones with a count in [2^(i-1), 2^i). It shows the skew of
+// the head of the distribution without exporting the keys.
+func (s *Stream) CountHistogram() []int {
+	var h []int
+	for _, e := range s.k.elts {
+		h = addLog2(h, e.Count)
+	}
+	return h
+}
+
+// CountQuantile returns the q-quantile of the counts of the tracked
+// elements, for q in [0, 1], e.g. 0.95 for a threshold above all but the
+// largest 5% of the current heavy hitters. The counts are the estimates of
+// the elements, it returns 0 if no element is tracked or q is NaN.
+func (s *Stream) CountQuantile(q float64) int {
+	if len(s.k.elts) == 0 || math.IsNaN(q) {
+		return 0
+	}
+	counts := make([]int, len(s.k.elts))
+	for i, e := range s.k.elts {
+		counts[i] = e.Count
+	}
+	sort.Ints(counts)
+	q = math.Min(math.Max(q, 0), 1)
+	return counts[int(q*float64(len(counts)-1))]
+}
+
+// addLog2 increments the log2 bucket of v in h, growing h as needed
+func addLog2(h []int, v int) []int {
+	b := bits.Len(uint(v))
+	if v < 0 {
+		b = 0
+	}
+	for len(h) <= b {
+		h = append(h, 0)
+	}
+	h[b]++
+	return h
+}
+
+// PartitionStats describe a partition of the tracked elements: a Stream
+// is made of a single heap and its alphas, a Sharded of one per shard
+type PartitionStats struct {
+	// Tracked is the number of tracked elements, Capacity the number of
+	// elements the partition can track
+	Tracked, Capacity int
+	// MinCount is the count of the smallest tracked element, a new key has
+	// to reach it to be admitted once the partition is full
+	MinCount int
+	// Evictions is the number of tracked elements replaced by new keys
+	Evictions int
+	// Alphas is the number of alpha slots, UsedAlphas the number of them
+	// with a count
+	Alphas, UsedAlphas int
+}
+
+// PartitionStats returns the statistics of the stream's single partition
+func (s *Stream) PartitionStats() []PartitionStats {
+	return []PartitionStats{s.partitionStats()}
+}
+
+func (s *Stream) partitionStats() PartitionStats {
+	st := PartitionStats{
+		Tracked:   len(s.k.elts),
+		Capacity:  s.n,
+		Evictions: s.stats.Evictions,
+		Alphas:    len(s.alphas),
+	}
+	for i, e := range s.k.elts {
+		if i == 0 || e.Count < st.MinCount {
+			st.MinCount = e.Count
+		}
+	}
+	for _, a := range s.alphas {
+		if a != 0 {
+			st.UsedAlphas++
+		}
+	}
+	return st
+}
+
+// PartitionStats returns the statistics of each shard
+func (sh *Sharded) PartitionStats() []PartitionStats {
+	stats := make([]PartitionStats, len(sh.shards))
+	for i := range sh.shards {
+		shd := &sh.shards[i]
+		shd.mu.Lock()
+		stats[i] = shd.s.partitionStats()
+		shd.mu.Unlock()
+	}
+	return stats
+}
+
+// PartitionHeap returns a copy of the elements of partition i in heap order:
+// the smallest element, the next to be evicted, comes first and the children
+// of element j are at 2j+1 and 2j+2. A Stream only has partition 0, i out of
+// range returns nil. The order is only restored lazily with WithDeferredHeap,
+// see Consolidate.
+func (s *Stream) PartitionHeap(i int) []Element {
+	if i != 0 {
+		return nil
+	}
+	return append([]Element(nil), s.k.elts...)
+}
+
+// PartitionHeap returns a copy of the elements of shard i in heap order, see
+// Stream.PartitionHeap
+func (sh *Sharded) PartitionHeap(i int) []Element {
+	if i < 0 || i >= len(sh.shards) {
+		return nil
+	}
+	shd := &sh.shards[i]
+	shd.mu.Lock()
+	defer shd.mu.Unlock()
+	return shd.s.PartitionHeap(0)
+}
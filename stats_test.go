@@ -0,0 +1,185 @@
+package topk
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestErrorStats(t *testing.T) {
+	s := New(4)
+	if st := s.ErrorStats(); st.Tracked != 0 || st.Histogram != nil {
+		t.Errorf("expected empty stats, got %+v", st)
+	}
+
+	s.k.elts = []Element{
+		{Key: "a", Count: 10, Error: 0},
+		{Key: "b", Count: 10, Error: 1},
+		{Key: "c", Count: 10, Error: 3},
+		{Key: "d", Count: 20, Error: 8},
+	}
+	s.alphas[7] = 9
+
+	want := ErrorStats{
+		Tracked:           4,
+		Exact:             1,
+		MeanError:         3,
+		MaxError:          8,
+		MeanRelativeError: (0 + 0.1 + 0.3 + 0.4) / 4,
+		P50Error:          1,
+		P90Error:          3,
+		P99Error:          3,
+		MaxAlpha:          9,
+		Histogram:         []int{1, 1, 1, 0, 1},
+	}
+	if st := s.ErrorStats(); !reflect.DeepEqual(st, want) {
+		t.Errorf("expected %+v, got %+v", want, st)
+	}
+}
+
+func TestCountHistogram(t *testing.T) {
+	s := New(10)
+	for i, count := range []int{1, 2, 3, 4, 7, 8, 100} {
+		s.Insert(fmt.Sprint(i), count)
+	}
+	want := []int{0, 1, 2, 2, 1, 0, 0, 1}
+	if h := s.CountHistogram(); !reflect.DeepEqual(h, want) {
+		t.Errorf("expected %v, got %v", want, h)
+	}
+	if h := New(10).CountHistogram(); h != nil {
+		t.Errorf("expected no buckets, got %v", h)
+	}
+}
+
+func TestCountQuantile(t *testing.T) {
+	s := New(200)
+	if q := s.CountQuantile(0.5); q != 0 {
+		t.Errorf("expected 0 without elements, got %d", q)
+	}
+	for i := 1; i <= 101; i++ {
+		s.Insert(fmt.Sprint(i), i)
+	}
+	cases := []struct {
+		q    float64
+		want int
+	}{{0, 1}, {0.5, 51}, {0.95, 96}, {1, 101}, {-1, 1}, {2, 101}, {math.NaN(), 0}}
+	for _, c := range cases {
+		if got := s.CountQuantile(c.q); got != c.want {
+			t.Errorf("quantile %v: expected %d, got %d", c.q, c.want, got)
+		}
+	}
+}
+
+func TestPartitionStats(t *testing.T) {
+	s := New(2)
+	s.Insert("a", 5)
+	s.Insert("b", 3)
+	// each count exceeds all previous ones, so this evicts b
+	s.Insert("c", 10)
+
+	st := s.PartitionStats()
+	if len(st) != 1 {
+		t.Fatalf("expected a single partition, got %v", st)
+	}
+	want := PartitionStats{Tracked: 2, Capacity: 2, MinCount: 5, Evictions: 1, Alphas: 12, UsedAlphas: 1}
+	if st[0] != want {
+		t.Errorf("expected %+v, got %+v", want, st[0])
+	}
+	if s.Stats().Evictions != 1 {
+		t.Errorf("expected 1 eviction, got %+v", s.Stats())
+	}
+
+	sh := NewSharded(10, 4)
+	for i := 0; i < 100; i++ {
+		sh.Insert(fmt.Sprintf("key-%d", i), 1)
+	}
+	var tracked int
+	for _, p := range sh.PartitionStats() {
+		tracked += p.Tracked
+		if p.Capacity != 10 {
+			t.Errorf("expected capacity 10, got %+v", p)
+		}
+	}
+	if tracked != 40 {
+		t.Errorf("expected all shards to be full, got %d tracked", tracked)
+	}
+}
+
+func TestPartitionHeap(t *testing.T) {
+	s := New(10)
+	for i := 0; i < 100; i++ {
+		s.Insert(fmt.Sprint(i%30), i)
+	}
+	heap := s.PartitionHeap(0)
+	if len(heap) != 10 {
+		t.Fatalf("expected 10 elements, got %v", heap)
+	}
+	for j := 1; j < len(heap); j++ {
+		if parent := heap[(j-1)/2]; parent.Count > heap[j].Count {
+			t.Errorf("element %d %v is below its parent %v", j, heap[j], parent)
+		}
+	}
+	heap[0].Count = -1
+	if s.PartitionHeap(0)[0].Count == -1 || s.PartitionHeap(1) != nil {
+		t.Error("expected a copy of the only partition")
+	}
+
+	sh := NewSharded(10, 4)
+	for i := 0; i < 100; i++ {
+		sh.Insert(fmt.Sprintf("key-%d", i), 1)
+	}
+	var tracked int
+	for i := 0; i < 4; i++ {
+		tracked += len(sh.PartitionHeap(i))
+	}
+	if tracked != 40 || sh.PartitionHeap(4) != nil {
+		t.Errorf("expected 40 elements over 4 shards, got %d", tracked)
+	}
+}
+
+func TestTimings(t *testing.T) {
+	if st := New(10).Stats(); st.HeapFixes != 0 || st.InsertRate != 0 {
+		t.Errorf("expected no timings by default, got %+v", st)
+	}
+
+	s := New(10, WithTimings())
+	for i := 0; i < 1000; i++ {
+		s.Insert(fmt.Sprint(i%20), 1)
+	}
+	other := New(10)
+	other.Insert("a", 5000)
+	if err := s.Merge(other); err != nil {
+		t.Fatal(err)
+	}
+
+	st := s.Stats()
+	if st.InsertRate <= 0 || st.HeapFixes == 0 || st.HeapFixTime <= 0 {
+		t.Errorf("expected insert and heap timings, got %+v", st)
+	}
+	if st.Merges != 1 || st.MergeTime <= 0 {
+		t.Errorf("expected one timed merge, got %+v", st)
+	}
+
+	s.ResetTimings()
+	if st := s.Stats(); st.HeapFixes != 0 || st.Merges != 0 || st.Evictions == 0 {
+		t.Errorf("expected only the timings to be reset, got %+v", st)
+	}
+	// the insert rate is measured by the stream's clock, in either order of
+	// the options
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, opts := range [][]Option{
+		{WithTimings(), WithClock(NewFakeClock(start))},
+		{WithClock(NewFakeClock(start)), WithTimings()},
+	} {
+		s := New(10, opts...)
+		for i := 0; i < 500; i++ {
+			s.Insert("a", 1)
+		}
+		s.clock.(*FakeClock).Advance(10 * time.Second)
+		if st := s.Stats(); st.InsertRate != 50 {
+			t.Errorf("expected 50 inserts per second, got %v", st.InsertRate)
+		}
+	}
+}
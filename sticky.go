@@ -0,0 +1,248 @@
+package topk
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/tinylib/msgp/msgp"
+)
+
+// StickySampling estimates the elements occurring in more than a support
+// fraction of a stream with the Sticky Sampling algorithm of Manku and
+// Motwani, as an alternative to the Filtered Space-Saving of Stream.
+//
+// New keys are only tracked with a probability that halves every time the
+// stream doubles in length, tracked keys are counted exactly. The counts of
+// keys with a frequency above support are underestimated by at most epsilon
+// times the stream length, with a probability of at least 1-delta.
+type StickySampling struct {
+	support, epsilon float64
+
+	t      int // 1/epsilon * ln(1/(support*delta))
+	rate   int // new keys are tracked with probability 1/rate
+	next   int // stream length at which the rate doubles
+	total  int
+	counts map[string]int
+	rng    *rand.Rand
+}
+
+// NewStickySampling returns a StickySampling for the given support in
+// (0, 1), error epsilon in (0, support) and failure probability delta in
+// (0, 1)
+func NewStickySampling(support, epsilon, delta float64) (*StickySampling, error) {
+	t, err := stickyT(support, epsilon, delta)
+	if err != nil {
+		return nil, err
+	}
+	return &StickySampling{
+		support: support,
+		epsilon: epsilon,
+		t:       t,
+		rate:    1,
+		next:    2 * t,
+		counts:  make(map[string]int),
+		rng:     rand.New(rand.NewSource(time.Now().UnixNano())),
+	}, nil
+}
+
+// stickyT returns the sampling parameter t for the given support, epsilon
+// and delta
+func stickyT(support, epsilon, delta float64) (int, error) {
+	// NaN fails the range checks too
+	if !(support > 0 && support < 1) {
+		return 0, fmt.Errorf("topk: support %v out of range (0, 1)", support)
+	}
+	if !(epsilon > 0 && epsilon < support) {
+		return 0, fmt.Errorf("topk: epsilon %v out of range (0, %v)", epsilon, support)
+	}
+	if !(delta > 0 && delta < 1) {
+		return 0, fmt.Errorf("topk: delta %v out of range (0, 1)", delta)
+	}
+	t := math.Ceil(math.Log(1/(support*delta)) / epsilon)
+	// the rate doubles at multiples of 2t
+	if t >= math.MaxInt/2 {
+		return 0, fmt.Errorf("topk: epsilon %v too small", epsilon)
+	}
+	return int(t), nil
+}
+
+// Insert adds count occurrences of x to the stream.
+// It returns an estimation for the just inserted element
+func (s *StickySampling) Insert(x string, count int) Element {
+	for count > 0 {
+		step := count
+		if s.total+step > s.next {
+			// process the occurrences up to the next rate change
+			step = s.next - s.total
+		}
+		s.total += step
+		count -= step
+
+		if c, ok := s.counts[x]; ok {
+			s.counts[x] = c + step
+		} else {
+			// the first sampled occurrence starts tracking x
+			for i := 0; i < step; i++ {
+				if s.rate == 1 || s.rng.Intn(s.rate) == 0 {
+					s.counts[x] = step - i
+					break
+				}
+			}
+		}
+
+		if s.total == s.next {
+			s.resample()
+		}
+	}
+	return s.Estimate(x)
+}
+
+// resample doubles the rate and adjusts the counts as if the keys had been
+// sampled at the new rate all along
+func (s *StickySampling) resample() {
+	s.next += s.rate * 2 * s.t
+	s.rate *= 2
+	for x, c := range s.counts {
+		// diminish c by one for every unsuccessful toss of an unbiased coin
+		for c > 0 && s.rng.Intn(2) == 0 {
+			c--
+		}
+		if c == 0 {
+			delete(s.counts, x)
+		} else {
+			s.counts[x] = c
+		}
+	}
+}
+
+// slack returns epsilon * N, the maximum undercount of a key
+func (s *StickySampling) slack() int {
+	return int(math.Ceil(s.epsilon * float64(s.total)))
+}
+
+// Estimate returns an estimate for the item x, its count is an upper bound
+// with high probability
+func (s *StickySampling) Estimate(x string) Element {
+	slack := s.slack()
+	return Element{Key: x, Count: s.counts[x] + slack, Error: slack}
+}
+
+// Keys returns the elements with an estimated frequency of at least
+// support-epsilon, ordered like Stream.Keys
+func (s *StickySampling) Keys() []Element {
+	slack := s.slack()
+	min := (s.support - s.epsilon) * float64(s.total)
+	var elts []Element
+	for x, c := range s.counts {
+		if float64(c) >= min {
+			elts = append(elts, Element{Key: x, Count: c + slack, Error: slack})
+		}
+	}
+	SortElements(elts)
+	return elts
+}
+
+// Total returns the sum of the counts of all inserted elements
+func (s *StickySampling) Total() int {
+	return s.total
+}
+
+const stickyMagic = "topk/sticky"
+
+// MergeSketch merges other, which has to be a *StickySampling, into s like Merge
+func (s *StickySampling) MergeSketch(other Sketch) error {
+	o, ok := other.(*StickySampling)
+	if !ok {
+		if other == nil {
+			return ErrNilSketch
+		}
+		return incompatible("cannot merge %T into %T", other, s)
+	}
+	return s.Merge(o)
+}
+
+// Merge adds other to s by summing up the counts. The merged summary samples
+// new keys at the lower rate of both, which is only an approximation: keys
+// tracked by just one of the summaries are undercounted by the occurrences
+// the other one didn't sample.
+func (s *StickySampling) Merge(other *StickySampling) error {
+	if other == nil {
+		return ErrNilSketch
+	}
+	if s.t != other.t || s.support != other.support {
+		return incompatible("expected sticky sampling with support %v and t %d, got %v and %d", s.support, s.t, other.support, other.t)
+	}
+
+	for x, c := range other.counts {
+		s.counts[x] += c
+	}
+	s.total += other.total
+	for s.rate < other.rate {
+		s.resample()
+	}
+	for s.total >= s.next {
+		s.resample()
+	}
+	return nil
+}
+
+// Encode writes the summary to w
+func (s *StickySampling) Encode(w io.Writer) error {
+	wrt := msgp.NewWriter(w)
+	if err := encodeHeader(wrt, stickyMagic); err != nil {
+		return err
+	}
+	for _, f := range []float64{s.support, s.epsilon} {
+		if err := wrt.WriteFloat64(f); err != nil {
+			return err
+		}
+	}
+	for _, v := range []int{s.t, s.rate, s.next, s.total} {
+		if err := wrt.WriteInt(v); err != nil {
+			return err
+		}
+	}
+	if err := encodeCounts(wrt, s.counts); err != nil {
+		return err
+	}
+	return wrt.Flush()
+}
+
+// Decode replaces s with the summary read from r. s is left unchanged on
+// errors.
+func (s *StickySampling) Decode(r io.Reader) error {
+	rdr := msgp.NewReader(r)
+	if err := expectHeader(rdr, stickyMagic); err != nil {
+		return err
+	}
+	// the decoded summary keeps the random source of s
+	d := *s
+	var err error
+	for _, f := range []*float64{&d.support, &d.epsilon} {
+		if *f, err = rdr.ReadFloat64(); err != nil {
+			return err
+		}
+	}
+	for _, v := range []*int{&d.t, &d.rate, &d.next, &d.total} {
+		if *v, err = rdr.ReadInt(); err != nil {
+			return err
+		}
+	}
+	if !(d.support > 0 && d.support <= 1) || !(d.epsilon > 0 && d.epsilon <= 1) {
+		return fmt.Errorf("topk: corrupt encoding: support %v or epsilon %v out of range", d.support, d.epsilon)
+	}
+	if d.t < 1 || d.rate < 1 || d.total < 0 || d.next < d.total {
+		return fmt.Errorf("topk: corrupt encoding: sampling t %d, rate %d and next %d out of range for total %d", d.t, d.rate, d.next, d.total)
+	}
+	if d.counts, err = decodeCounts(rdr); err != nil {
+		return err
+	}
+	if d.rng == nil {
+		d.rng = rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
+	*s = d
+	return nil
+}
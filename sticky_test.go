@@ -0,0 +1,78 @@
+package topk
+
+import (
+	"fmt"
+	"math"
+	"testing"
+)
+
+// newSticky returns a StickySampling with the valid support, epsilon and
+// delta
+func newSticky(t testing.TB, support, epsilon, delta float64) *StickySampling {
+	t.Helper()
+	s, err := NewStickySampling(support, epsilon, delta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestStickySampling(t *testing.T) {
+	s := newSticky(t, 0.05, 0.005, 0.01)
+
+	// a, b and c make up 20%, 10% and 6% of the stream, the rest is noise
+	for i := 0; i < 100000; i++ {
+		switch {
+		case i%5 == 0:
+			s.Insert("a", 1)
+		case i%10 == 1:
+			s.Insert("b", 1)
+		case i%50 >= 2 && i%50 <= 4:
+			s.Insert("c", 1)
+		default:
+			s.Insert(fmt.Sprintf("noise-%d", i), 1)
+		}
+	}
+	if s.Total() != 100000 {
+		t.Fatalf("expected total 100000, got %d", s.Total())
+	}
+
+	keys := s.Keys()
+	if len(keys) != 3 || keys[0].Key != "a" || keys[1].Key != "b" || keys[2].Key != "c" {
+		t.Fatalf("expected a, b and c, got %v", keys)
+	}
+	for _, tc := range []struct {
+		key   string
+		exact int
+	}{{"a", 20000}, {"b", 10000}, {"c", 6000}} {
+		e := s.Estimate(tc.key)
+		if e.LowerBound() > tc.exact || e.UpperBound() < tc.exact {
+			t.Errorf("expected %d for %s within the bounds of %v", tc.exact, tc.key, e)
+		}
+	}
+
+	// weighted inserts cross rate changes
+	w := newSticky(t, 0.1, 0.05, 0.1)
+	w.Insert("a", 10000)
+	if e := w.Estimate("a"); e.LowerBound() > 10000 || e.UpperBound() < 10000 {
+		t.Errorf("expected 10000 within the bounds of %v", e)
+	}
+}
+
+func TestStickySamplingParameters(t *testing.T) {
+	for _, p := range [][3]float64{
+		{0, 0.01, 0.1},
+		{1, 0.01, 0.1},
+		{math.NaN(), 0.01, 0.1},
+		{0.1, 0, 0.1},
+		{0.1, 0.1, 0.1},
+		{0.1, -0.01, 0.1},
+		{0.1, 0.01, 0},
+		{0.1, 0.01, 1},
+		{0.1, 1e-300, 0.1},
+	} {
+		if _, err := NewStickySampling(p[0], p[1], p[2]); err == nil {
+			t.Errorf("expected an error for support %v, epsilon %v and delta %v", p[0], p[1], p[2])
+		}
+	}
+}
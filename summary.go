@@ -0,0 +1,132 @@
+package topk
+
+import (
+	"io"
+
+	"github.com/tinylib/msgp/msgp"
+)
+
+const summaryMagic = "topk/summary"
+
+// Summary is the result of a stream without any of the state needed to
+// update or merge it
+type Summary struct {
+	// Total is the sum of all counts inserted into the stream
+	Total int
+	// Elements are the most frequent elements as returned by Keys
+	Elements []Element
+}
+
+// EncodeSummary writes only the total and the result of Keys to w, which
+// is a fraction of the size of Encode for consumers that only display
+// results.
+func (s *Stream) EncodeSummary(w io.Writer) error {
+	wrt := msgp.NewWriter(w)
+	if err := encodeHeader(wrt, summaryMagic); err != nil {
+		return err
+	}
+	if err := wrt.WriteInt(s.total); err != nil {
+		return err
+	}
+	keys := s.Keys()
+	if err := wrt.WriteArrayHeader(uint32(len(keys))); err != nil {
+		return err
+	}
+	for _, e := range keys {
+		if err := wrt.WriteString(e.Key); err != nil {
+			return err
+		}
+		if err := wrt.WriteInt(e.Count); err != nil {
+			return err
+		}
+		if err := wrt.WriteInt(e.Error); err != nil {
+			return err
+		}
+	}
+	return wrt.Flush()
+}
+
+// DecodeSummary reads a summary written by EncodeSummary
+func DecodeSummary(r io.Reader) (*Summary, error) {
+	rdr := msgp.NewReader(r)
+	if err := expectHeader(rdr, summaryMagic); err != nil {
+		return nil, err
+	}
+
+	var (
+		sum Summary
+		err error
+	)
+	if sum.Total, err = rdr.ReadInt(); err != nil {
+		return nil, err
+	}
+	sz, err := rdr.ReadArrayHeader()
+	if err != nil {
+		return nil, err
+	}
+	hint, err := decodeLen(int(sz))
+	if err != nil {
+		return nil, err
+	}
+	sum.Elements = make([]Element, 0, hint)
+	for i := 0; i < int(sz); i++ {
+		var e Element
+		if e.Key, err = readString(rdr); err != nil {
+			return nil, err
+		}
+		if e.Count, err = rdr.ReadInt(); err != nil {
+			return nil, err
+		}
+		if e.Error, err = rdr.ReadInt(); err != nil {
+			return nil, err
+		}
+		sum.Elements = append(sum.Elements, e)
+	}
+	return &sum, nil
+}
+
+// FromElements returns a stream tracking elts, for example the stored Keys of
+// an older job, so that they can be merged with new streams. Elements with
+// the same key are combined and only the n most frequent ones are kept.
+//
+// The alphas of the original stream are lost: if elts fills the stream,
+// untracked keys are estimated at the smallest tracked count as they can't
+// have been more frequent, otherwise at zero. Estimates after merging are
+// therefore less precise than merging the original streams. The total is
+// the sum of the counts.
+func FromElements(n int, elts []Element, opts ...Option) *Stream {
+	s := New(n, opts...)
+
+	sum := make(map[string]Element, len(elts))
+	for _, e := range elts {
+		acc := sum[e.Key]
+		acc.Key = e.Key
+		acc.Count += e.Count
+		acc.Error += e.Error
+		sum[e.Key] = acc
+	}
+	combined := make([]Element, 0, len(sum))
+	for _, e := range sum {
+		combined = append(combined, e)
+		s.total += e.Count
+	}
+	s.k.sort(combined)
+	if len(combined) > n {
+		combined = combined[:n]
+	}
+
+	for _, e := range combined {
+		e.Key = s.intern(e.Key)
+		s.k.m[e.Key] = len(s.k.elts)
+		s.k.elts = append(s.k.elts, e)
+	}
+	s.k.init()
+
+	if len(s.k.elts) == n && n > 0 {
+		min := s.k.elts[0].Count
+		for i := range s.alphas {
+			s.alphas[i] = min
+		}
+	}
+	return s
+}
@@ -0,0 +1,77 @@
+package topk
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestSummary(t *testing.T) {
+	s := New(100)
+	for _, w := range loadWords() {
+		s.Insert(w, 1)
+	}
+
+	full, buf := bytes.NewBuffer(nil), bytes.NewBuffer(nil)
+	if err := s.Encode(full); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.EncodeSummary(buf); err != nil {
+		t.Fatal(err)
+	}
+	if buf.Len()*2 > full.Len() {
+		t.Errorf("expected a much smaller summary, got %d of %d bytes", buf.Len(), full.Len())
+	}
+
+	sum, err := DecodeSummary(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum.Total != s.Total() {
+		t.Errorf("expected total %d, got %d", s.Total(), sum.Total)
+	}
+	if !reflect.DeepEqual(sum.Elements, s.Keys()) {
+		t.Errorf("elements differ: %v != %v", sum.Elements, s.Keys())
+	}
+
+	if _, err := DecodeSummary(full); err == nil {
+		t.Error("expected an error decoding a full stream as summary")
+	}
+}
+
+func TestFromElements(t *testing.T) {
+	s := FromElements(3, []Element{
+		{Key: "a", Count: 10},
+		{Key: "b", Count: 5, Error: 1},
+		{Key: "a", Count: 2, Error: 2},
+		{Key: "c", Count: 3},
+		{Key: "d", Count: 1},
+	})
+
+	want := []Element{{Key: "a", Count: 12, Error: 2}, {Key: "b", Count: 5, Error: 1}, {Key: "c", Count: 3}}
+	if !reflect.DeepEqual(s.Keys(), want) {
+		t.Errorf("expected %v, got %v", want, s.Keys())
+	}
+	if s.Total() != 21 {
+		t.Errorf("expected total 21, got %d", s.Total())
+	}
+	if e := s.Estimate("d"); e.Count != 3 || e.Error != 3 {
+		t.Errorf("expected untracked keys to be estimated at the minimum, got %v", e)
+	}
+	if err := s.Validate(); err != nil {
+		t.Error(err)
+	}
+
+	other := New(3)
+	other.Insert("b", 10)
+	if err := other.Merge(s); err != nil {
+		t.Fatal(err)
+	}
+	if e := other.Estimate("b"); e.Count != 15 {
+		t.Errorf("expected merged count 15, got %v", e)
+	}
+
+	if e := FromElements(10, want).Estimate("d"); e.Count != 0 {
+		t.Errorf("expected zero estimate below capacity, got %v", e)
+	}
+}
@@ -0,0 +1,39 @@
+package text
+
+import (
+	"io"
+	"strings"
+
+	"github.com/axiomhq/topk"
+)
+
+// Delimiter joins the tokens of an n-gram. It is the ASCII unit separator,
+// which doesn't occur in regular text, so n-grams can always be split back
+// into their tokens.
+const Delimiter = "\x1f"
+
+// CountNGrams slides a window of n tokens over the text read from r and
+// inserts every n-gram, its tokens joined by Delimiter, into s with a count
+// of 1. Stop words are skipped before the n-grams are formed.
+func CountNGrams(r io.Reader, s *topk.Stream, n int, opts ...Option) error {
+	if n < 1 {
+		n = 1
+	}
+
+	window := make([]string, 0, n)
+	return scan(r, opts, func(tok string) {
+		if len(window) == n {
+			copy(window, window[1:])
+			window = window[:n-1]
+		}
+		window = append(window, tok)
+		if len(window) == n {
+			s.Insert(strings.Join(window, Delimiter), 1)
+		}
+	})
+}
+
+// SplitNGram returns the tokens of an n-gram key
+func SplitNGram(key string) []string {
+	return strings.Split(key, Delimiter)
+}
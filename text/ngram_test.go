@@ -0,0 +1,32 @@
+package text
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/axiomhq/topk"
+)
+
+func TestCountNGrams(t *testing.T) {
+	const doc = "select * from users select * from orders select id from users"
+
+	s := topk.New(10)
+	if err := CountNGrams(strings.NewReader(doc), s, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	// "* from", "from users" and "select *" occur twice
+	top := s.Keys()
+	want := []string{"*", "from"}
+	if got := SplitNGram(top[0].Key); !reflect.DeepEqual(got, want) || top[0].Count != 2 {
+		t.Errorf("expected %v twice on top, got %v (%d)", want, got, top[0].Count)
+	}
+	if e := s.Estimate("from" + Delimiter + "users"); e.Count != 2 {
+		t.Errorf("expected from users to be counted twice, got %v", e)
+	}
+	// 12 tokens make 11 bigrams
+	if s.Total() != 11 {
+		t.Errorf("expected 11 bigrams, got %d", s.Total())
+	}
+}
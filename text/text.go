@@ -0,0 +1,69 @@
+// Package text counts the most frequent words of a text with a topk.Stream
+package text
+
+import (
+	"bufio"
+	"io"
+	"strings"
+
+	"github.com/axiomhq/topk"
+)
+
+type config struct {
+	split bufio.SplitFunc
+	stop  map[string]struct{}
+}
+
+// Option configures the tokenization
+type Option func(*config)
+
+// WithSplit sets the function splitting the input into tokens, the default is
+// bufio.ScanWords
+func WithSplit(split bufio.SplitFunc) Option {
+	return func(c *config) {
+		c.split = split
+	}
+}
+
+// WithStopWords skips the given words, they are matched case-insensitively
+func WithStopWords(words ...string) Option {
+	return func(c *config) {
+		if c.stop == nil {
+			c.stop = make(map[string]struct{}, len(words))
+		}
+		for _, w := range words {
+			c.stop[strings.ToLower(w)] = struct{}{}
+		}
+	}
+}
+
+// scan calls fn for every token read from r
+func scan(r io.Reader, opts []Option, fn func(tok string)) error {
+	c := config{split: bufio.ScanWords}
+	for _, opt := range opts {
+		opt(&c)
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Split(c.split)
+	for scanner.Scan() {
+		tok := scanner.Text()
+		if tok == "" {
+			continue
+		}
+		if _, ok := c.stop[strings.ToLower(tok)]; ok {
+			continue
+		}
+		fn(tok)
+	}
+	return scanner.Err()
+}
+
+// Count splits the text read from r into tokens and inserts each of them into
+// s with a count of 1. It returns the first error encountered reading r, if
+// any.
+func Count(r io.Reader, s *topk.Stream, opts ...Option) error {
+	return scan(r, opts, func(tok string) {
+		s.Insert(tok, 1)
+	})
+}
@@ -0,0 +1,28 @@
+package text
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/axiomhq/topk"
+)
+
+func TestCount(t *testing.T) {
+	const doc = `The quick brown fox jumps over the lazy dog.
+The dog sleeps, the fox runs.`
+
+	s := topk.New(10)
+	if err := Count(strings.NewReader(doc), s, WithStopWords("the", "over")); err != nil {
+		t.Fatal(err)
+	}
+
+	if e := s.Estimate("The"); e.Count != 0 {
+		t.Errorf("expected stop word to be skipped, got %v", e)
+	}
+	if e := s.Estimate("fox"); e.Count != 2 {
+		t.Errorf("expected fox to be counted twice, got %v", e)
+	}
+	if e := s.Estimate("dog."); e.Count != 1 {
+		t.Errorf("expected the default splitter to keep punctuation, got %v", e)
+	}
+}
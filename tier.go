@@ -0,0 +1,93 @@
+package topk
+
+import "container/heap"
+
+// tier tracks the largest elements evicted from a stream, see
+// WithSecondTier
+type tier struct {
+	k keys
+	n int
+}
+
+func newTier(n int) *tier {
+	return &tier{k: keys{m: make(map[string]int, n), elts: make([]Element, 0, n)}, n: n}
+}
+
+func (t *tier) clone() *tier {
+	c := t.empty()
+	c.k.elts = append(c.k.elts, t.k.elts...)
+	for k, v := range t.k.m {
+		c.k.m[k] = v
+	}
+	return c
+}
+
+// empty returns an empty tier of the same size
+func (t *tier) empty() *tier {
+	c := newTier(t.n)
+	c.k.stable = t.k.stable
+	return c
+}
+
+func (t *tier) get(x string) (Element, bool) {
+	idx, ok := t.k.m[x]
+	if !ok {
+		return Element{}, false
+	}
+	return t.k.elts[idx], true
+}
+
+// add starts tracking an element evicted from the stream, unless the tier
+// is full of larger ones
+func (t *tier) add(e Element) {
+	if len(t.k.elts) < t.n {
+		t.k.push(e)
+		return
+	}
+	if t.n == 0 || t.k.below(e, t.k.elts[0]) {
+		return
+	}
+	delete(t.k.m, t.k.elts[0].Key)
+	t.k.elts[0] = e
+	t.k.m[e.Key] = 0
+	t.k.fix(0)
+}
+
+// update adds count to x if it is tracked
+func (t *tier) update(x string, count int) {
+	if idx, ok := t.k.m[x]; ok {
+		t.k.elts[idx].Count = satAdd(t.k.elts[idx].Count, count)
+		t.k.fix(idx)
+	}
+}
+
+// remove stops tracking x, e.g. once it is tracked by the stream again
+func (t *tier) remove(x string) {
+	if idx, ok := t.k.m[x]; ok {
+		heap.Remove(&t.k, idx)
+	}
+}
+
+// resetTier forgets the evicted counts of the second tier. They only cover
+// the inserts into s, once counts of other streams are merged or decoded
+// into s they would no longer bound the counts of the keys.
+func (s *Stream) resetTier() {
+	if s.tier != nil {
+		// a new tier, the old one may be referenced by a View or by the
+		// stream a decoded copy was made of
+		s.tier = s.tier.empty()
+	}
+}
+
+// WithSecondTier keeps up to n elements evicted from the stream with their
+// counts in a second heap, the largest ones once it is full. Keys
+// oscillating around the minimum are counted from their own evicted counts
+// instead of the alphas they share with others, which gives them tighter
+// estimates. The second tier is not persisted in the encoding and is
+// emptied whenever another stream is merged or decoded into the stream.
+func WithSecondTier(n int) Option {
+	return func(s *Stream) {
+		s.tier = newTier(n)
+		s.tier.k.stable = s.k.stable
+	}
+}
@@ -0,0 +1,136 @@
+package topk
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"testing"
+)
+
+func TestSecondTier(t *testing.T) {
+	run := func(opts ...Option) *Stream {
+		s := New(2, opts...)
+		s.Insert("a", 100)
+		s.Insert("b", 5)
+		// b is evicted by a tail of slightly larger keys
+		for i := 0; i < 100; i++ {
+			s.Insert(fmt.Sprintf("tail-%d", i), 6)
+		}
+		return s
+	}
+	plain, tiered := run(), run(WithSecondTier(200))
+
+	if _, ok := tiered.k.m["b"]; ok {
+		t.Fatal("expected b to be evicted")
+	}
+	if e := tiered.Estimate("b"); e != (Element{Key: "b", Count: 5}) {
+		t.Errorf("expected b estimated from its evicted count, got %v", e)
+	}
+	if e := plain.Estimate("b"); e.Count <= 5 {
+		t.Errorf("expected a looser estimate from the alphas, got %v", e)
+	}
+	if err := tiered.Validate(); err != nil {
+		t.Error(err)
+	}
+
+	// b comes back with its count
+	tiered.Insert("b", 100)
+	if e := tiered.Estimate("b"); e != (Element{Key: "b", Count: 105}) {
+		t.Errorf("expected b readmitted with count 105, got %v", e)
+	}
+	if _, ok := tiered.tier.get("b"); ok {
+		t.Error("expected b to leave the second tier")
+	}
+
+	// the tier of a view doesn't change with the stream
+	v := tiered.Freeze()
+	before := v.Estimate("b")
+	for i := 0; i < 100; i++ {
+		tiered.Insert("b", 1)
+		tiered.Insert(fmt.Sprintf("more-%d", i), 1)
+	}
+	if after := v.Estimate("b"); after != before {
+		t.Errorf("view changed: %v != %v", after, before)
+	}
+}
+
+func TestSecondTierMergeDecode(t *testing.T) {
+	// b is evicted from both streams, from other with a larger count
+	evicted := func(count int) *Stream {
+		s := New(2, WithSecondTier(200))
+		s.Insert("a", 1000)
+		s.Insert("b", count)
+		for i := 0; i < 100; i++ {
+			s.Insert(fmt.Sprintf("tail-%d", i), count+1)
+		}
+		if _, ok := s.tier.get("b"); !ok {
+			t.Fatal("expected b in the second tier")
+		}
+		return s
+	}
+
+	s, other := evicted(5), evicted(50)
+	if err := s.Merge(other); err != nil {
+		t.Fatal(err)
+	}
+	if e := s.Estimate("b"); e.Count < 55 {
+		t.Errorf("expected the merged estimate of b to be at least 55, got %v", e)
+	}
+
+	var buf bytes.Buffer
+	if err := other.Encode(&buf); err != nil {
+		t.Fatal(err)
+	}
+	s = evicted(5)
+	if err := s.Decode(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if e := s.Estimate("b"); e.Count < 50 {
+		t.Errorf("expected the decoded estimate of b to be at least 50, got %v", e)
+	}
+
+	s = evicted(5)
+	b, err := other.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.MergeEncoded(b); err != nil {
+		t.Fatal(err)
+	}
+	if e := s.Estimate("b"); e.Count < 55 {
+		t.Errorf("expected the merged estimate of b to be at least 55, got %v", e)
+	}
+}
+
+func TestSecondTierFreeSlot(t *testing.T) {
+	s := New(2, WithSecondTier(10))
+	s.Insert("a", 100)
+	s.Insert("b", 5)
+	s.Insert("c", 6)
+	if _, ok := s.tier.get("b"); !ok {
+		t.Fatal("expected b in the second tier")
+	}
+
+	// shrinking and growing again leaves a free slot for b
+	s.Resize(1)
+	s.Resize(2)
+	s.Insert("b", 1)
+	if _, ok := s.k.m["b"]; !ok {
+		t.Fatal("expected b to be tracked")
+	}
+	if _, ok := s.tier.get("b"); ok {
+		t.Error("expected b to leave the second tier")
+	}
+	if _, ok := s.tier.get("c"); !ok {
+		t.Error("expected c resized into the second tier")
+	}
+}
+
+func TestSecondTierSaturates(t *testing.T) {
+	tr := newTier(1)
+	tr.add(Element{Key: "a", Count: math.MaxInt - 1})
+	tr.update("a", 10)
+	if e, _ := tr.get("a"); e.Count != math.MaxInt {
+		t.Errorf("expected the count to saturate, got %v", e)
+	}
+}
@@ -18,10 +18,16 @@ Licensed under the MIT license.
 package topk
 
 import (
+	"bufio"
 	"container/heap"
+	"context"
 	"fmt"
 	"io"
+	"maps"
+	"math"
+	"math/rand"
 	"sort"
+	"time"
 
 	"github.com/dgryski/go-metro"
 	"github.com/tinylib/msgp/msgp"
@@ -32,30 +38,62 @@ type Element struct {
 	Key   string `json:"key"`
 	Count int    `json:"count"`
 	Error int    `json:"error"`
+
+	// Share is the fraction of the stream's total the element accounts for,
+	// it is only set by queries using WithShare
+	Share float64 `json:"share,omitempty"`
+
+	// Truncated is set if the key is the prefix and hash of a longer key,
+	// see PrefixHashLongKeys
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// LowerBound returns the guaranteed count of the element, the true count is
+// at least this large
+func (e Element) LowerBound() int { return e.Count - e.Error }
+
+// UpperBound returns the maximum count of the element, the true count is at
+// most this large
+func (e Element) UpperBound() int { return e.Count }
+
+// ElementLess reports whether a is ranked before b in the results: elements
+// are ordered by descending count, ties are broken by ascending key
+func ElementLess(a, b Element) bool {
+	return (a.Count > b.Count) || (a.Count == b.Count && a.Key < b.Key)
+}
+
+// SortElements sorts elts in the order returned by Keys
+func SortElements(elts []Element) {
+	sort.Sort(elementsByCountDescending(elts))
 }
 
 type elementsByCountDescending []Element
 
 func (elts elementsByCountDescending) Len() int { return len(elts) }
 func (elts elementsByCountDescending) Less(i, j int) bool {
-	return (elts[i].Count > elts[j].Count) || (elts[i].Count == elts[j].Count && elts[i].Key < elts[j].Key)
+	return ElementLess(elts[i], elts[j])
 }
 func (elts elementsByCountDescending) Swap(i, j int) { elts[i], elts[j] = elts[j], elts[i] }
 
 type keys struct {
 	m    map[string]int
 	elts []Element
+
+	score  func(Element) float64 // see WithScore
+	stable bool                  // see WithDeterministic
 }
 
 func (tk *keys) EncodeMsgp(w *msgp.Writer) error {
 	if err := w.WriteMapHeader(uint32(len(tk.m))); err != nil {
 		return err
 	}
-	for k, v := range tk.m {
-		if err := w.WriteString(k); err != nil {
+	// the index in element order keeps the encoding independent of the
+	// map's iteration order
+	for i, e := range tk.elts {
+		if err := w.WriteString(e.Key); err != nil {
 			return err
 		}
-		if err := w.WriteInt(v); err != nil {
+		if err := w.WriteInt(i); err != nil {
 			return err
 		}
 	}
@@ -78,6 +116,10 @@ func (tk *keys) EncodeMsgp(w *msgp.Writer) error {
 }
 
 func (tk *keys) DecodeMsp(r *msgp.Reader) error {
+	return tk.decode(context.Background(), r)
+}
+
+func (tk *keys) decode(ctx context.Context, r *msgp.Reader) error {
 	var (
 		err error
 		sz  uint32
@@ -86,11 +128,18 @@ func (tk *keys) DecodeMsp(r *msgp.Reader) error {
 	if sz, err = r.ReadMapHeader(); err != nil {
 		return err
 	}
+	hint, err := decodeLen(int(sz))
+	if err != nil {
+		return err
+	}
 
-	tk.m = make(map[string]int, sz)
+	tk.m = make(map[string]int, hint)
 
 	for i := uint32(0); i < sz; i++ {
-		key, err := r.ReadString()
+		if err := canceled(ctx, int(i)); err != nil {
+			return err
+		}
+		key, err := readString(r)
 		if err != nil {
 			return err
 		}
@@ -105,17 +154,25 @@ func (tk *keys) DecodeMsp(r *msgp.Reader) error {
 		return err
 	}
 
-	tk.elts = make([]Element, sz)
-	for i := range tk.elts {
-		if tk.elts[i].Key, err = r.ReadString(); err != nil {
+	if hint, err = decodeLen(int(sz)); err != nil {
+		return err
+	}
+	tk.elts = make([]Element, 0, hint)
+	for i := 0; i < int(sz); i++ {
+		if err := canceled(ctx, i); err != nil {
+			return err
+		}
+		var e Element
+		if e.Key, err = readString(r); err != nil {
 			return err
 		}
-		if tk.elts[i].Count, err = r.ReadInt(); err != nil {
+		if e.Count, err = r.ReadInt(); err != nil {
 			return err
 		}
-		if tk.elts[i].Error, err = r.ReadInt(); err != nil {
+		if e.Error, err = r.ReadInt(); err != nil {
 			return err
 		}
+		tk.elts = append(tk.elts, e)
 	}
 
 	return nil
@@ -127,8 +184,53 @@ func (tk *keys) DecodeMsp(r *msgp.Reader) error {
 func (tk *keys) Len() int { return len(tk.elts) }
 
 // Less ...
-func (tk *keys) Less(i, j int) bool {
-	return (tk.elts[i].Count < tk.elts[j].Count) || (tk.elts[i].Count == tk.elts[j].Count && tk.elts[i].Error > tk.elts[j].Error)
+func (tk *keys) Less(i, j int) bool { return tk.less(tk.elts[i], tk.elts[j]) }
+
+// less reports whether a is evicted before b
+func (tk *keys) less(a, b Element) bool {
+	if tk.score != nil {
+		if sa, sb := tk.score(a), tk.score(b); sa != sb {
+			return sa < sb
+		}
+	} else if a.Count != b.Count {
+		return a.Count < b.Count
+	}
+	if a.Error != b.Error {
+		return a.Error > b.Error
+	}
+	// the larger key ranks lower in the results
+	return tk.stable && a.Key > b.Key
+}
+
+// below reports whether e ranks strictly below the tracked minimum min and
+// can't replace it
+func (tk *keys) below(e, min Element) bool {
+	if tk.score != nil {
+		return tk.score(e) < tk.score(min)
+	}
+	return e.Count < min.Count
+}
+
+// ranked reports whether a is ranked before b in the results of Keys: by
+// descending score if set, then like ElementLess
+func (tk *keys) ranked(a, b Element) bool {
+	if tk.score != nil {
+		if sa, sb := tk.score(a), tk.score(b); sa != sb {
+			return sa > sb
+		}
+	}
+	return ElementLess(a, b)
+}
+
+// sort sorts elts in the order returned by Keys
+func (tk *keys) sort(elts []Element) {
+	if tk.score == nil {
+		sort.Sort(elementsByCountDescending(elts))
+		return
+	}
+	sort.Slice(elts, func(i, j int) bool {
+		return tk.ranked(elts[i], elts[j])
+	})
 }
 func (tk *keys) Swap(i, j int) {
 
@@ -153,20 +255,211 @@ func (tk *keys) Pop() interface{} {
 	return e
 }
 
+// The insert path uses these instead of container/heap, they behave the same
+// but avoid the interface dispatch and boxing of pushed elements
+
+func (tk *keys) push(e Element) {
+	tk.m[e.Key] = len(tk.elts)
+	tk.elts = append(tk.elts, e)
+	tk.up(len(tk.elts) - 1)
+}
+
+func (tk *keys) init() {
+	n := len(tk.elts)
+	for i := n/2 - 1; i >= 0; i-- {
+		tk.down(i, n)
+	}
+}
+
+func (tk *keys) fix(i int) {
+	if !tk.down(i, len(tk.elts)) {
+		tk.up(i)
+	}
+}
+
+func (tk *keys) up(j int) {
+	for {
+		i := (j - 1) / 2 // parent
+		if i == j || !tk.Less(j, i) {
+			break
+		}
+		tk.Swap(i, j)
+		j = i
+	}
+}
+
+func (tk *keys) down(i0, n int) bool {
+	i := i0
+	for {
+		j1 := 2*i + 1
+		if j1 >= n || j1 < 0 { // j1 < 0 after int overflow
+			break
+		}
+		j := j1 // left child
+		if j2 := j1 + 1; j2 < n && tk.Less(j2, j1) {
+			j = j2 // = 2*i + 2  // right child
+		}
+		if !tk.Less(j, i) {
+			break
+		}
+		tk.Swap(i, j)
+		i = j
+	}
+	return i > i0
+}
+
 // Stream calculates the TopK elements for a stream
 type Stream struct {
 	n      int
 	k      keys
 	alphas []int
+
+	// shared is set when k and alphas are referenced by a View and have to
+	// be copied before the next modification
+	shared bool
+
+	deferred bool // see WithDeferredHeap
+	stale    bool // heap ordering needs to be restored
+
+	total      int // sum of all inserted counts
+	inserts    int // number of inserts
+	admissions int // number of times a key started being tracked
+
+	transform func(string) string // see WithKeyTransform
+	maxKeyLen int                 // see WithMaxKeyLength
+	keyPolicy KeyLengthPolicy
+
+	filter       func(string) bool // see WithFilter
+	filterAction FilterAction
+
+	sampleRate float64 // see WithSampling
+	rng        *rand.Rand
+
+	door *doorkeeper // see WithDoorkeeper
+
+	distinct *hll // see WithDistinct
+
+	shadow *shadow // see WithShadow
+
+	evictions *evictionLog // see WithEvictionLog
+	clock     Clock        // see WithClock
+
+	hooks Hooks  // see WithHooks
+	tune  *tuner // see WithAutoTune
+
+	probabilistic bool // see WithProbabilisticUpdate
+	updateRng     *rand.Rand
+
+	replacement ReplacementPolicy // see WithReplacement
+	promotion   int               // see WithPromotion
+
+	tier *tier // see WithSecondTier
+
+	decrements bool // see WithDecrements
+
+	seed *int64 // see WithDeterministic
+
+	interner *Interner // see WithInterner
+
+	timings timings // see WithTimings
+
+	labels         map[string]string // see WithLabels
+	requiredLabels []string          // see WithRequiredLabels
+
+	stats Stats
 }
 
 // New returns a Stream estimating the top n most frequent elements
-func New(n int) *Stream {
-	return &Stream{
+func New(n int, opts ...Option) *Stream {
+	s := &Stream{
 		n:      n,
 		k:      keys{m: make(map[string]int, n), elts: make([]Element, 0, n)},
 		alphas: make([]int, n*6), // 6 is the multiplicative constant from the paper
 	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// NewFromPrior returns a stream of the size of prior seeded with prior's
+// elements at their counts scaled by scale, so that keys known to be heavy
+// aren't evicted by the churn at the start of a new window.
+//
+// The seeded counts are not part of the new stream's total and count
+// entirely as error, the lower bounds only cover the new inserts. Elements
+// whose scaled count rounds to zero are not seeded, as is everything for a
+// scale that isn't positive.
+func NewFromPrior(prior *Stream, scale float64, opts ...Option) *Stream {
+	s := New(prior.n, opts...)
+	if !(scale > 0) {
+		return s
+	}
+	for _, e := range prior.k.elts {
+		count := int(math.Floor(float64(e.Count) * scale))
+		if count <= 0 {
+			continue
+		}
+		s.k.m[e.Key] = len(s.k.elts)
+		s.k.elts = append(s.k.elts, Element{Key: e.Key, Count: count, Error: count})
+	}
+	s.k.init()
+	return s
+}
+
+// clone returns a deep copy of the stream
+func (s *Stream) clone() *Stream {
+	c := *s
+	c.k = keys{m: make(map[string]int, len(s.k.m)), elts: append(make([]Element, 0, s.n), s.k.elts...), score: s.k.score, stable: s.k.stable}
+	c.alphas = append([]int(nil), s.alphas...)
+	c.shared = false
+	if s.distinct != nil {
+		c.distinct = s.distinct.clone()
+	}
+	if s.evictions != nil {
+		c.evictions = s.evictions.clone()
+	}
+	if s.tier != nil {
+		c.tier = s.tier.clone()
+	}
+	c.labels = maps.Clone(s.labels)
+	for k, v := range s.k.m {
+		c.k.m[k] = v
+	}
+	return &c
+}
+
+// own makes sure the stream has exclusive access to its state before it is
+// modified
+func (s *Stream) own() {
+	if s.shared {
+		*s = *s.clone()
+	}
+}
+
+// satAdd returns a+b saturated at the bounds of int, so that counts of long
+// lived streams merged over and over stick at the maximum instead of
+// overflowing into negative counts
+func satAdd(a, b int) int {
+	c := a + b
+	if (c > a) == (b > 0) {
+		return c
+	}
+	if b > 0 {
+		return math.MaxInt
+	}
+	return math.MinInt
+}
+
+// satInt converts f to an int saturated at the bounds of int
+func satInt(f float64) int {
+	switch {
+	case f >= math.MaxInt:
+		return math.MaxInt
+	case f <= math.MinInt:
+		return math.MinInt
+	}
+	return int(f)
 }
 
 func reduce(x uint64, n int) uint32 {
@@ -176,61 +469,481 @@ func reduce(x uint64, n int) uint32 {
 // Insert adds an element to the stream to be tracked
 // It returns an estimation for the just inserted element
 func (s *Stream) Insert(x string, count int) Element {
+	e, _ := s.InsertDetailed(x, count)
+	return e
+}
 
-	xhash := reduce(metro.Hash64Str(x, 0), len(s.alphas))
+// InsertDetailed is like Insert but also reports how the insert was handled
+func (s *Stream) InsertDetailed(x string, count int) (Element, InsertOutcome) {
+	s.own()
+	x, r := s.admit(x)
+	count = s.scale(count)
+	if r == dropKey {
+		return Element{}, Dropped
+	}
+	if count < 0 {
+		if !s.decrements {
+			return s.estimate(x), Invalid
+		}
+		return s.decrement(x, r, count)
+	}
+	if r == alphaKey {
+		return s.insertAlpha(x, metro.Hash64Str(x, 0), count), Filtered
+	}
+	return s.insert(x, metro.Hash64Str(x, 0), count)
+}
+
+// decrement subtracts -count from x, see WithDecrements
+func (s *Stream) decrement(x string, r route, count int) (Element, InsertOutcome) {
+	s.inserts++
+	if s.timings.on {
+		s.timings.inserts++
+	}
+	// restoring the heap moves the elements, look the key up afterwards
+	s.restore()
+	idx, ok := s.k.m[x]
+	if !ok {
+		s.total = max(s.total+count, 0)
+		// the alpha stays an upper bound of all keys hashing to it
+		if r == alphaKey {
+			return s.estimate(x), Filtered
+		}
+		return s.estimate(x), Rejected
+	}
+	e := &s.k.elts[idx]
+	count = max(count, -e.Count)
+	e.Count += count
+	s.total = max(s.total+count, 0)
+	if e.Error > e.Count {
+		e.Error = e.Count
+	}
+	res := *e
+	s.fix(idx)
+	return res, Updated
+}
+
+// InsertOutcome tells how an insert was handled
+type InsertOutcome uint8
+
+const (
+	// Dropped inserts were ignored by sampling, the filter or the key
+	// length policy
+	Dropped InsertOutcome = iota
+	// Held inserts are first occurrences held back by the doorkeeper
+	Held
+	// Filtered inserts were only counted in the alphas, see
+	// AlphaOnlyFiltered
+	Filtered
+	// Rejected inserts were only counted in the alphas, the key's estimate
+	// stays below the tracked minimum. Decrements of untracked keys leave
+	// the alphas unchanged.
+	Rejected
+	// Updated inserts were added to an already tracked element
+	Updated
+	// AdmittedFree inserts started tracking the key in a free slot
+	AdmittedFree
+	// AdmittedReplacing inserts started tracking the key in place of the
+	// evicted minimum
+	AdmittedReplacing
+	// Invalid inserts had a negative count without WithDecrements and were
+	// ignored
+	Invalid
+)
+
+func (o InsertOutcome) String() string {
+	switch o {
+	case Dropped:
+		return "dropped"
+	case Held:
+		return "held"
+	case Filtered:
+		return "filtered"
+	case Rejected:
+		return "rejected"
+	case Updated:
+		return "updated"
+	case AdmittedFree:
+		return "admitted-free"
+	case AdmittedReplacing:
+		return "admitted-replacing"
+	case Invalid:
+		return "invalid"
+	}
+	return fmt.Sprintf("InsertOutcome(%d)", uint8(o))
+}
+
+// route tells how an insert of a key is handled
+type route uint8
+
+const (
+	trackKey route = iota // regular insert
+	alphaKey              // only update the alphas, never track the key
+	dropKey               // ignore the insert
+)
+
+// admit applies the key normalization and filters to x, it returns the key x
+// is tracked under and how to handle the insert
+func (s *Stream) admit(x string) (string, route) {
+	if s.rng != nil && s.rng.Float64() >= s.sampleRate {
+		s.stats.SkippedInserts++
+		return x, dropKey
+	}
+
+	x, long := s.key(x)
+	if long {
+		s.stats.LongKeys++
+		if s.keyPolicy == RejectLongKeys {
+			return x, dropKey
+		}
+	}
+	if s.filter != nil && s.filter(x) {
+		s.stats.FilteredKeys++
+		if s.filterAction == DropFiltered {
+			return x, dropKey
+		}
+		return x, alphaKey
+	}
+	return x, trackKey
+}
+
+// scale returns count scaled up for the sample rate. The scaled count is
+// rounded up or down at random, by the fraction it is closer to either, so
+// that it stays unbiased for any rate.
+func (s *Stream) scale(count int) int {
+	if s.rng == nil {
+		return count
+	}
+	scaled := float64(count) / s.sampleRate
+	f := math.Floor(scaled)
+	if s.rng.Float64() < scaled-f {
+		f++
+	}
+	return satInt(f)
+}
+
+// key returns the key x is tracked under, long is set if x exceeds the
+// maximum key length
+func (s *Stream) key(x string) (k string, long bool) {
+	if s.transform != nil {
+		x = s.transform(x)
+	}
+	if s.maxKeyLen > 0 && len(x) > s.maxKeyLen {
+		return s.keyPolicy.shorten(x, s.maxKeyLen), true
+	}
+	return x, false
+}
+
+// InsertMany adds each of xs to the stream with a count of 1.
+//
+// The keys are hashed in batches before any of them touches the heap, which
+// keeps the hashing loop tight and free of dependencies on the heap updates.
+func (s *Stream) InsertMany(xs []string) {
+	s.own()
+
+	var (
+		keys   [64]string
+		hashes [64]uint64
+		routes [64]route
+	)
+	for len(xs) > 0 {
+		batch := xs
+		if len(batch) > len(hashes) {
+			batch = batch[:len(hashes)]
+		}
+		m := 0
+		for _, x := range batch {
+			k, r := s.admit(x)
+			if r == dropKey {
+				continue
+			}
+			keys[m], routes[m] = k, r
+			hashes[m] = metro.Hash64Str(k, 0)
+			m++
+		}
+		for i := 0; i < m; i++ {
+			count := s.scale(1)
+			if routes[i] == alphaKey {
+				s.insertAlpha(keys[i], hashes[i], count)
+			} else {
+				s.insert(keys[i], hashes[i], count)
+			}
+		}
+		xs = xs[len(batch):]
+	}
+}
+
+// insertAlpha counts x in the alphas only
+func (s *Stream) insertAlpha(x string, h uint64, count int) Element {
+	s.total = satAdd(s.total, count)
+	s.inserts++
+	if s.timings.on {
+		s.timings.inserts++
+	}
+	if s.distinct != nil {
+		s.distinct.add(h)
+	}
+	if s.shadow != nil {
+		s.shadow.count(x, h, count)
+		defer s.shadow.tick(s)
+	}
+	if s.hooks != nil {
+		s.hooks.OnInsert(x, count)
+	}
+	xhash := reduce(h, len(s.alphas))
+	s.alphas[xhash] = satAdd(s.alphas[xhash], count)
+	return Element{
+		Key:   x,
+		Error: s.alphas[xhash],
+		Count: s.alphas[xhash],
+	}
+}
+
+// insert adds x with the precomputed hash h
+func (s *Stream) insert(x string, h uint64, count int) (Element, InsertOutcome) {
+	s.total = satAdd(s.total, count)
+	s.inserts++
+	if s.timings.on {
+		s.timings.inserts++
+	}
+	if s.tune != nil {
+		s.tune.inserts++
+	}
+	if s.distinct != nil {
+		s.distinct.add(h)
+	}
+	if s.shadow != nil {
+		s.shadow.count(x, h, count)
+		defer s.shadow.tick(s)
+	}
+	if s.hooks != nil {
+		s.hooks.OnInsert(x, count)
+	}
+	xhash := reduce(h, len(s.alphas))
 
 	// are we tracking this element?
 	if idx, ok := s.k.m[x]; ok {
-		s.k.elts[idx].Count += count
+		s.k.elts[idx].Count = satAdd(s.k.elts[idx].Count, count)
 		e := s.k.elts[idx]
-		heap.Fix(&s.k, idx)
-		return e
+		if s.deferred {
+			s.stale = true
+		} else {
+			s.fix(idx)
+		}
+		return e, Updated
+	}
+
+	if s.door != nil && count == 1 {
+		switch s.door.check(h) {
+		case doorHeld:
+			s.stats.HeldInserts++
+			return Element{Key: x, Count: 1}, Held
+		case doorAdmit:
+			// add back the held first occurrence
+			count++
+		}
 	}
 
 	// can we track more elements?
 	if len(s.k.elts) < s.n {
 		// there is free space
-		e := Element{Key: x, Count: count}
-		heap.Push(&s.k, e)
-		return e
+		e := Element{Key: s.intern(x), Count: count}
+		s.k.push(e)
+		s.admissions++
+		if s.tier != nil {
+			s.tier.remove(x)
+		}
+		if s.hooks != nil {
+			s.hooks.OnAdmit(e)
+		}
+		return e, AdmittedFree
 	}
 
-	if s.alphas[xhash]+count < s.k.elts[0].Count {
-		e := Element{
-			Key:   x,
-			Error: s.alphas[xhash],
-			Count: s.alphas[xhash] + count,
+	// the decision below needs the actual minimum
+	s.restore()
+
+	e := Element{
+		Key:   x,
+		Error: s.alphas[xhash],
+		Count: satAdd(s.alphas[xhash], count),
+	}
+	if s.replacement == InheritMinReplacement && s.k.elts[0].Count > e.Error {
+		e.Error = s.k.elts[0].Count
+		e.Count = satAdd(e.Error, count)
+	}
+	if s.tier != nil {
+		if te, ok := s.tier.get(x); ok && satAdd(te.Count, count) < e.Count {
+			e = Element{Key: x, Count: satAdd(te.Count, count), Error: te.Error}
 		}
-		s.alphas[xhash] += count
-		return e
+	}
+	if s.k.below(e, s.k.elts[0]) || s.promoted(s.k.elts[0]) || s.skipReplace(count) {
+		s.alphas[xhash] = satAdd(s.alphas[xhash], count)
+		if s.tier != nil {
+			s.tier.update(x, count)
+		}
+		return e, Rejected
 	}
 
-	// replace the current minimum element
+	s.replaceMin(e)
+	return e, AdmittedReplacing
+}
+
+// replaceMin evicts the current minimum element in favor of e
+func (s *Stream) replaceMin(e Element) {
 	minElement := s.k.elts[0]
+	e.Key = s.intern(e.Key)
 
 	mkhash := reduce(metro.Hash64Str(minElement.Key, 0), len(s.alphas))
 	s.alphas[mkhash] = minElement.Count
+	s.stats.Evictions++
+	s.admissions++
+	if s.evictions != nil {
+		s.evictions.add(Eviction{Element: minElement, By: e.Key, Time: s.now()})
+	}
+	if s.hooks != nil {
+		s.hooks.OnEvict(minElement, e.Key)
+		s.hooks.OnAdmit(e)
+	}
 
-	e := Element{
-		Key:   x,
-		Error: s.alphas[xhash],
-		Count: s.alphas[xhash] + count,
+	if s.tier != nil {
+		s.tier.remove(e.Key)
+		s.tier.add(minElement)
 	}
+
 	s.k.elts[0] = e
 
 	// we're not longer monitoring minKey
 	delete(s.k.m, minElement.Key)
 	// but 'x' is as array position 0
-	s.k.m[x] = 0
+	s.k.m[e.Key] = 0
+
+	s.fix(0)
+	if s.tune != nil {
+		s.evicted()
+	}
+}
 
-	heap.Fix(&s.k, 0)
+// promoted reports whether e is authoritative, see WithPromotion
+func (s *Stream) promoted(e Element) bool {
+	return s.promotion > 0 && e.Count > s.promotion
+}
+
+// skipReplace decides whether a new key with count passes on replacing the
+// minimum element
+func (s *Stream) skipReplace(count int) bool {
+	if !s.probabilistic {
+		return false
+	}
+	if s.updateRng == nil {
+		s.updateRng = s.newRand()
+	}
+	min := s.k.elts[0].Count
+	return s.updateRng.Float64()*float64(min+count) >= float64(count)
+}
+
+// Correct replaces the estimate of x with its exact count, e.g. known from
+// an offline computation. A tracked element gets exact as its count and no
+// error, an untracked key is admitted unless exact is below the tracked
+// minimum. The total is left unchanged.
+// It returns the resulting estimate of x
+func (s *Stream) Correct(x string, exact int) Element {
+	s.own()
+	s.restore()
+	x, _ = s.key(x)
+	e := Element{Key: x, Count: exact}
+	if s.tier != nil {
+		// the evicted count of x is superseded by the exact one
+		s.tier.remove(x)
+	}
+
+	if idx, ok := s.k.m[x]; ok {
+		s.k.elts[idx] = e
+		s.fix(idx)
+		return e
+	}
+	if len(s.k.elts) < s.n {
+		e.Key = s.intern(x)
+		s.k.push(e)
+		s.admissions++
+		if s.hooks != nil {
+			s.hooks.OnAdmit(e)
+		}
+		return e
+	}
+	if s.k.below(e, s.k.elts[0]) {
+		return s.estimate(x)
+	}
+	s.replaceMin(e)
 	return e
 }
 
+// Consolidate restores the heap ordering deferred by WithDeferredHeap. It is
+// done implicitly whenever the minimum element is needed, calling it
+// periodically keeps that cost off the insert path.
+func (s *Stream) Consolidate() {
+	if s.stale {
+		s.own()
+		s.restore()
+	}
+}
+
+func (s *Stream) restore() {
+	if !s.stale {
+		return
+	}
+	if s.timings.on {
+		defer s.timings.fixed(time.Now())
+	}
+	s.k.init()
+	s.stale = false
+}
+
 // Merge ...
 func (s *Stream) Merge(other *Stream) error {
+	return s.MergeContext(context.Background(), other)
+}
+
+// MergeSketch merges other, which has to be a *Stream, into s like Merge
+func (s *Stream) MergeSketch(other Sketch) error {
+	o, ok := other.(*Stream)
+	if !ok {
+		if other == nil {
+			return ErrNilSketch
+		}
+		return incompatible("cannot merge %T into %T", other, s)
+	}
+	return s.Merge(o)
+}
+
+// CompatibleWith returns why other can't be merged into s, nil if it can.
+// It checks the configuration Merge relies on without touching either
+// stream: the size n, the number of partitions and alphas and the hashing
+// of the keys. Errors wrap ErrIncompatibleSketch, or are ErrNilSketch.
+func (s *Stream) CompatibleWith(other *Stream) error {
+	if other == nil {
+		return ErrNilSketch
+	}
 	if s.n != other.n {
-		return fmt.Errorf("expected stream of size n %d, got %d", s.n, other.n)
+		return incompatible("expected stream of size n %d, got %d", s.n, other.n)
+	}
+	// a Stream is a single partition, the streams of a Sharded are merged
+	// shard by shard
+	if len(s.alphas) != len(other.alphas) {
+		return incompatible("expected %d alphas, got %d", len(s.alphas), len(other.alphas))
+	}
+	// streams in memory share the hash, encodings are checked for it when
+	// decoded, see decodeFields
+	return s.checkLabels(other.labels)
+}
+
+// MergeContext is like Merge but gives up with the context's error once ctx
+// is done. s is left unchanged in that case.
+func (s *Stream) MergeContext(ctx context.Context, other *Stream) error {
+	if err := s.CompatibleWith(other); err != nil {
+		return err
+	}
+	s.own()
+	if s.timings.on {
+		defer s.timings.merged(time.Now())
 	}
 
 	// merge the elements
@@ -243,11 +956,17 @@ func (s *Stream) Merge(other *Stream) error {
 		eKeys[e.Key] = struct{}{}
 	}
 
+	i := 0
 	for k := range eKeys {
+		if err := canceled(ctx, i); err != nil {
+			return err
+		}
+		i++
+
 		idx1, ok1 := s.k.m[k]
 		idx2, ok2 := other.k.m[k]
 		xhash := reduce(metro.Hash64Str(k, 0), len(s.alphas))
-		min1 := other.alphas[xhash]
+		min1 := s.alphas[xhash]
 		min2 := other.alphas[xhash]
 
 		switch {
@@ -256,33 +975,65 @@ func (s *Stream) Merge(other *Stream) error {
 			e2 := other.k.elts[idx2]
 			eMap[k] = Element{
 				Key:   k,
-				Count: e1.Count + e2.Count,
-				Error: e1.Error + e2.Error,
+				Count: satAdd(e1.Count, e2.Count),
+				Error: satAdd(e1.Error, e2.Error),
 			}
 		case ok1:
 			e1 := s.k.elts[idx1]
 			eMap[k] = Element{
 				Key:   k,
-				Count: e1.Count + min2,
-				Error: e1.Error + min2,
+				Count: satAdd(e1.Count, min2),
+				Error: satAdd(e1.Error, min2),
 			}
 		case ok2:
 			e2 := other.k.elts[idx2]
 			eMap[k] = Element{
 				Key:   k,
-				Count: e2.Count + min1,
-				Error: e2.Error + min1,
+				Count: satAdd(e2.Count, min1),
+				Error: satAdd(e2.Error, min1),
 			}
 		}
 
 	}
 
-	// sort the elements
 	elts := make([]Element, 0, len(eMap))
 	for _, v := range eMap {
 		elts = append(elts, v)
 	}
-	sort.Sort(elementsByCountDescending(elts))
+
+	// modify alphas
+	for i, v := range other.alphas {
+		s.alphas[i] = satAdd(s.alphas[i], v)
+	}
+	s.mergeCounters(other)
+	s.resetTier()
+
+	s.replaceElements(elts)
+	if s.hooks != nil {
+		s.hooks.OnMerge(len(s.k.elts))
+	}
+	return nil
+}
+
+// mergeCounters adds the counters of other to those of s
+func (s *Stream) mergeCounters(other *Stream) {
+	s.total = satAdd(s.total, other.total)
+	s.inserts = satAdd(s.inserts, other.inserts)
+	s.admissions = satAdd(s.admissions, other.admissions)
+	s.stats.Evictions = satAdd(s.stats.Evictions, other.stats.Evictions)
+	s.combineLabels(other.labels)
+	if s.distinct != nil && other.distinct != nil {
+		s.distinct.merge(other.distinct)
+	} else {
+		// the keys of one of the streams are unknown
+		s.distinct = nil
+	}
+}
+
+// replaceElements replaces the tracked elements with the n largest of elts
+func (s *Stream) replaceElements(elts []Element) {
+	// sort the elements
+	s.k.sort(elts)
 
 	// trim elements
 	if len(elts) > s.n {
@@ -291,35 +1042,104 @@ func (s *Stream) Merge(other *Stream) error {
 
 	// create heap
 	tk := keys{
-		m:    make(map[string]int),
-		elts: make([]Element, 0, s.n),
+		m:      make(map[string]int),
+		elts:   make([]Element, 0, s.n),
+		score:  s.k.score,
+		stable: s.k.stable,
 	}
 	for _, e := range elts {
+		e.Key = s.intern(e.Key)
 		heap.Push(&tk, e)
 	}
 
-	// modify alphas
-	for i, v := range other.alphas {
-		s.alphas[i] += v
-	}
-
 	// replace k
 	s.k = tk
-	return nil
+	s.stale = false
+}
+
+// Total returns the sum of the counts of all inserted elements
+func (s *Stream) Total() int {
+	return s.total
+}
+
+// Len returns the number of tracked elements
+func (s *Stream) Len() int {
+	return len(s.k.elts)
+}
+
+// Cap returns the number of elements the stream tracks at most, its n
+func (s *Stream) Cap() int {
+	return s.n
+}
+
+// FillRatio returns the fraction of the capacity in use. A stream that
+// stays well below 1 is overprovisioned, evictions are tracked by Stats.
+func (s *Stream) FillRatio() float64 {
+	if s.n == 0 {
+		return 0
+	}
+	return float64(len(s.k.elts)) / float64(s.n)
+}
+
+// Inserts returns the number of inserts, each key passed to InsertMany
+// counts as one
+func (s *Stream) Inserts() int {
+	return s.inserts
+}
+
+// Admissions returns the number of times a key started being tracked. A key
+// evicted and admitted again is counted again, so it is an upper bound of
+// the number of distinct keys ever tracked.
+func (s *Stream) Admissions() int {
+	return s.admissions
 }
 
 // Keys returns the current estimates for the most frequent elements
 func (s *Stream) Keys() []Element {
 	elts := append([]Element(nil), s.k.elts...)
-	sort.Sort(elementsByCountDescending(elts))
+	s.k.sort(elts)
 	if len(elts) > s.n {
 		elts = elts[:s.n]
 	}
+	s.reportAll(elts)
 	return elts
 }
 
 // Estimate returns an estimate for the item x
 func (s *Stream) Estimate(x string) Element {
+	x, _ = s.key(x)
+	return s.report(s.estimate(x))
+}
+
+// report returns e as returned to callers. With sampling only the sampled
+// share of the lower bound is guaranteed, the rest of the scaled count is
+// reported as error. Keys shortened by PrefixHashLongKeys are marked.
+func (s *Stream) report(e Element) Element {
+	if s.rng != nil {
+		e.Error = e.Count - int(float64(e.LowerBound())*s.sampleRate)
+	}
+	e.Truncated = s.truncated(e.Key)
+	return e
+}
+
+// reportAll applies report to elts in place
+func (s *Stream) reportAll(elts []Element) {
+	if s.rng == nil && s.keyPolicy != PrefixHashLongKeys {
+		return
+	}
+	for i := range elts {
+		elts[i] = s.report(elts[i])
+	}
+}
+
+// truncated reports whether the tracked key x was shortened by
+// PrefixHashLongKeys, the keys it keeps are never longer than the maximum
+func (s *Stream) truncated(x string) bool {
+	return s.keyPolicy == PrefixHashLongKeys && s.maxKeyLen > 0 && len(x) > s.maxKeyLen
+}
+
+// estimate returns an estimate for the already normalized key x
+func (s *Stream) estimate(x string) Element {
 	xhash := reduce(metro.Hash64Str(x, 0), len(s.alphas))
 
 	// are we tracking this element?
@@ -334,11 +1154,291 @@ func (s *Stream) Estimate(x string) Element {
 		Error: count,
 		Count: count,
 	}
+	if s.tier != nil {
+		if te, ok := s.tier.get(x); ok && te.Count < count {
+			return te
+		}
+	}
 	return e
 }
 
+// Equal reports whether both streams track the same elements with the same
+// counts and share their size, total and alphas. Unlike reflect.DeepEqual it does not
+// depend on the order of the elements in the heap.
+func (s *Stream) Equal(other *Stream) bool {
+	if s.n != other.n || s.total != other.total || len(s.alphas) != len(other.alphas) || len(s.k.elts) != len(other.k.elts) {
+		return false
+	}
+	for i, a := range s.alphas {
+		if other.alphas[i] != a {
+			return false
+		}
+	}
+	for _, e := range s.k.elts {
+		idx, ok := other.k.m[e.Key]
+		if !ok || other.k.elts[idx] != e {
+			return false
+		}
+	}
+	return true
+}
+
+// The encoding starts with encodingMagic and the format version, followed by
+// n, the alphas and the keys. Since version 1 it ends in a map of named
+// fields, decoders skip fields they don't know.
+// The legacy encoding has no header and no fields and starts right with n.
+const (
+	encodingMagic   = "topk"
+	encodingVersion = 1
+)
+
+// The encoding records how keys were hashed so that streams hashing keys
+// differently are never merged
+const (
+	hashName = "metro"
+	hashSeed = 0
+)
+
+// decodeHeader reads the format header and returns the format version, 0 for
+// the legacy encoding
+func decodeHeader(r *msgp.Reader) (int, error) {
+	t, err := r.NextType()
+	if err != nil {
+		return 0, err
+	}
+	if t != msgp.StrType {
+		return 0, nil
+	}
+
+	magic, err := readString(r)
+	if err != nil {
+		return 0, err
+	}
+	if magic != encodingMagic {
+		return 0, fmt.Errorf("%w %q", ErrUnexpectedMagic, magic)
+	}
+	version, err := r.ReadInt()
+	if err != nil {
+		return 0, err
+	}
+	if err := checkVersion(version); err != nil {
+		return 0, err
+	}
+	return version, nil
+}
+
+// checkVersion checks the format version read from an encoding
+func checkVersion(version int) error {
+	if version < 1 || version > encodingVersion {
+		return fmt.Errorf("%w %d", ErrUnsupportedVersion, version)
+	}
+	return nil
+}
+
+// encodeHeader writes the format header of the sketch with the given magic,
+// the other engines frame their encodings like Stream does
+func encodeHeader(w *msgp.Writer, magic string) error {
+	if err := w.WriteString(magic); err != nil {
+		return err
+	}
+	return w.WriteInt(encodingVersion)
+}
+
+// expectHeader reads the format header of an engine's encoding
+func expectHeader(r *msgp.Reader, magic string) error {
+	got, err := readString(r)
+	if err != nil {
+		return err
+	}
+	if got != magic {
+		return fmt.Errorf("%w %q, expected %q", ErrUnexpectedMagic, got, magic)
+	}
+	version, err := r.ReadInt()
+	if err != nil {
+		return err
+	}
+	if err := checkVersion(version); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (s *Stream) encodeFields(w *msgp.Writer) error {
+	fields := uint32(7)
+	if s.distinct != nil {
+		fields++
+	}
+	if s.probabilistic {
+		fields++
+	}
+	if s.replacement != AlphaReplacement {
+		fields++
+	}
+	if s.promotion > 0 {
+		fields++
+	}
+	if len(s.labels) > 0 {
+		fields++
+	}
+	if err := w.WriteMapHeader(fields); err != nil {
+		return err
+	}
+	if err := w.WriteString("total"); err != nil {
+		return err
+	}
+	if err := w.WriteInt(s.total); err != nil {
+		return err
+	}
+	if err := w.WriteString("inserts"); err != nil {
+		return err
+	}
+	if err := w.WriteInt(s.inserts); err != nil {
+		return err
+	}
+	if err := w.WriteString("admissions"); err != nil {
+		return err
+	}
+	if err := w.WriteInt(s.admissions); err != nil {
+		return err
+	}
+	if err := w.WriteString("evictions"); err != nil {
+		return err
+	}
+	if err := w.WriteInt(s.stats.Evictions); err != nil {
+		return err
+	}
+	if err := w.WriteString("partitions"); err != nil {
+		return err
+	}
+	if err := w.WriteInt(1); err != nil {
+		return err
+	}
+	if err := w.WriteString("hash"); err != nil {
+		return err
+	}
+	if err := w.WriteString(hashName); err != nil {
+		return err
+	}
+	if err := w.WriteString("seed"); err != nil {
+		return err
+	}
+	if err := w.WriteUint64(hashSeed); err != nil {
+		return err
+	}
+	if s.distinct != nil {
+		if err := w.WriteString("distinct"); err != nil {
+			return err
+		}
+		if err := w.WriteBytes(s.distinct.regs); err != nil {
+			return err
+		}
+	}
+	if s.probabilistic {
+		if err := w.WriteString("probabilistic"); err != nil {
+			return err
+		}
+		if err := w.WriteBool(true); err != nil {
+			return err
+		}
+	}
+	if s.replacement != AlphaReplacement {
+		if err := w.WriteString("replacement"); err != nil {
+			return err
+		}
+		if err := w.WriteInt(int(s.replacement)); err != nil {
+			return err
+		}
+	}
+	if s.promotion > 0 {
+		if err := w.WriteString("promotion"); err != nil {
+			return err
+		}
+		if err := w.WriteInt(s.promotion); err != nil {
+			return err
+		}
+	}
+	if len(s.labels) > 0 {
+		if err := w.WriteString("labels"); err != nil {
+			return err
+		}
+		if err := s.encodeLabels(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Stream) decodeFields(r *msgp.Reader) error {
+	sz, err := r.ReadMapHeader()
+	if err != nil {
+		return err
+	}
+	for i := uint32(0); i < sz; i++ {
+		field, err := readString(r)
+		if err != nil {
+			return err
+		}
+		switch field {
+		case "total":
+			s.total, err = r.ReadInt()
+		case "inserts":
+			s.inserts, err = r.ReadInt()
+		case "admissions":
+			s.admissions, err = r.ReadInt()
+		case "evictions":
+			s.stats.Evictions, err = r.ReadInt()
+		case "partitions":
+			var p int
+			if p, err = r.ReadInt(); err == nil && p != 1 {
+				return incompatible("expected a single partition, got %d", p)
+			}
+		case "hash":
+			var h string
+			if h, err = readString(r); err == nil {
+				err = checkHash(h, hashSeed)
+			}
+		case "seed":
+			var seed uint64
+			if seed, err = r.ReadUint64(); err == nil {
+				err = checkHash(hashName, seed)
+			}
+		case "promotion":
+			s.promotion, err = r.ReadInt()
+		case "labels":
+			s.labels, err = decodeLabels(r)
+		case "replacement":
+			var p int
+			if p, err = r.ReadInt(); err == nil {
+				s.replacement = ReplacementPolicy(p)
+			}
+		case "probabilistic":
+			s.probabilistic, err = r.ReadBool()
+		case "distinct":
+			var b []byte
+			if b, err = readBytes(r); err == nil {
+				s.distinct, err = decodeHLL(b)
+			}
+		default:
+			err = r.Skip()
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // EncodeMsgp ...
 func (s *Stream) EncodeMsgp(w *msgp.Writer) error {
+	s.Consolidate()
+
+	if err := w.WriteString(encodingMagic); err != nil {
+		return err
+	}
+	if err := w.WriteInt(encodingVersion); err != nil {
+		return err
+	}
+
 	if err := w.WriteInt(s.n); err != nil {
 		return err
 	}
@@ -353,16 +1453,31 @@ func (s *Stream) EncodeMsgp(w *msgp.Writer) error {
 		}
 	}
 
-	return s.k.EncodeMsgp(w)
+	if err := s.k.EncodeMsgp(w); err != nil {
+		return err
+	}
+
+	return s.encodeFields(w)
 }
 
 // DecodeMsgp ...
 func (s *Stream) DecodeMsgp(r *msgp.Reader) error {
+	return s.decodeMsgp(context.Background(), r)
+}
+
+func (s *Stream) decodeMsgp(ctx context.Context, r *msgp.Reader) error {
 	var (
 		err error
 		sz  uint32
 	)
 
+	s.resetDecoded()
+
+	version, err := decodeHeader(r)
+	if err != nil {
+		return err
+	}
+
 	if s.n, err = r.ReadInt(); err != nil {
 		return err
 	}
@@ -370,19 +1485,53 @@ func (s *Stream) DecodeMsgp(r *msgp.Reader) error {
 	if sz, err = r.ReadArrayHeader(); err != nil {
 		return err
 	}
+	hint, err := decodeLen(int(sz))
+	if err != nil {
+		return err
+	}
 
-	s.alphas = make([]int, sz)
-	for i := range s.alphas {
-		if s.alphas[i], err = r.ReadInt(); err != nil {
+	s.alphas = make([]int, 0, hint)
+	for i := 0; i < int(sz); i++ {
+		if err := canceled(ctx, i); err != nil {
 			return err
 		}
+		a, err := r.ReadInt()
+		if err != nil {
+			return err
+		}
+		s.alphas = append(s.alphas, a)
+	}
+
+	if err := s.k.decode(ctx, r); err != nil {
+		return err
+	}
+	if err := s.checkDecoded(); err != nil {
+		return err
+	}
+	s.internKeys()
+	if s.k.score != nil || s.k.stable {
+		// the encoder may have ordered the heap by another score
+		s.k.init()
 	}
 
-	return s.k.DecodeMsp(r)
+	if version == 0 {
+		return nil
+	}
+	return s.decodeFields(r)
 }
 
 // Encode ...
 func (s *Stream) Encode(w io.Writer) error {
+	if s.hooks == nil {
+		return s.encode(w)
+	}
+	cw := &countingWriter{w: w}
+	err := s.encode(cw)
+	s.hooks.OnEncode(cw.n, err)
+	return err
+}
+
+func (s *Stream) encode(w io.Writer) error {
 	wrt := msgp.NewWriter(w)
 	if err := s.EncodeMsgp(wrt); err != nil {
 		return err
@@ -390,8 +1539,66 @@ func (s *Stream) Encode(w io.Writer) error {
 	return wrt.Flush()
 }
 
-// Decode ...
+// Decode replaces s with the stream read from r. A stream created by New
+// only accepts streams of its own size, streams of other sizes or hashing keys
+// differently return ErrIncompatibleSketch.
 func (s *Stream) Decode(r io.Reader) error {
-	rdr := msgp.NewReader(r)
-	return s.DecodeMsgp(rdr)
+	return s.DecodeContext(context.Background(), r)
+}
+
+// DecodeContext is like Decode but gives up with the context's error once
+// ctx is done. s is left unchanged on any error.
+//
+// Corrupt input of any kind returns an error, decoding is safe for
+// encodings from untrusted sources.
+func (s *Stream) DecodeContext(ctx context.Context, r io.Reader) (err error) {
+	// a stream created by New only accepts streams of the same size
+	n, alphas := s.n, len(s.alphas)
+
+	defer func() {
+		// the checks of the decoders should leave nothing to recover from,
+		// this is the last line of defense for untrusted input
+		if r := recover(); r != nil {
+			err = fmt.Errorf("topk: corrupt encoding: %v", r)
+		}
+	}()
+
+	// decode into a copy sharing the options of s, which only replaces s
+	// once the decoded stream is verified
+	d := *s
+	br := bufio.NewReader(r)
+	if isCompact(br) {
+		err = d.decodeCompact(ctx, br)
+	} else {
+		err = d.decodeMsgp(ctx, msgp.NewReader(br))
+	}
+	if err != nil {
+		return err
+	}
+	if n != 0 && d.n != n {
+		return incompatible("expected stream of size n %d, got %d", n, d.n)
+	}
+	if n != 0 && len(d.alphas) != alphas {
+		return incompatible("expected %d alphas, got %d", alphas, len(d.alphas))
+	}
+	*s = d
+	return nil
+}
+
+// resetDecoded resets the state not covered by every encoding before
+// decoding into s
+func (s *Stream) resetDecoded() {
+	// the decoded state replaces whatever a View might still reference
+	s.shared = false
+	s.stale = false
+	s.total, s.inserts, s.admissions = 0, 0, 0
+	s.labels = nil
+	s.probabilistic = false
+	s.replacement = AlphaReplacement
+	s.promotion = 0
+	s.stats.Evictions = 0
+	if s.distinct != nil {
+		s.distinct = newHLL()
+	}
+	s.resetTier()
 }
@@ -144,6 +144,23 @@ func TestTopKMerge(t *testing.T) {
 	}
 }
 
+func TestMergeReceiverAlphas(t *testing.T) {
+	tk1 := New(20)
+	tk2 := New(20)
+	for i := range tk1.alphas {
+		tk1.alphas[i] = 3
+	}
+	tk2.Insert("x", 5)
+
+	// a key only tracked by tk2 may have been seen up to alpha times by tk1
+	if err := tk1.Merge(tk2); err != nil {
+		t.Fatal(err)
+	}
+	if e := tk1.Keys()[0]; e.Key != "x" || e.Count != 8 || e.Error != 3 {
+		t.Errorf("expected x with count 8 and error 3, got %v", e)
+	}
+}
+
 func loadWords() []string {
 	f, _ := os.Open("testdata/words.txt")
 	defer f.Close()
@@ -435,3 +452,315 @@ func TestMarshalUnMarshal(t *testing.T) {
 	assert.EqualValues(t, sketch, tmp)
 
 }
+
+func TestInsertMany(t *testing.T) {
+	words := loadWords()
+
+	tk1 := New(100)
+	for _, w := range words {
+		tk1.Insert(w, 1)
+	}
+
+	tk2 := New(100)
+	tk2.InsertMany(words)
+
+	if !reflect.DeepEqual(tk1, tk2) {
+		t.Error("InsertMany differs from Insert")
+	}
+}
+
+func TestInsertTrackedAllocs(t *testing.T) {
+	tk := New(100)
+	for i := 0; i < 100; i++ {
+		tk.Insert(fmt.Sprintf("word-%d", i), i)
+	}
+
+	allocs := testing.AllocsPerRun(1000, func() {
+		tk.Insert("word-42", 1)
+	})
+	if allocs != 0 {
+		t.Errorf("expected 0 allocs/op for a tracked key, got %v", allocs)
+	}
+}
+
+func BenchmarkInsertTracked(b *testing.B) {
+	tk := New(100)
+	words := make([]string, 100)
+	for i := range words {
+		words[i] = fmt.Sprintf("word-%d", i)
+		tk.Insert(words[i], 1)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		tk.Insert(words[i%len(words)], 1)
+	}
+}
+
+func BenchmarkInsert(b *testing.B) {
+	words := loadWords()
+	tk := New(100)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		tk.Insert(words[i%len(words)], 1)
+	}
+}
+
+func TestEqual(t *testing.T) {
+	slices := split(loadWords(), 2)
+
+	tk1, tk2 := New(50), New(50)
+	for _, w := range slices[0] {
+		tk1.Insert(w, 1)
+	}
+	for _, w := range slices[1] {
+		tk2.Insert(w, 1)
+	}
+	if tk1.Equal(tk2) {
+		t.Error("sketches of different input are equal")
+	}
+
+	m1, m2 := tk1.clone(), tk2.clone()
+	if err := m1.Merge(tk2); err != nil {
+		t.Fatal(err)
+	}
+	if err := m2.Merge(tk1); err != nil {
+		t.Fatal(err)
+	}
+	if !m1.Equal(m2) || !m2.Equal(m1) {
+		t.Error("merge is not commutative")
+	}
+
+	m2.Insert(m2.k.elts[0].Key, 1)
+	if m1.Equal(m2) {
+		t.Error("sketches with different counts are equal")
+	}
+}
+
+func TestSortElements(t *testing.T) {
+	elts := []Element{
+		{Key: "c", Count: 1},
+		{Key: "b", Count: 2},
+		{Key: "a", Count: 2},
+		{Key: "d", Count: 5, Error: 4},
+	}
+	SortElements(elts)
+
+	var got []string
+	for _, e := range elts {
+		got = append(got, e.Key)
+	}
+	if want := []string{"d", "a", "b", "c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if ElementLess(elts[1], elts[0]) || !ElementLess(elts[0], elts[1]) {
+		t.Error("ElementLess disagrees with SortElements")
+	}
+}
+
+func TestCounters(t *testing.T) {
+	s := New(2)
+	s.Insert("a", 5)
+	s.Insert("a", 1)
+	s.Insert("b", 3)
+	// each count exceeds all previous ones, so this evicts b
+	s.Insert("c", 10)
+	s.InsertMany([]string{"a", "c"})
+
+	if s.Total() != 21 || s.Inserts() != 6 || s.Admissions() != 3 {
+		t.Errorf("expected total 21, 6 inserts and 3 admissions, got %d, %d and %d", s.Total(), s.Inserts(), s.Admissions())
+	}
+
+	buf := bytes.NewBuffer(nil)
+	if err := s.Encode(buf); err != nil {
+		t.Fatal(err)
+	}
+	enc := buf.Bytes()
+
+	decoded := New(2)
+	if err := decoded.Decode(bytes.NewReader(enc)); err != nil {
+		t.Fatal(err)
+	}
+	if err := decoded.Merge(s); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Total() != 42 || decoded.Inserts() != 12 || decoded.Admissions() != 6 {
+		t.Errorf("expected merged total 42, 12 inserts and 6 admissions, got %d, %d and %d", decoded.Total(), decoded.Inserts(), decoded.Admissions())
+	}
+
+	merged, err := MergeEncodedAll([]io.Reader{bytes.NewReader(enc), bytes.NewReader(enc)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if merged.Inserts() != 12 || merged.Admissions() != 6 {
+		t.Errorf("expected 12 inserts and 6 admissions, got %d and %d", merged.Inserts(), merged.Admissions())
+	}
+}
+
+func TestInsertDetailed(t *testing.T) {
+	s := New(2,
+		WithFilter(func(x string) bool { return strings.HasPrefix(x, "skip") }, AlphaOnlyFiltered),
+		WithMaxKeyLength(8, RejectLongKeys),
+	)
+	for i, tc := range []struct {
+		key     string
+		count   int
+		outcome InsertOutcome
+	}{
+		{"a", 5, AdmittedFree},
+		{"a", 1, Updated},
+		{"b", 3, AdmittedFree},
+		{"c", 1, Rejected},
+		// exceeds all previous counts
+		{"d", 100, AdmittedReplacing},
+		{"skip-me", 1, Filtered},
+		{"much-too-long", 1, Dropped},
+	} {
+		if _, o := s.InsertDetailed(tc.key, tc.count); o != tc.outcome {
+			t.Errorf("%d: expected %v inserting %s, got %v", i, tc.outcome, tc.key, o)
+		}
+	}
+
+	s = New(2, WithDoorkeeper(1024))
+	if _, o := s.InsertDetailed("a", 1); o != Held {
+		t.Errorf("expected the first occurrence to be held, got %v", o)
+	}
+	if e, o := s.InsertDetailed("a", 1); o != AdmittedFree || e.Count != 2 {
+		t.Errorf("expected the second occurrence to be admitted, got %v %v", e, o)
+	}
+}
+
+func TestCorrect(t *testing.T) {
+	s := New(2)
+	s.Insert("a", 5)
+	s.k.elts[s.k.m["a"]].Error = 2
+	s.Insert("b", 3)
+
+	if e := s.Correct("a", 4); e != (Element{Key: "a", Count: 4}) || s.Estimate("a") != e {
+		t.Errorf("expected a to be corrected to 4, got %v", s.Estimate("a"))
+	}
+	// below the minimum of 3, c stays untracked
+	if e := s.Correct("c", 1); e.Key != "c" || len(s.k.elts) != 2 {
+		t.Errorf("expected c not to be tracked, got %v", s.Keys())
+	}
+	if e := s.Correct("d", 10); s.Estimate("d") != e || e.Count != 10 {
+		t.Errorf("expected d to be seeded with 10, got %v", s.Estimate("d"))
+	}
+	keys := s.Keys()
+	if len(keys) != 2 || keys[0].Key != "d" || keys[1].Key != "a" {
+		t.Errorf("expected d to replace b, got %v", keys)
+	}
+	if e := s.Estimate("b"); e.Count < 3 {
+		t.Errorf("expected b's count to be kept in the alphas, got %v", e)
+	}
+	if s.Total() != 8 {
+		t.Errorf("expected the total to be unchanged, got %d", s.Total())
+	}
+	if err := s.Validate(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestNewFromPrior(t *testing.T) {
+	prior := New(10)
+	prior.Insert("a", 100)
+	prior.Insert("b", 10)
+	prior.Insert("c", 1)
+
+	s := NewFromPrior(prior, 0.5)
+	if s.Total() != 0 {
+		t.Errorf("expected the seeds not to count towards the total, got %d", s.Total())
+	}
+	if e := s.Estimate("a"); e.Count != 50 || e.LowerBound() != 0 {
+		t.Errorf("expected a seeded at 50 without lower bound, got %v", e)
+	}
+	if _, ok := s.k.m["c"]; ok {
+		t.Error("expected c not to be seeded")
+	}
+
+	s.Insert("a", 5)
+	if e := s.Estimate("a"); e.Count != 55 || e.LowerBound() != 5 {
+		t.Errorf("expected count 55 with lower bound 5, got %v", e)
+	}
+	if err := s.Validate(); err != nil {
+		t.Error(err)
+	}
+	if len(NewFromPrior(prior, 0).Keys()) != 0 {
+		t.Error("expected no seeds for a zero scale")
+	}
+}
+
+func TestCapacity(t *testing.T) {
+	s := New(4)
+	if s.Len() != 0 || s.Cap() != 4 || s.FillRatio() != 0 {
+		t.Errorf("unexpected empty capacity %d/%d", s.Len(), s.Cap())
+	}
+	s.Insert("a", 1)
+	s.Insert("b", 1)
+	if s.Len() != 2 || s.FillRatio() != 0.5 {
+		t.Errorf("expected half full, got %d/%d", s.Len(), s.Cap())
+	}
+
+	sh := NewSharded(4, 2)
+	sh.Insert("a", 1)
+	if sh.Len() != 1 || sh.Cap() != 8 || sh.FillRatio() != 0.125 {
+		t.Errorf("unexpected sharded capacity %d/%d", sh.Len(), sh.Cap())
+	}
+}
+
+func TestSaturation(t *testing.T) {
+	for _, c := range []struct{ a, b, want int }{
+		{1, 2, 3},
+		{math.MaxInt, 1, math.MaxInt},
+		{math.MaxInt - 1, math.MaxInt, math.MaxInt},
+		{math.MinInt, -1, math.MinInt},
+		{math.MaxInt, math.MinInt, -1},
+		{5, 0, 5},
+	} {
+		if got := satAdd(c.a, c.b); got != c.want {
+			t.Errorf("satAdd(%d, %d) = %d, want %d", c.a, c.b, got, c.want)
+		}
+	}
+
+	s := New(10)
+	s.Insert("a", math.MaxInt-1)
+	s.Insert("a", 10)
+	if e := s.Estimate("a"); e.Count != math.MaxInt {
+		t.Errorf("expected a saturated count, got %v", e)
+	}
+	for i := 0; i < 3; i++ {
+		if err := s.Merge(s.clone()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if e := s.Estimate("a"); e.Count != math.MaxInt || s.Total() != math.MaxInt {
+		t.Errorf("expected saturated counts after merging, got %v and total %d", e, s.Total())
+	}
+	if err := s.MergeWeighted(s.clone(), 2); err != nil {
+		t.Fatal(err)
+	}
+	if e := s.Estimate("a"); e.Count != math.MaxInt {
+		t.Errorf("expected a saturated count after a weighted merge, got %v", e)
+	}
+}
+
+func TestElementBounds(t *testing.T) {
+	e := Element{Key: "a", Count: 10, Error: 3}
+	if e.LowerBound() != 7 || e.UpperBound() != 10 {
+		t.Errorf("expected bounds [7, 10], got [%d, %d]", e.LowerBound(), e.UpperBound())
+	}
+
+	words := loadWords()
+	s := New(20)
+	for _, w := range words {
+		s.Insert(w, 1)
+	}
+	for w, c := range exactCount(words) {
+		if e := s.Estimate(w); e.LowerBound() > c || e.UpperBound() < c {
+			t.Errorf("expected %d of %q within [%d, %d]", c, w, e.LowerBound(), e.UpperBound())
+		}
+	}
+}
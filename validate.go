@@ -0,0 +1,157 @@
+package topk
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/tinylib/msgp/msgp"
+)
+
+var (
+	// ErrInvalidCount is returned by InsertChecked for counts that are not
+	// positive, or negative without WithDecrements
+	ErrInvalidCount = errors.New("topk: invalid count")
+	// ErrNilSketch is returned when merging a nil sketch
+	ErrNilSketch = errors.New("topk: nil sketch")
+	// ErrNoSketches is returned when merging an empty set of sketches
+	ErrNoSketches = errors.New("topk: no sketches to merge")
+	// ErrUnexpectedMagic is returned when decoding an encoding that doesn't
+	// start with the magic of the expected format
+	ErrUnexpectedMagic = errors.New("topk: unexpected magic")
+	// ErrUnsupportedVersion is returned when decoding an encoding written by
+	// an unknown version of the format
+	ErrUnsupportedVersion = errors.New("topk: unsupported encoding version")
+)
+
+// maxDecodeLen bounds the sizes read from encodings
+const maxDecodeLen = 1 << 28
+
+// decodeLen checks a size read from an encoding and returns the capacity to
+// preallocate for it. Slices and maps grow beyond it as their elements are
+// actually read, so a corrupt size can't allocate more than the input holds.
+func decodeLen(sz int) (int, error) {
+	if sz < 0 || sz > maxDecodeLen {
+		return 0, fmt.Errorf("topk: corrupt encoding: size %d out of range", sz)
+	}
+	return min(sz, 1<<12), nil
+}
+
+// readN reads the next sz bytes of r
+func readN(r io.Reader, sz int) ([]byte, error) {
+	hint, err := decodeLen(sz)
+	if err != nil {
+		return nil, err
+	}
+	if sz == hint {
+		b := make([]byte, sz)
+		_, err := io.ReadFull(r, b)
+		return b, unexpected(err)
+	}
+	// grow with the data actually read
+	buf := bytes.NewBuffer(make([]byte, 0, hint))
+	if _, err := io.CopyN(buf, r, int64(sz)); err != nil {
+		return nil, unexpected(err)
+	}
+	return buf.Bytes(), nil
+}
+
+// readString is like ReadString of r, which allocates the encoded length
+// before reading the string
+func readString(r *msgp.Reader) (string, error) {
+	sz, err := r.ReadStringHeader()
+	if err != nil {
+		return "", err
+	}
+	b, err := readN(r, int(sz))
+	return string(b), err
+}
+
+// readBytes is like ReadBytes of r, which allocates the encoded length
+// before reading the bytes
+func readBytes(r *msgp.Reader) ([]byte, error) {
+	sz, err := r.ReadBytesHeader()
+	if err != nil {
+		return nil, err
+	}
+	return readN(r, int(sz))
+}
+
+// checkDecoded checks the consistency of a decoded stream before it is used
+func (s *Stream) checkDecoded() error {
+	if s.n < 0 || s.n > maxDecodeLen {
+		return fmt.Errorf("topk: corrupt encoding: size n %d out of range", s.n)
+	}
+	// a stream has 6 alphas per element it can track, allocations by n are
+	// bounded by the alphas actually read
+	if len(s.alphas) < s.n || len(s.alphas) == 0 && len(s.k.elts) > 0 {
+		return fmt.Errorf("topk: corrupt encoding: %d alphas for size n %d", len(s.alphas), s.n)
+	}
+	if len(s.k.m) != len(s.k.elts) {
+		return fmt.Errorf("topk: corrupt encoding: index has %d keys for %d elements", len(s.k.m), len(s.k.elts))
+	}
+	for i, e := range s.k.elts {
+		if idx, ok := s.k.m[e.Key]; !ok || idx != i {
+			return fmt.Errorf("topk: corrupt encoding: element %d (%q) indexed at %d", i, e.Key, idx)
+		}
+	}
+	return nil
+}
+
+// InsertChecked is like Insert but returns ErrInvalidCount instead of
+// ignoring or tracking counts that don't change the stream, a zero count or
+// a negative one without WithDecrements.
+func (s *Stream) InsertChecked(x string, count int) (Element, error) {
+	if count == 0 || count < 0 && !s.decrements {
+		return Element{}, fmt.Errorf("%w %d for %q", ErrInvalidCount, count, x)
+	}
+	return s.Insert(x, count), nil
+}
+
+// Validate checks the internal invariants of the stream: the heap ordering,
+// the consistency of the key index with the elements, and the sign of all
+// counters. It is meant to vet sketches decoded from untrusted sources.
+func (s *Stream) Validate() error {
+	if s.n < 0 {
+		return fmt.Errorf("topk: negative size n %d", s.n)
+	}
+	if len(s.k.elts) > s.n {
+		return fmt.Errorf("topk: %d elements exceed size n %d", len(s.k.elts), s.n)
+	}
+	if len(s.alphas) == 0 && (s.n > 0 || len(s.k.elts) > 0) {
+		return fmt.Errorf("topk: no alphas")
+	}
+	if len(s.k.m) != len(s.k.elts) {
+		return fmt.Errorf("topk: index has %d keys for %d elements", len(s.k.m), len(s.k.elts))
+	}
+
+	for i, e := range s.k.elts {
+		if idx, ok := s.k.m[e.Key]; !ok || idx != i {
+			return fmt.Errorf("topk: element %d (%q) indexed at %d", i, e.Key, idx)
+		}
+		if e.Count < 0 || e.Error < 0 {
+			return fmt.Errorf("topk: element %q has negative count %d or error %d", e.Key, e.Count, e.Error)
+		}
+		if e.Error > e.Count {
+			return fmt.Errorf("topk: element %q has error %d larger than count %d", e.Key, e.Error, e.Count)
+		}
+
+		// with WithDeferredHeap the ordering is only restored on demand
+		if s.stale {
+			continue
+		}
+		for _, j := range []int{2*i + 1, 2*i + 2} {
+			if j < len(s.k.elts) && s.k.Less(j, i) {
+				return fmt.Errorf("topk: heap property violated between %d and %d", i, j)
+			}
+		}
+	}
+
+	for i, a := range s.alphas {
+		if a < 0 {
+			return fmt.Errorf("topk: alpha %d is negative: %d", i, a)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,247 @@
+package topk
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/tinylib/msgp/msgp"
+)
+
+func TestValidate(t *testing.T) {
+	sketch := New(100)
+	for _, w := range loadWords() {
+		sketch.Insert(w, 1)
+	}
+	if err := sketch.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := bytes.NewBuffer(nil)
+	if err := sketch.Encode(buf); err != nil {
+		t.Fatal(err)
+	}
+	decoded := &Stream{}
+	if err := decoded.Decode(buf); err != nil {
+		t.Fatal(err)
+	}
+	if err := decoded.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	corrupt := []func(s *Stream){
+		func(s *Stream) { s.k.elts[0], s.k.elts[1] = s.k.elts[1], s.k.elts[0] },
+		func(s *Stream) { s.k.m[s.k.elts[0].Key] = 3 },
+		func(s *Stream) { delete(s.k.m, s.k.elts[5].Key) },
+		func(s *Stream) { s.k.elts[7].Error = s.k.elts[7].Count + 1 },
+		func(s *Stream) { s.alphas[2] = -1 },
+		func(s *Stream) { s.n = 10 },
+	}
+	for i, f := range corrupt {
+		s := sketch.clone()
+		f(s)
+		if err := s.Validate(); err == nil || !strings.HasPrefix(err.Error(), "topk: ") {
+			t.Errorf("corruption %d not detected: %v", i, err)
+		}
+	}
+}
+
+func TestDecodeHeader(t *testing.T) {
+	encode := func(magic string, version int) []byte {
+		buf := bytes.NewBuffer(nil)
+		w := msgp.NewWriter(buf)
+		if err := encodeHeader(w, magic); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.WriteInt(version); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+
+	for _, tc := range []struct {
+		data []byte
+		want error
+	}{
+		{encode("nope", encodingVersion), ErrUnexpectedMagic},
+		{append(encode(encodingMagic, encodingVersion)[:len(encodingMagic)+1], 0x7f), ErrUnsupportedVersion},
+		{[]byte{compactMagic, encodingVersion + 1, 0, 10}, ErrUnsupportedVersion},
+	} {
+		if err := New(10).Decode(bytes.NewReader(tc.data)); !errors.Is(err, tc.want) {
+			t.Errorf("decoding %x: expected %v, got %v", tc.data, tc.want, err)
+		}
+	}
+	if _, err := NewBytesView([]byte{compactMagic + 1, encodingVersion, 0, 10}); !errors.Is(err, ErrUnexpectedMagic) {
+		t.Errorf("expected ErrUnexpectedMagic, got %v", err)
+	}
+	if err := NewMisraGries(10).Decode(bytes.NewReader(encode(encodingMagic, encodingVersion))); !errors.Is(err, ErrUnexpectedMagic) {
+		t.Errorf("expected ErrUnexpectedMagic, got %v", err)
+	}
+}
+
+func TestInvalidOperations(t *testing.T) {
+	s := New(10)
+	for _, count := range []int{0, -1} {
+		if _, err := s.InsertChecked("a", count); !errors.Is(err, ErrInvalidCount) {
+			t.Errorf("count %d: expected ErrInvalidCount, got %v", count, err)
+		}
+	}
+	if s.Len() != 0 || s.Total() != 0 {
+		t.Error("invalid inserts changed the stream")
+	}
+	if e, err := s.InsertChecked("a", 2); err != nil || e.Count != 2 {
+		t.Errorf("expected count 2, got %v %v", e, err)
+	}
+	if _, err := New(10, WithDecrements()).InsertChecked("a", -1); err != nil {
+		t.Errorf("expected decrements to be valid, got %v", err)
+	}
+
+	var nilStream *Stream
+	if err := s.Merge(nilStream); !errors.Is(err, ErrNilSketch) {
+		t.Errorf("expected ErrNilSketch, got %v", err)
+	}
+	if err := s.Merge(nil); !errors.Is(err, ErrNilSketch) {
+		t.Errorf("expected ErrNilSketch, got %v", err)
+	}
+	if err := s.MergeSketch(nil); !errors.Is(err, ErrNilSketch) {
+		t.Errorf("expected ErrNilSketch, got %v", err)
+	}
+	if err := s.MergeSketch(NewMisraGries(10)); !errors.Is(err, ErrIncompatibleSketch) {
+		t.Errorf("expected ErrIncompatibleSketch, got %v", err)
+	}
+	if err := s.MergeWeighted(nil, 1); !errors.Is(err, ErrNilSketch) {
+		t.Errorf("expected ErrNilSketch, got %v", err)
+	}
+	if err := s.MergeAbove(nil, 1); !errors.Is(err, ErrNilSketch) {
+		t.Errorf("expected ErrNilSketch, got %v", err)
+	}
+}
+
+// encodings returns valid encodings of a small stream in both formats
+func encodings(tb testing.TB) [][]byte {
+	s := New(5)
+	for i, w := range []string{"a", "b", "c", "d", "e", "f", "g", "a", "b", "a"} {
+		s.Insert(w, i+1)
+	}
+	var enc, compact bytes.Buffer
+	if err := s.Encode(&enc); err != nil {
+		tb.Fatal(err)
+	}
+	if err := s.EncodeCompact(&compact); err != nil {
+		tb.Fatal(err)
+	}
+	return [][]byte{enc.Bytes(), compact.Bytes()}
+}
+
+func TestDecodeCorrupt(t *testing.T) {
+	for _, b := range encodings(t) {
+		for i := 0; i < len(b); i++ {
+			if err := new(Stream).Decode(bytes.NewReader(b[:i])); err == nil {
+				t.Errorf("truncation at %d of %d decoded", i, len(b))
+			}
+			for _, x := range []byte{0x00, 0xff, b[i] ^ 0x80} {
+				c := append([]byte(nil), b...)
+				c[i] = x
+				// corruptions may decode, but must not panic
+				new(Stream).Decode(bytes.NewReader(c))
+				MergeEncodedAll([]io.Reader{bytes.NewReader(c)})
+			}
+		}
+	}
+
+	// sizes far beyond the input fail instead of allocating
+	var buf bytes.Buffer
+	w := msgp.NewWriter(&buf)
+	encodeHeader(w, encodingMagic)
+	w.WriteInt(10)
+	w.WriteArrayHeader(1 << 31)
+	w.Flush()
+	if _, err := MergeEncodedAll([]io.Reader{bytes.NewReader(buf.Bytes())}); err == nil {
+		t.Error("expected MergeEncodedAll to fail for a huge alphas header")
+	}
+	if err := new(Stream).Decode(&buf); err == nil {
+		t.Error("expected an error for a huge alphas header")
+	}
+	compact := []byte{compactMagic, encodingVersion, 0, 10, 0xff, 0xff, 0xff, 0xff, 0x0f}
+	if err := new(Stream).Decode(bytes.NewReader(compact)); err == nil {
+		t.Error("expected an error for a huge compact alphas header")
+	}
+}
+
+func TestDecodeCorruptSketches(t *testing.T) {
+	engines := map[string]func() Sketch{
+		"sticky": func() Sketch { return newSticky(t, 0.05, 0.005, 0.01) },
+		"lossy":  func() Sketch { return newLossy(t, 0.005) },
+		"mg":     func() Sketch { return NewMisraGries(100) },
+		"cms":    func() Sketch { return newCountMin(t, 20, 100, 4) },
+	}
+	for name, newSketch := range engines {
+		s := newSketch()
+		for i, w := range loadWords()[:150] {
+			s.Insert(w, 1+i%3)
+		}
+		var buf bytes.Buffer
+		if err := s.Encode(&buf); err != nil {
+			t.Fatal(err)
+		}
+		b := buf.Bytes()
+		keys := s.Keys()
+
+		for i := 0; i < len(b); i++ {
+			if err := s.Decode(bytes.NewReader(b[:i])); err == nil {
+				t.Errorf("%s: truncation at %d of %d decoded", name, i, len(b))
+			}
+			if got := s.Keys(); !reflect.DeepEqual(got, keys) {
+				t.Fatalf("%s: truncation at %d of %d changed the sketch", name, i, len(b))
+			}
+			for _, x := range []byte{0x00, 0xff, b[i] ^ 0x80} {
+				c := append([]byte(nil), b...)
+				c[i] = x
+				// corruptions may decode, but must not panic
+				d := newSketch()
+				if d.Decode(bytes.NewReader(c)) == nil {
+					d.Keys()
+					d.Estimate("a")
+				}
+			}
+		}
+	}
+
+	// dimensions that can't be allocated or indexed fail
+	for _, dims := range [][3]int{{-1, 10, 4}, {10, 0, 4}, {10, -5, 4}, {10, 1 << 40, 1 << 40}, {10, 1 << 20, 1 << 20}} {
+		var buf bytes.Buffer
+		w := msgp.NewWriter(&buf)
+		encodeHeader(w, countMinMagic)
+		for _, v := range []int{dims[0], dims[1], dims[2], 0} {
+			w.WriteInt(v)
+		}
+		w.WriteArrayHeader(uint32(dims[1] * dims[2]))
+		w.Flush()
+		if err := newCountMin(t, 10, 10, 4).Decode(&buf); err == nil {
+			t.Errorf("expected an error for count-min dimensions %v", dims)
+		}
+	}
+}
+
+func FuzzDecode(f *testing.F) {
+	for _, b := range encodings(f) {
+		f.Add(b)
+	}
+	f.Fuzz(func(t *testing.T, b []byte) {
+		s := new(Stream)
+		if err := s.Decode(bytes.NewReader(b)); err != nil || s.Cap() == 0 {
+			// like New(0), a stream of size 0 can't be queried
+			return
+		}
+		// whatever decoded has to be usable
+		s.Keys()
+		s.Estimate("a")
+		s.Insert("a", 1)
+	})
+}
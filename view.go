@@ -0,0 +1,35 @@
+package topk
+
+import "io"
+
+// View is an immutable snapshot of a Stream. It supports all queries but no
+// modifications and is safe to share between goroutines without any
+// synchronization.
+type View struct {
+	s Stream
+}
+
+// Freeze returns an immutable View of the stream's current state.
+//
+// Creating a View is cheap: it shares the stream's state, and the stream
+// only copies it on its next modification.
+func (s *Stream) Freeze() *View {
+	s.Consolidate()
+	s.shared = true
+	return &View{s: *s}
+}
+
+// Keys returns the estimates for the most frequent elements
+func (v *View) Keys() []Element {
+	return v.s.Keys()
+}
+
+// Estimate returns an estimate for the item x
+func (v *View) Estimate(x string) Element {
+	return v.s.Estimate(x)
+}
+
+// Encode ...
+func (v *View) Encode(w io.Writer) error {
+	return v.s.Encode(w)
+}
@@ -0,0 +1,43 @@
+package topk
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestFreeze(t *testing.T) {
+	s := New(10)
+	for i := 0; i < 100; i++ {
+		s.Insert("a", 1)
+		s.Insert("b", 2)
+	}
+
+	v := s.Freeze()
+	keys := v.Keys()
+
+	s.Insert("a", 1000)
+	s.Insert("c", 1)
+
+	if !reflect.DeepEqual(keys, v.Keys()) {
+		t.Errorf("view changed after insert: %v != %v", keys, v.Keys())
+	}
+	if e := v.Estimate("a"); e.Count != 100 {
+		t.Errorf("expected frozen count 100, got %d", e.Count)
+	}
+	if e := s.Estimate("a"); e.Count != 1100 {
+		t.Errorf("expected live count 1100, got %d", e.Count)
+	}
+
+	buf := bytes.NewBuffer(nil)
+	if err := v.Encode(buf); err != nil {
+		t.Fatal(err)
+	}
+	decoded := &Stream{}
+	if err := decoded.Decode(buf); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(keys, decoded.Keys()) {
+		t.Errorf("decoded view differs: %v != %v", keys, decoded.Keys())
+	}
+}
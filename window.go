@@ -0,0 +1,147 @@
+package topk
+
+import "time"
+
+// windowPerSize is the number of buckets of the same size kept before the
+// two oldest of them are merged
+const windowPerSize = 2
+
+// Window estimates the top n elements over a sliding window of time, e.g.
+// the last hour, using an exponential histogram of sub-sketches: inserts go
+// to a sketch covering the current granularity, which is sealed once that
+// time has passed. Whenever more than two sealed sketches cover the same
+// length of time the two oldest of them are merged, so a window of any
+// length only needs a number of sketches logarithmic in span/granularity.
+//
+// On top of the error of the merged sketches the window may count inserts up
+// to the age of its oldest sketch: that sketch is only dropped once it
+// entirely left the window. Its share of the window is at most about
+// 1/windowPerSize, use Span to know the time actually covered.
+type Window struct {
+	n           int
+	span        time.Duration
+	granularity time.Duration
+	clock       Clock
+
+	cur      *Stream
+	curStart time.Time
+	buckets  []windowBucket // newest first
+}
+
+type windowBucket struct {
+	s          *Stream
+	start, end time.Time
+	size       int // number of granularities merged into s
+}
+
+// NewWindow returns a Window estimating the top n elements over the last
+// span, inserts are grouped by granularity
+func NewWindow(n int, span, granularity time.Duration, clock Clock) *Window {
+	if clock == nil {
+		clock = SystemClock{}
+	}
+	return &Window{
+		n:           n,
+		span:        span,
+		granularity: granularity,
+		clock:       clock,
+		cur:         New(n),
+		curStart:    clock.Now().Truncate(granularity),
+	}
+}
+
+// Insert adds an element to the current sketch of the window.
+// It returns the estimation of the element in the current sketch
+func (w *Window) Insert(x string, count int) Element {
+	w.advance()
+	return w.cur.Insert(x, count)
+}
+
+// advance seals the current sketch once its granularity has passed and drops
+// the sketches that left the window
+func (w *Window) advance() {
+	now := w.clock.Now()
+	if end := w.curStart.Add(w.granularity); !now.Before(end) {
+		if w.cur.total > 0 {
+			w.seal(windowBucket{s: w.cur, start: w.curStart, end: end, size: 1})
+			w.cur = New(w.n)
+		}
+		w.curStart = now.Truncate(w.granularity)
+	}
+
+	oldest := now.Add(-w.span)
+	for len(w.buckets) > 0 && !w.buckets[len(w.buckets)-1].end.After(oldest) {
+		w.buckets = w.buckets[:len(w.buckets)-1]
+	}
+}
+
+// seal adds b as the newest bucket and merges buckets of the same size
+func (w *Window) seal(b windowBucket) {
+	w.buckets = append([]windowBucket{b}, w.buckets...)
+
+	// the sizes grow from the newest to the oldest bucket
+	for i := 0; i < len(w.buckets); {
+		size := w.buckets[i].size
+		j := i
+		for j < len(w.buckets) && w.buckets[j].size == size {
+			j++
+		}
+		if j-i <= windowPerSize {
+			i = j
+			continue
+		}
+
+		// merge the two oldest of this size into the older one
+		newer, older := w.buckets[j-2], w.buckets[j-1]
+		merged := older.s.clone()
+		_ = merged.Merge(newer.s) // same n
+		w.buckets[j-1] = windowBucket{s: merged, start: older.start, end: newer.end, size: 2 * size}
+		w.buckets = append(w.buckets[:j-2], w.buckets[j-1:]...)
+		i = j - 2
+	}
+}
+
+// merged returns a sketch of the whole window
+func (w *Window) merged() *Stream {
+	w.advance()
+	s := w.cur.clone()
+	for _, b := range w.buckets {
+		_ = s.Merge(b.s) // same n
+	}
+	return s
+}
+
+// Keys returns the estimates for the most frequent elements in the window
+func (w *Window) Keys() []Element {
+	return w.merged().Keys()
+}
+
+// Estimate returns an estimate for the item x in the window
+func (w *Window) Estimate(x string) Element {
+	return w.merged().Estimate(x)
+}
+
+// Span returns the time covered by the window's sketches, which may exceed
+// the window's span by up to the age of the oldest sketch
+func (w *Window) Span() time.Duration {
+	start := w.curStart
+	if len(w.buckets) > 0 {
+		start = w.buckets[len(w.buckets)-1].start
+	}
+	return w.clock.Now().Sub(start)
+}
+
+// Sketches returns the number of sketches the window is made of
+func (w *Window) Sketches() int {
+	return len(w.buckets) + 1
+}
+
+// MemoryUsage returns an estimate of the bytes held by the window's sketches,
+// see Stream.MemoryUsage
+func (w *Window) MemoryUsage() int {
+	size := w.cur.MemoryUsage()
+	for _, b := range w.buckets {
+		size += b.s.MemoryUsage()
+	}
+	return size
+}
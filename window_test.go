@@ -0,0 +1,47 @@
+package topk
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWindow(t *testing.T) {
+	clock := NewFakeClock(time.Unix(0, 0))
+	w := NewWindow(10, 64*time.Second, time.Second, clock)
+
+	w.Insert("old", 1000)
+	for i := 0; i < 200; i++ {
+		clock.Advance(time.Second)
+		w.Insert("new", 1)
+		if i%10 == 0 {
+			w.Insert("old", 1)
+		}
+	}
+
+	keys := w.Keys()
+	if len(keys) != 2 || keys[0].Key != "new" {
+		t.Fatalf("expected new on top, got %v", keys)
+	}
+	// the window covers 64s, the oldest sketch may add up to half of that
+	if e := w.Estimate("new"); e.Count < 64 || e.Count > 64+32 {
+		t.Errorf("expected about 64 for new, got %v", e)
+	}
+	if e := w.Estimate("old"); e.Count > 10 {
+		t.Errorf("expected the initial burst of old to have left the window, got %v", e)
+	}
+	if span := w.Span(); span < 64*time.Second || span > 96*time.Second {
+		t.Errorf("expected a span between 64s and 96s, got %v", span)
+	}
+	// 2 sketches for each of the sizes 1, 2, 4, ... 32, plus the current one
+	if n := w.Sketches(); n > 14 {
+		t.Errorf("expected at most 14 sketches, got %d", n)
+	}
+	if size := w.MemoryUsage(); size < w.Sketches()*New(10).MemoryUsage() {
+		t.Errorf("expected the memory of %d sketches, got %d bytes", w.Sketches(), size)
+	}
+
+	clock.Advance(time.Hour)
+	if keys := w.Keys(); len(keys) != 0 {
+		t.Errorf("expected an empty window, got %v", keys)
+	}
+}